@@ -7,13 +7,21 @@ package cache
 
 import (
   "fmt"
+  "container/heap"
   "container/list"
+  "hash/fnv"
   "sync"
+  "sync/atomic"
   "time"
   "P2-f12/official/lsplog"
   "P2-f12/official/storageproto"
 )
 
+const (
+  NUM_SHARDS = 16                           // lock shards per cache
+  REAP_INTERVAL = 500 * time.Millisecond    // how often the reaper wakes up
+)
+
 /** 
  *  @brief cache entry 
  */
@@ -24,71 +32,199 @@ type Entry struct {
 
   Queries *list.List
   Data interface{}
+  Version uint64  // storage version of Data
+  Decoded map[string]interface{}  // Data decoded by typed readers, by slot
+  Hits int  // reads served under the current lease
+
+  key string
+  deadline time.Time  // next time the reaper has to look at this entry
+  index int           // position in the shard's expiry heap, -1 if absent
+}
+
+/**
+ *  @brief cached marker for a key the storage server reported as missing
+ */
+type NotFound struct{}
+
+/**
+ *  @brief min-heap of entries ordered by deadline
+ */
+type expiryHeap []*Entry
+
+func (h expiryHeap) Len() int {
+  return len(h)
+}
+
+func (h expiryHeap) Less(i, j int) bool {
+  return h[i].deadline.Before(h[j].deadline)
+}
+
+func (h expiryHeap) Swap(i, j int) {
+  h[i], h[j] = h[j], h[i]
+  h[i].index = i
+  h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+  var entry *Entry = x.(*Entry)
+
+  entry.index = len(*h)
+  *h = append(*h, entry)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+  var old expiryHeap = *h
+  var entry *Entry = old[len(old) - 1]
+
+  old[len(old) - 1] = nil
+  entry.index = -1
+  *h = old[:len(old) - 1]
+
+  return entry
+}
+
+/**
+ *  @brief one lock shard of the cache
+ */
+type Shard struct {
+  Map map[string]*Entry
+  Lock sync.Mutex
+
+  expiry expiryHeap
+}
+
+/**
+ *  @brief cache counters, updated atomically
+ */
+type Stats struct {
+  Hits uint64
+  Misses uint64
+  Expirations uint64  // leases that ran out before being revoked
+  Evictions uint64    // entries dropped for lack of recent queries
 }
 
 /** 
  *  @brief cache 
  */
 type Cache struct {
-  Map map[string]*Entry
-  Lock sync.Mutex
+  Shards []*Shard
+
+  stats Stats
+
+  quit chan bool
+  stopOnce sync.Once
 }
 
-/**@brief create a cache 
+/**@brief create a cache and start its background reaper
  * @param void 
  * @return *Cache 
  */
 func NewCache() *Cache {
   var cache Cache
 
-  cache.Map = make(map[string]*Entry)
+  cache.Shards = make([]*Shard, NUM_SHARDS)
+  for i := 0; i < NUM_SHARDS; i++ {
+    cache.Shards[i] = &Shard{Map: make(map[string]*Entry)}
+  }
+
+  cache.quit = make(chan bool)
+  go cache.reaper()
 
   return &cache
 }
 
-/**@brief Get is the most important function for cache, it will first clear all 
- *        the expire entries, and then fetch the cache content or add the count 
- *        of wantlease flag
+/**@brief stop the background reaper, the cache is still usable but
+ *        expired entries are only detected lazily afterwards
+ * @param void
+ * @return void
+ */
+func (cache *Cache) Stop() {
+  cache.stopOnce.Do(func() { close(cache.quit) })
+}
+
+/**@brief snapshot of the cache counters
+ * @param void
+ * @return Stats
+ */
+func (cache *Cache) Stats() Stats {
+  var stats Stats
+
+  stats.Hits = atomic.LoadUint64(&cache.stats.Hits)
+  stats.Misses = atomic.LoadUint64(&cache.stats.Misses)
+  stats.Expirations = atomic.LoadUint64(&cache.stats.Expirations)
+  stats.Evictions = atomic.LoadUint64(&cache.stats.Evictions)
+
+  return stats
+}
+
+/**@brief pick the shard responsible for a key
  * @param key 
+ * @return *Shard
+ */
+func (cache *Cache) shard(key string) *Shard {
+  var hasher = fnv.New32()
+
+  hasher.Write([]byte(key))
+  return cache.Shards[hasher.Sum32() % uint32(len(cache.Shards))]
+}
+
+/**@brief Get is the most important function for cache, it will fetch the
+ *        cache content or add the count of wantlease flag. Expired entries
+ *        are removed by the background reaper, Get only checks the lease of
+ *        the entry it looks at. Data older than minVersion counts as a miss.
+ * @param key
+ * @param minVersion
  * @param GetArgs
  * @return interface{}(string or []string)
+ * @return uint64 storage version of the data
  * @return error 
  */
-func (cache *Cache) Get(
-    key string, args *storageproto.GetArgs) (interface{}, error) {
+func (cache *Cache) Get(key string, minVersion uint64,
+    args *storageproto.GetArgs) (interface{}, uint64, error) {
+  var sh *Shard = cache.shard(key)
   var entry *Entry
   var valid bool
   var data interface{}
+  var version uint64
 
   //fmt.Printf("Cache get: %s\n", key)
 
-  cache.Lock.Lock()
-  cache.ClearExpired()
+  sh.Lock.Lock()
 
-  entry, valid = cache.Map[key]
+  entry, valid = sh.Map[key]
   if !valid {
     entry = new(Entry)
+    entry.key = key
+    entry.index = -1
     entry.Queries = list.New()
     entry.Queries.PushBack(time.Now())
 
     //fmt.Printf("Cache entry: %+v\n", *entry)
     //fmt.Printf("Queries: %+v\n", entry.Queries)
-    cache.Map[key] = entry
+    sh.Map[key] = entry
+    sh.schedule(entry)
 
-    cache.Lock.Unlock()
-    return "", lsplog.MakeErr("Not found.")
+    sh.Lock.Unlock()
+    atomic.AddUint64(&cache.stats.Misses, 1)
+    return "", 0, lsplog.MakeErr("Not found.")
   }
 
-  if entry.Granted {
+  if entry.Granted && !entry.Expired() && entry.Version >= minVersion {
     data = entry.Data
-    cache.Lock.Unlock()
+    version = entry.Version
+    entry.Hits++
+    sh.Lock.Unlock()
+    atomic.AddUint64(&cache.stats.Hits, 1)
 
     //fmt.Printf("Already in Cache %s->%v\n", key, data)
 
-    return data, nil
+    return data, version, nil
   }
 
+  entry.Granted = false
+  entry.Clean()
   entry.Queries.PushBack(time.Now())
+  sh.schedule(entry)
 
   //fmt.Printf("Cache entry: %v\n", *entry)
 
@@ -97,9 +233,10 @@ func (cache *Cache) Get(
     args.WantLease = true
   }
 
-  cache.Lock.Unlock()
+  sh.Lock.Unlock()
+  atomic.AddUint64(&cache.stats.Misses, 1)
 
-  return "", lsplog.MakeErr("Not in cache")
+  return "", 0, lsplog.MakeErr("Not in cache")
 }
 
 /**@brief delete entries older than the query threshhold 
@@ -124,31 +261,126 @@ func (ent *Entry) Clean() {
 
 }
 
-/**@brief delete expire entries 
+/**@brief check whether the lease of an entry has run out
  * @param void 
+ * @return bool
+ */
+func (ent *Entry) Expired() bool {
+  return time.Since(ent.LeaseTime) > ent.LeaseDur
+}
+
+/**@brief compute the next time the reaper has to visit this entry, that is
+ *        the lease expiry or the moment the oldest query falls out of the
+ *        tracking window, whichever comes first
+ * @param void
+ * @return time.Time
+ * @return bool false if the entry can be dropped right away
+ */
+func (ent *Entry) nextDeadline() (time.Time, bool) {
+  var when time.Time
+  var found bool
+
+  if ent.Granted {
+    when = ent.LeaseTime.Add(ent.LeaseDur)
+    found = true
+  }
+
+  if ent.Queries.Len() > 0 {
+    oldest := ent.Queries.Front().Value.(time.Time).Add(
+        time.Duration(storageproto.QUERY_CACHE_SECONDS) * time.Second)
+    if !found || oldest.Before(when) {
+      when = oldest
+    }
+    found = true
+  }
+
+  return when, found
+}
+
+/**@brief (re)insert an entry into the expiry heap, shard lock must be held
+ * @param *Entry
+ * @return bool true if the entry was dropped from the shard instead
+ */
+func (sh *Shard) schedule(entry *Entry) bool {
+  var when time.Time
+  var found bool
+
+  when, found = entry.nextDeadline()
+  if !found {
+    if entry.index >= 0 {
+      heap.Remove(&sh.expiry, entry.index)
+    }
+    delete(sh.Map, entry.key)
+    return true
+  }
+
+  entry.deadline = when
+  if entry.index >= 0 {
+    heap.Fix(&sh.expiry, entry.index)
+  } else {
+    heap.Push(&sh.expiry, entry)
+  }
+
+  return false
+}
+
+/**@brief pop every entry whose deadline has passed and expire its lease,
+ *        old queries, or the entry itself
+ * @param *Stats counters to update
  * @return void 
  */
-func (cache *Cache) ClearExpired() {
-  var key string
+func (sh *Shard) reap(stats *Stats) {
   var entry *Entry
-  var dur time.Duration
+  var now time.Time
 
-  for key = range cache.Map {
-    entry = cache.Map[key]
+  sh.Lock.Lock()
 
-    if entry.Granted {
-      dur = time.Since(entry.LeaseTime)
-      if dur > entry.LeaseDur {
-        fmt.Printf("Lease expired: %s\n", key)
-        entry.Granted = false
-      }
+  now = time.Now()
+  for sh.expiry.Len() > 0 && !sh.expiry[0].deadline.After(now) {
+    entry = heap.Pop(&sh.expiry).(*Entry)
+
+    if entry.Granted && entry.Expired() {
+      fmt.Printf("Lease expired: %s\n", entry.key)
+      entry.Granted = false
+      atomic.AddUint64(&stats.Expirations, 1)
     }
 
     entry.Clean()
 
     //fmt.Printf("No recent queries: %s\n", key)
-    if entry.Queries.Len() == 0 {
-      delete(cache.Map, key)
+    if sh.schedule(entry) {
+      atomic.AddUint64(&stats.Evictions, 1)
+    }
+  }
+
+  sh.Lock.Unlock()
+}
+
+/**@brief delete expire entries in every shard
+ * @param void
+ * @return void
+ */
+func (cache *Cache) ClearExpired() {
+  for _, sh := range cache.Shards {
+    sh.reap(&cache.stats)
+  }
+}
+
+/**@brief background goroutine which reaps expired entries until Stop
+ * @param void
+ * @return void
+ */
+func (cache *Cache) reaper() {
+  var ticker *time.Ticker = time.NewTicker(REAP_INTERVAL)
+
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-cache.quit:
+      return
+    case <-ticker.C:
+      cache.ClearExpired()
     }
   }
 }
@@ -158,17 +390,20 @@ func (cache *Cache) ClearExpired() {
  * @return bool 
  */
 func (cache *Cache) ClearEntry(key string) bool {
+  var sh *Shard = cache.shard(key)
   var entry *Entry
   var valid bool
 
-  cache.Lock.Lock()
+  sh.Lock.Lock()
 
-  entry, valid = cache.Map[key]
+  entry, valid = sh.Map[key]
   if valid {
     entry.Granted = false
+    entry.Decoded = nil
+    sh.schedule(entry)
   }
 
-  cache.Lock.Unlock()
+  sh.Lock.Unlock()
 
   return valid
 }
@@ -177,30 +412,120 @@ func (cache *Cache) ClearEntry(key string) bool {
  *        used by revoke in libstore 
  * @param string 
  * @param interface{} 
+ * @param uint64 version of data
  * @param LeaseStuct
  * @return void 
  */
-func (cache *Cache) LeaseGranted(
-    key string, data interface{}, lease storageproto.LeaseStruct) {
+func (cache *Cache) LeaseGranted(key string, data interface{},
+    version uint64, lease storageproto.LeaseStruct) {
+  var sh *Shard = cache.shard(key)
   var entry *Entry
   var valid bool
 
-  cache.Lock.Lock()
+  sh.Lock.Lock()
   //fmt.Printf("Lease granted: %s (%v)\n", key, data)
 
-  entry, valid = cache.Map[key]
+  entry, valid = sh.Map[key]
   if !valid {
     entry = new(Entry)
+    entry.key = key
+    entry.index = -1
     entry.Queries = list.New()
 
-    cache.Map[key] = entry
+    sh.Map[key] = entry
   }
 
   entry.Data = data
+  entry.Decoded = nil
+  entry.Version = version
   entry.Granted = true
+  entry.Hits = 0
   entry.LeaseTime = time.Now()
   entry.LeaseDur = time.Duration(lease.ValidSeconds) * time.Second
+  sh.schedule(entry)
 
-  cache.Lock.Unlock()
+  sh.Lock.Unlock()
   //fmt.Printf("Lease granted complete\n")
 }
+
+/**@brief collect the leased entries that are about to expire but were read
+ *        at least thresh times under their current lease
+ * @param window how close to expiry a lease has to be
+ * @param thresh minimum number of cache hits
+ * @return map[string]interface{} key -> cached data
+ */
+func (cache *Cache) Renewable(
+    window time.Duration, thresh int) map[string]interface{} {
+  var hot map[string]interface{} = make(map[string]interface{})
+  var left time.Duration
+
+  for _, sh := range cache.Shards {
+    sh.Lock.Lock()
+    for key, entry := range sh.Map {
+      if !entry.Granted || entry.Hits < thresh {
+        continue
+      }
+
+      left = entry.LeaseDur - time.Since(entry.LeaseTime)
+      if left > 0 && left <= window {
+        hot[key] = entry.Data
+      }
+    }
+    sh.Lock.Unlock()
+  }
+
+  return hot
+}
+
+/**@brief decoded form of key kept in slot, only while the lease still
+ *        holds the version it was decoded from
+ * @param key
+ * @param slot one per way of decoding, e.g. with or without a schema check
+ * @param version storage version of the data the caller just read
+ * @return interface{}
+ * @return bool
+ */
+func (cache *Cache) Decoded(key, slot string,
+    version uint64) (interface{}, bool) {
+  var sh *Shard = cache.shard(key)
+  var entry *Entry
+  var valid bool
+
+  sh.Lock.Lock()
+  defer sh.Lock.Unlock()
+
+  entry, valid = sh.Map[key]
+  if !valid || !entry.Granted || entry.Expired() || version == 0 ||
+     entry.Version != version {
+    return nil, false
+  }
+
+  obj, ok := entry.Decoded[slot]
+  return obj, ok
+}
+
+/**@brief remember the decoded form of key in slot if version is what is
+ *        leased
+ * @param key
+ * @param slot
+ * @param version storage version of the data obj was decoded from
+ * @param obj
+ * @return void
+ */
+func (cache *Cache) SetDecoded(key, slot string, version uint64,
+    obj interface{}) {
+  var sh *Shard = cache.shard(key)
+  var entry *Entry
+  var valid bool
+
+  sh.Lock.Lock()
+  defer sh.Lock.Unlock()
+
+  entry, valid = sh.Map[key]
+  if valid && entry.Granted && version != 0 && entry.Version == version {
+    if entry.Decoded == nil {
+      entry.Decoded = make(map[string]interface{})
+    }
+    entry.Decoded[slot] = obj
+  }
+}
@@ -0,0 +1,139 @@
+/** @file cache_test.go
+ *  @brief tests of the sharded lease cache and its reaper
+ *  @author Andrin(atrejo) Dalong CHENG (dalongc)
+ *  @date 2012-10-23
+ */
+package cache
+
+import (
+  "fmt"
+  "sync"
+  "testing"
+  "time"
+  "P2-f12/official/storageproto"
+)
+
+/**@brief a lease of seconds as storage grants it
+ * @param seconds
+ * @return storageproto.LeaseStruct
+ */
+func lease(seconds int) storageproto.LeaseStruct {
+  return storageproto.LeaseStruct{true, seconds}
+}
+
+/**@brief whether key is leased, looked up without counting a query
+ * @param cache
+ * @param key
+ * @return bool
+ */
+func granted(cache *Cache, key string) bool {
+  sh := cache.shard(key)
+  sh.Lock.Lock()
+  defer sh.Lock.Unlock()
+
+  entry, valid := sh.Map[key]
+  return valid && entry.Granted
+}
+
+/**@brief readers and writers of different keys run at the same time on
+ *        every shard and each reads back its own data
+ * @param t
+ * @return void
+ */
+func TestCacheConcurrentShards(t *testing.T) {
+  var wg sync.WaitGroup
+  var used map[*Shard]bool = make(map[*Shard]bool)
+
+  cache := NewCache()
+  defer cache.Stop()
+
+  for i := 0; i < 8 * NUM_SHARDS; i++ {
+    used[cache.shard(fmt.Sprintf("key%d", i))] = true
+  }
+  if len(used) != NUM_SHARDS {
+    t.Fatalf("keys spread over %d of %d shards", len(used), NUM_SHARDS)
+  }
+
+  errs := make(chan error, 8 * NUM_SHARDS)
+  for i := 0; i < 8 * NUM_SHARDS; i++ {
+    wg.Add(1)
+    go func(i int) {
+      defer wg.Done()
+      key := fmt.Sprintf("key%d", i)
+
+      for round := 0; round < 50; round++ {
+        var args storageproto.GetArgs
+
+        value := fmt.Sprintf("%s.%d", key, round)
+        cache.LeaseGranted(key, value, uint64(round + 1), lease(10))
+        data, version, err := cache.Get(key, 0, &args)
+        if err != nil || data != value || version != uint64(round + 1) {
+          errs <- fmt.Errorf("%s: %v, %d, %v", key, data, version, err)
+          return
+        }
+        if round % 10 == 9 && !cache.ClearEntry(key) {
+          errs <- fmt.Errorf("%s: not cached", key)
+          return
+        }
+      }
+    }(i)
+  }
+  wg.Wait()
+  close(errs)
+
+  for err := range errs {
+    t.Error(err)
+  }
+  if stats := cache.Stats(); stats.Hits != 8 * NUM_SHARDS * 50 {
+    t.Errorf("%d hits, want %d", stats.Hits, 8 * NUM_SHARDS * 50)
+  }
+}
+
+/**@brief the reaper expires a lease that is never read again
+ * @param t
+ * @return void
+ */
+func TestCacheReaperExpires(t *testing.T) {
+  cache := NewCache()
+  defer cache.Stop()
+
+  cache.LeaseGranted("hot", "v", 1, lease(1))
+  cache.LeaseGranted("cold", "v", 1, lease(10))
+  if !granted(cache, "hot") {
+    t.Fatal("lease not recorded")
+  }
+
+  deadline := time.Now().Add(time.Second + 4 * REAP_INTERVAL)
+  for granted(cache, "hot") {
+    if time.Now().After(deadline) {
+      t.Fatal("expired lease not reaped")
+    }
+    time.Sleep(REAP_INTERVAL / 5)
+  }
+
+  if stats := cache.Stats(); stats.Expirations != 1 || stats.Hits != 0 ||
+      stats.Misses != 0 {
+    t.Errorf("stats after reaping = %+v", stats)
+  }
+  if !granted(cache, "cold") {
+    t.Errorf("unexpired lease reaped")
+  }
+}
+
+/**@brief a stopped cache still answers, expiry is then only seen by Get
+ * @param t
+ * @return void
+ */
+func TestCacheStop(t *testing.T) {
+  var args storageproto.GetArgs
+
+  cache := NewCache()
+  cache.Stop()
+  cache.Stop()
+
+  cache.LeaseGranted("key", "v", 1, lease(10))
+  data, _, err := cache.Get("key", 0, &args)
+  if err != nil || data != "v" {
+    t.Errorf("Get after Stop = %v, %v", data, err)
+  }
+}
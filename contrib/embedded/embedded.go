@@ -0,0 +1,175 @@
+/** @file embedded.go
+ *  @brief a whole storage cluster inside one process, storage servers and
+ *         libstores talk over a transport.Local instead of TCP
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package embedded
+
+import (
+  "fmt"
+  "io"
+  "math/rand"
+  "net/rpc"
+  "sync"
+  "P2-f12/contrib/libstore"
+  "P2-f12/contrib/partition"
+  "P2-f12/contrib/storageimpl"
+  "P2-f12/contrib/transport"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/storagerpc"
+)
+
+/**
+ *  @brief embedded storage cluster
+ */
+type Cluster struct {
+  Trans *transport.Local
+  Master string
+  Servers []*storageimpl.Storageserver
+  Part partition.Partitioner //placement used by servers and libstores
+
+  listeners []io.Closer
+  libstores []*libstore.Libstore
+  lock sync.Mutex
+}
+
+/**@brief start numnodes storage servers, the first one is the master
+ * @param numnodes
+ * @return *Cluster
+ * @return error
+ */
+func NewCluster(numnodes int) (*Cluster, error) {
+  return NewClusterPartitioned(numnodes, partition.Hash{})
+}
+
+/**@brief start numnodes storage servers placing keys with part, libstores
+ *        of the cluster use it as well
+ * @param numnodes
+ * @param part
+ * @return *Cluster
+ * @return error
+ */
+func NewClusterPartitioned(numnodes int,
+    part partition.Partitioner) (*Cluster, error) {
+  var cluster Cluster
+  var wg sync.WaitGroup
+  var err error
+
+  if numnodes < 1 {
+    return nil, lsplog.MakeErr("embedded cluster needs at least one node")
+  }
+
+  cluster.Trans = transport.NewLocal()
+  cluster.Part = part
+  cluster.Master = "localhost:1"
+  cluster.Servers = make([]*storageimpl.Storageserver, numnodes)
+
+  //the master has to be reachable before any slave registers
+  cluster.Servers[0] = storageimpl.NewStorageserverPartitioned(
+      cluster.Master, numnodes, 1, rand.Uint32(), cluster.Trans, part)
+  err = cluster.serve(cluster.Master, cluster.Servers[0])
+  if lsplog.CheckReport(1, err) {
+    return nil, err
+  }
+
+  //slaves block until every node registered, start them together
+  for i := 1; i < numnodes; i++ {
+    wg.Add(1)
+    go func(i int) {
+      defer wg.Done()
+      cluster.Servers[i] = storageimpl.NewStorageserverPartitioned(
+          cluster.Master, numnodes, i + 1, rand.Uint32(), cluster.Trans, part)
+    }(i)
+  }
+  wg.Wait()
+
+  for i := 1; i < numnodes; i++ {
+    if cluster.Servers[i] == nil {
+      cluster.Close()
+      return nil, lsplog.MakeErr("embedded storage server failed to start")
+    }
+
+    err = cluster.serve(fmt.Sprintf("localhost:%d", i + 1), cluster.Servers[i])
+    if lsplog.CheckReport(1, err) {
+      cluster.Close()
+      return nil, err
+    }
+  }
+
+  return &cluster, nil
+}
+
+/**@brief make a storage server reachable at addr on the local transport
+ * @param addr
+ * @param ss
+ * @return error
+ */
+func (cluster *Cluster) serve(
+    addr string, ss *storageimpl.Storageserver) error {
+  var srv *rpc.Server = rpc.NewServer()
+  var err error
+
+  err = srv.Register(storagerpc.NewStorageRPC(ss))
+  if err != nil {
+    return err
+  }
+
+  l, _, err := cluster.Trans.Listen(addr, srv)
+  if err != nil {
+    return err
+  }
+
+  cluster.lock.Lock()
+  cluster.listeners = append(cluster.listeners, l)
+  cluster.lock.Unlock()
+
+  return nil
+}
+
+/**@brief create a libstore connected to this cluster, lease revocations
+ *        are delivered in-process as well
+ * @param flags libstore flags
+ * @return *libstore.Libstore
+ * @return error
+ */
+func (cluster *Cluster) NewLibstore(flags int) (*libstore.Libstore, error) {
+  ls, err := libstore.NewLibstoreTransport(cluster.Master, "localhost:0",
+      flags, cluster.Part, cluster.Trans)
+  if err != nil {
+    return nil, err
+  }
+
+  cluster.lock.Lock()
+  cluster.libstores = append(cluster.libstores, ls)
+  cluster.lock.Unlock()
+
+  return ls, nil
+}
+
+/**@brief close the libstores created through the cluster, then stop
+ *        serving its storage servers
+ * @param void
+ * @return error the first one met
+ */
+func (cluster *Cluster) Close() error {
+  var err error
+
+  cluster.lock.Lock()
+  defer cluster.lock.Unlock()
+
+  for _, ls := range cluster.libstores {
+    if e := ls.Close(); e != nil && err == nil {
+      err = e
+    }
+  }
+  for _, l := range cluster.listeners {
+    if e := l.Close(); e != nil && err == nil {
+      err = e
+    }
+  }
+  cluster.libstores = nil
+  cluster.listeners = nil
+
+  return err
+}
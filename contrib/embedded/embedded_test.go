@@ -0,0 +1,289 @@
+/** @file embedded_test.go
+ *  @brief end-to-end checks of libstore leases on an embedded cluster
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package embedded
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+  "P2-f12/contrib/libstore"
+  "P2-f12/contrib/partition"
+  "P2-f12/official/storageproto"
+)
+
+/**@brief misses are leased and cached on every node of a multi-node
+ *        cluster, whatever the partitioner, and a put revokes them
+ * @param t
+ * @return void
+ */
+func TestNegativeLeaseMultiNode(t *testing.T) {
+  for name, part := range map[string]partition.Partitioner{
+      "hash": partition.Hash{},
+      "rendezvous": partition.Rendezvous{},
+      "hashtag": partition.HashTag{}} {
+    t.Run(name, func(t *testing.T) {
+      cluster, err := NewClusterPartitioned(3, part)
+      if err != nil {
+        t.Fatal(err)
+      }
+      defer cluster.Close()
+      reader, err := cluster.NewLibstore(libstore.ALWAYS_LEASE)
+      if err != nil {
+        t.Fatal(err)
+      }
+      writer, err := cluster.NewLibstore(libstore.NONE)
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      //routing keys and tags spread over the nodes
+      for _, key := range []string{"alice:T", "bob:F", "carol:L", "dave:M",
+          "{x}:a", "{y}:b", "{z}:c"} {
+        before := reader.Stats()
+
+        for i := 0; i < 2; i++ {
+          _, err = reader.Get(key)
+          if err != libstore.MakeErr("Get()", storageproto.EKEYNOTFOUND) {
+            t.Fatalf("Get(%s) = %v, want not found", key, err)
+          }
+        }
+
+        after := reader.Stats()
+        if after.LeasesGranted != before.LeasesGranted + 1 {
+          t.Errorf("%s: %d leases granted on a miss, want 1",
+              key, after.LeasesGranted - before.LeasesGranted)
+        }
+        if after.Hits != before.Hits + 1 {
+          t.Errorf("%s: second miss not served from the cache", key)
+        }
+
+        if err = writer.Put(key, "v"); err != nil {
+          t.Fatal(err)
+        }
+        value, err := reader.Get(key)
+        if err != nil || value != "v" {
+          t.Errorf("%s after put: %q, %v", key, value, err)
+        }
+      }
+    })
+  }
+}
+
+/**@brief decoded values are reused under a lease whether the cache holds
+ *        plain, encrypted or compressed data
+ * @param t
+ * @return void
+ */
+func TestDecodedCache(t *testing.T) {
+  cluster, err := NewCluster(1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer cluster.Close()
+
+  cases := []struct {
+    name string
+    encrypt bool
+    value string
+  }{
+    {"plain", false, "v"},
+    {"encrypted", true, "v"},
+    //over storageproto.COMPRESS_THRESHOLD
+    {"compressed", false, strings.Repeat("tribble ", 400)},
+  }
+
+  for _, c := range cases {
+    t.Run(c.name, func(t *testing.T) {
+      ls, err := cluster.NewLibstore(libstore.ALWAYS_LEASE)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if c.encrypt {
+        kr := libstore.NewKeyring()
+        if err = kr.Add("k1", make([]byte, 32)); err != nil {
+          t.Fatal(err)
+        }
+        ls.SetKeyring(kr)
+      }
+
+      key := "decoded:" + c.name
+      err = libstore.PutJSON(ls, key, map[string]string{"v": c.value})
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      first, err := libstore.GetJSON[map[string]string](ls, key)
+      if err != nil || first["v"] != c.value {
+        t.Fatalf("GetJSON: %v, %v", first, err)
+      }
+      second, err := libstore.GetJSON[map[string]string](ls, key)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if reflect.ValueOf(first).Pointer() !=
+         reflect.ValueOf(second).Pointer() {
+        t.Errorf("second read decoded again")
+      }
+    })
+  }
+}
+
+/**@brief a value decoded without a schema check does not let a schema
+ *        checked read skip its check
+ * @param t
+ * @return void
+ */
+func TestDecodedCacheSchema(t *testing.T) {
+  cluster, err := NewCluster(1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer cluster.Close()
+  ls, err := cluster.NewLibstore(libstore.ALWAYS_LEASE)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  err = libstore.PutJSONSchema(ls, "schema:k", 2, map[string]interface{}{})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  _, err = libstore.GetJSON[map[string]interface{}](ls, "schema:k")
+  if err != nil {
+    t.Fatal(err)
+  }
+  _, err = libstore.GetJSONSchema[map[string]interface{}](ls, "schema:k", 3)
+  if err == nil {
+    t.Errorf("schema 2 accepted as 3")
+  }
+  _, err = libstore.GetJSONSchema[map[string]interface{}](ls, "schema:k", 2)
+  if err != nil {
+    t.Error(err)
+  }
+}
+
+/**@brief a session reads its own writes back even when a revocation is
+ *        late and the cache still holds an older value
+ * @param t
+ * @return void
+ */
+func TestSessionReadsOwnWrites(t *testing.T) {
+  cluster, err := NewCluster(1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer cluster.Close()
+  ls, err := cluster.NewLibstore(libstore.ALWAYS_LEASE)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var session libstore.KVStore = ls.NewSession()
+
+  if err = session.Put("session:k", "v1"); err != nil {
+    t.Fatal(err)
+  }
+  if _, err = ls.Get("session:k"); err != nil {
+    t.Fatal(err)
+  }
+  if err = session.Put("session:k", "v2"); err != nil {
+    t.Fatal(err)
+  }
+
+  //the lease of v1 granted again, as if its revocation were late
+  ls.Leases.LeaseGranted("session:k", "v1", 1,
+      storageproto.LeaseStruct{Granted: true, ValidSeconds: 10})
+
+  if value, _ := ls.Get("session:k"); value != "v1" {
+    t.Fatalf("stale cache entry not used by the libstore: %q", value)
+  }
+  if value, err := session.Get("session:k"); err != nil || value != "v2" {
+    t.Errorf("session read %q, %v after writing v2", value, err)
+  }
+
+  if err = session.Close(); err != nil {
+    t.Error(err)
+  }
+  if session.Stats().Hits == 0 {
+    t.Errorf("session stats are not those of its libstore")
+  }
+}
+
+/**@brief list writes that return early on a leased key release its lock,
+ *        so later writes to the key do not hang
+ * @param t
+ * @return void
+ */
+func TestLeasedListWritesUnlock(t *testing.T) {
+  cluster, err := NewCluster(1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer cluster.Close()
+  reader, err := cluster.NewLibstore(libstore.ALWAYS_LEASE)
+  if err != nil {
+    t.Fatal(err)
+  }
+  writer, err := cluster.NewLibstore(libstore.NONE)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if err = writer.AppendToList("locked:L", "a"); err != nil {
+    t.Fatal(err)
+  }
+  if _, err = reader.GetList("locked:L"); err != nil {
+    t.Fatal(err)
+  }
+
+  done := make(chan error)
+  go func() {
+    //item exists, then removed, then a write after both early returns
+    writer.AppendToList("locked:L", "a")
+    writer.RemoveFromList("locked:L", "a")
+    done <- writer.AppendToList("locked:L", "b")
+  }()
+
+  select {
+  case err = <-done:
+    if err != nil {
+      t.Error(err)
+    }
+  case <-time.After(5 * time.Second):
+    t.Fatal("list write hung on the lock of an earlier one")
+  }
+}
+
+/**@brief a closed cluster no longer serves its storage servers and its
+ *        libstores are closed with it
+ * @param t
+ * @return void
+ */
+func TestClusterClose(t *testing.T) {
+  cluster, err := NewCluster(2)
+  if err != nil {
+    t.Fatal(err)
+  }
+  ls, err := cluster.NewLibstore(libstore.ALWAYS_LEASE)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if err = cluster.Close(); err != nil {
+    t.Fatal(err)
+  }
+  if _, err = cluster.Trans.Dial(cluster.Master); err == nil {
+    t.Errorf("master still reachable after Close")
+  }
+  if _, err = cluster.NewLibstore(libstore.NONE); err == nil {
+    t.Errorf("libstore created on a closed cluster")
+  }
+  //closing twice is harmless
+  if err = ls.Close(); err != nil {
+    t.Error(err)
+  }
+}
@@ -0,0 +1,250 @@
+/** @file fakestore.go
+ *  @brief in-memory libstore.KVStore for testing the tribble server
+ *         without running storage servers
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package fakestore
+
+import (
+  "sync"
+  "time"
+  "P2-f12/contrib/libstore"
+  "P2-f12/official/storageproto"
+)
+
+/**
+ *  @brief called before every operation with its name ("Get", "Put",
+ *         "GetList", "AppendToList", "RemoveFromList") and key, a non-nil
+ *         error is returned to the caller instead of running the operation
+ */
+type Hook func(op, key string) error
+
+/**
+ *  @brief fake store, values are either string or []string like in the
+ *         real storage server
+ */
+type FakeStore struct {
+  data map[string]interface{}
+  lock sync.Mutex
+
+  hookLock sync.Mutex
+  hook Hook
+  latency time.Duration
+
+  stats libstore.Stats
+}
+
+/**@brief create an empty fake store
+ * @param void
+ * @return *FakeStore
+ */
+func NewFakeStore() *FakeStore {
+  var fs FakeStore
+
+  fs.data = make(map[string]interface{})
+
+  return &fs
+}
+
+/**@brief install a hook for failure injection, nil removes it
+ * @param Hook
+ * @return void
+ */
+func (fs *FakeStore) SetHook(hook Hook) {
+  fs.hookLock.Lock()
+  fs.hook = hook
+  fs.hookLock.Unlock()
+}
+
+/**@brief delay every operation by d to simulate a slow network
+ * @param d
+ * @return void
+ */
+func (fs *FakeStore) SetLatency(d time.Duration) {
+  fs.hookLock.Lock()
+  fs.latency = d
+  fs.hookLock.Unlock()
+}
+
+/**@brief FailStatus returns a hook failing op on key with a storage status,
+ *        e.g. FailStatus("Put", "alice:T", storageproto.EPUTFAILED)
+ * @param op
+ * @param key
+ * @param status
+ * @return Hook
+ */
+func FailStatus(op, key string, status int) Hook {
+  return func(o, k string) error {
+    if o == op && k == key {
+      return libstore.MakeErr(op + "()", status)
+    }
+    return nil
+  }
+}
+
+/**@brief run latency and hook before an operation
+ * @param op
+ * @param key
+ * @return error
+ */
+func (fs *FakeStore) before(op, key string) error {
+  var hook Hook
+  var latency time.Duration
+
+  fs.hookLock.Lock()
+  hook = fs.hook
+  latency = fs.latency
+  fs.hookLock.Unlock()
+
+  if latency > 0 {
+    time.Sleep(latency)
+  }
+
+  if hook != nil {
+    return hook(op, key)
+  }
+
+  return nil
+}
+
+func (fs *FakeStore) Get(key string) (string, error) {
+  var val interface{}
+  var present bool
+  var err error
+
+  err = fs.before("Get", key)
+  if err != nil {
+    return "", err
+  }
+
+  fs.lock.Lock()
+  defer fs.lock.Unlock()
+
+  fs.stats.Misses++
+
+  val, present = fs.data[key]
+  if !present {
+    return "", libstore.MakeErr("Get()", storageproto.EKEYNOTFOUND)
+  }
+
+  //a list read as a value decodes to "" on the real server
+  str, _ := val.(string)
+  return str, nil
+}
+
+func (fs *FakeStore) Put(key, value string) error {
+  var present bool
+  var err error
+
+  err = fs.before("Put", key)
+  if err != nil {
+    return err
+  }
+
+  fs.lock.Lock()
+  defer fs.lock.Unlock()
+
+  //first put of "" creates an empty list, as storageimpl does
+  _, present = fs.data[key]
+  if !present && value == "" {
+    fs.data[key] = []string{}
+  } else {
+    fs.data[key] = value
+  }
+
+  return nil
+}
+
+func (fs *FakeStore) GetList(key string) ([]string, error) {
+  var val interface{}
+  var present bool
+  var err error
+
+  err = fs.before("GetList", key)
+  if err != nil {
+    return nil, err
+  }
+
+  fs.lock.Lock()
+  defer fs.lock.Unlock()
+
+  fs.stats.Misses++
+
+  val, present = fs.data[key]
+  if !present {
+    return nil, libstore.MakeErr("GetList()", storageproto.EKEYNOTFOUND)
+  }
+
+  list, _ := val.([]string)
+  return append([]string(nil), list...), nil
+}
+
+func (fs *FakeStore) AppendToList(key, newitem string) error {
+  var list []string
+  var err error
+
+  err = fs.before("AppendToList", key)
+  if err != nil {
+    return err
+  }
+
+  fs.lock.Lock()
+  defer fs.lock.Unlock()
+
+  list, _ = fs.data[key].([]string)
+  for _, v := range list {
+    if v == newitem {
+      return libstore.MakeErr("AppendToList()", storageproto.EITEMEXISTS)
+    }
+  }
+
+  fs.data[key] = append(list, newitem)
+
+  return nil
+}
+
+func (fs *FakeStore) RemoveFromList(key, removeitem string) error {
+  var val interface{}
+  var present bool
+  var list []string
+  var err error
+
+  err = fs.before("RemoveFromList", key)
+  if err != nil {
+    return err
+  }
+
+  fs.lock.Lock()
+  defer fs.lock.Unlock()
+
+  val, present = fs.data[key]
+  if !present {
+    return libstore.MakeErr("RemoveFromList()", storageproto.EKEYNOTFOUND)
+  }
+
+  list, _ = val.([]string)
+  for i, v := range list {
+    if v == removeitem {
+      fs.data[key] = append(list[:i:i], list[i+1:]...)
+      return nil
+    }
+  }
+
+  return libstore.MakeErr("RemoveFromList()", storageproto.EITEMNOTFOUND)
+}
+
+/**@brief the fake has no cache, every read counts as a miss
+ * @param void
+ * @return libstore.Stats
+ */
+func (fs *FakeStore) Stats() libstore.Stats {
+  fs.lock.Lock()
+  defer fs.lock.Unlock()
+
+  return fs.stats
+}
+
+func (fs *FakeStore) Close() error {
+  return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+// Rebuilds the follower index (<target>:FOLLOWERS) from the subscription
+// lists (<userid>:F) of the given users. Storage cannot list its keys, so
+// the users to repair are named on the command line or, one per line, in
+// the file given with -users.
+
+import (
+	"P2-f12/contrib/libstore"
+	"P2-f12/contrib/tribimpl"
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"strings"
+)
+
+var usersFile *string = flag.String("users", "", "file of userids to repair, one per line")
+
+func readUsers(path string) []string {
+	var users []string
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal("could not open users file:", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if userid := strings.TrimSpace(scanner.Text()); userid != "" {
+			users = append(users, userid)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal("could not read users file:", err)
+	}
+	return users
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() < 1 {
+		log.Fatal("usage:  followerrepair [-users file] <storage master node> [userid ...]")
+	}
+
+	users := flag.Args()[1:]
+	if *usersFile != "" {
+		users = append(users, readUsers(*usersFile)...)
+	}
+	if len(users) == 0 {
+		log.Fatal("no users to repair")
+	}
+
+	// libstore.NONE: no leases, so nothing calls back
+	ls, err := libstore.NewLibstore(flag.Arg(0), "localhost:0", libstore.NONE)
+	if err != nil {
+		log.Fatal("could not start libstore:", err)
+	}
+
+	added, removed, err := tribimpl.RepairFollowers(ls, users)
+	log.Printf("%d followers added, %d removed\n", added, removed)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+/** @file client.go
+ *  @brief minimal unary gRPC client for Go programs and grpctest, speaks
+ *         to any gRPC server implementing the .proto services
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package grpcserver
+
+import (
+  "bytes"
+  "io"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "P2-f12/official/lsplog"
+)
+
+/**
+ *  @brief a connection to one server, Call takes net/rpc style names so
+ *         the client can stand in for an rpc.Client
+ */
+type Client struct {
+  base string
+  http *http.Client
+}
+
+/**@brief client for the gRPC server at hostport, connections are made on
+ *        the first call
+ * @param hostport
+ * @return *Client
+ */
+func Dial(hostport string) *Client {
+  var protocols http.Protocols
+  var tr *http.Transport = &http.Transport{}
+
+  protocols.SetUnencryptedHTTP2(true)
+  tr.Protocols = &protocols
+
+  return &Client{"http://" + hostport, &http.Client{Transport: tr}}
+}
+
+/**@brief call "Service.Method" with args and decode into reply
+ * @param serviceMethod
+ * @param args
+ * @param reply
+ * @return error
+ */
+func (c *Client) Call(serviceMethod string,
+    args interface{}, reply interface{}) error {
+  service, name, found := strings.Cut(serviceMethod, ".")
+  pkg, known := ProtoPackage[service]
+  if !found || !known {
+    return lsplog.MakeErr("grpc: unknown service " + serviceMethod)
+  }
+
+  data, err := Marshal(args)
+  if err != nil {
+    return err
+  }
+
+  req, err := http.NewRequest("POST",
+      c.base + "/" + pkg + "." + service + "/" + name,
+      bytes.NewReader(frame(data)))
+  if err != nil {
+    return err
+  }
+  req.Header.Set("Content-Type", "application/grpc+proto")
+  req.Header.Set("TE", "trailers")
+
+  resp, err := c.http.Do(req)
+  if err != nil {
+    return err
+  }
+  defer resp.Body.Close()
+
+  data, _, err = readMessage(resp.Body)
+  //trailers are only complete once the body is drained
+  io.Copy(io.Discard, resp.Body)
+
+  status := resp.Trailer.Get("Grpc-Status")
+  if status == "" {
+    status = resp.Header.Get("Grpc-Status")
+  }
+  if code, _ := strconv.Atoi(status); status != "0" {
+    msg, _ := url.PathUnescape(resp.Trailer.Get("Grpc-Message"))
+    return lsplog.MakeErr(
+        "grpc: " + serviceMethod + " failed with code " +
+        strconv.Itoa(code) + ": " + msg)
+  }
+  if err != nil {
+    return err
+  }
+
+  return Unmarshal(data, reply)
+}
+
+func (c *Client) Close() error {
+  c.http.CloseIdleConnections()
+  return nil
+}
@@ -0,0 +1,303 @@
+/** @file pb.go
+ *  @brief protocol buffer encoding of the proto package structs, following
+ *         storageproto.proto and tribproto.proto: the n-th exported field
+ *         of a struct is field number n. protoc is not part of our build,
+ *         so the messages are encoded by reflection instead of generated
+ *         code.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package grpcserver
+
+import (
+  "encoding/binary"
+  "math"
+  "reflect"
+  "time"
+  "P2-f12/official/lsplog"
+)
+
+// protobuf wire types
+const (
+  WIRE_VARINT = 0
+  WIRE_FIXED64 = 1
+  WIRE_BYTES = 2
+  WIRE_FIXED32 = 5
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func appendTag(buf []byte, num int, wire int) []byte {
+  return binary.AppendUvarint(buf, uint64(num) << 3 | uint64(wire))
+}
+
+func appendLen(buf []byte, num int, data []byte) []byte {
+  buf = appendTag(buf, num, WIRE_BYTES)
+  buf = binary.AppendUvarint(buf, uint64(len(data)))
+  return append(buf, data...)
+}
+
+/**@brief encode a struct as a protobuf message, zero scalars are left out
+ *        as proto3 does
+ * @param buf
+ * @param v struct value
+ * @return []byte
+ * @return error
+ */
+func appendMessage(buf []byte, v reflect.Value) ([]byte, error) {
+  var num int
+  var err error
+
+  if v.Type() == timeType {
+    //google.protobuf.Timestamp
+    t := v.Interface().(time.Time)
+    if t.Unix() != 0 {
+      buf = appendTag(buf, 1, WIRE_VARINT)
+      buf = binary.AppendUvarint(buf, uint64(t.Unix()))
+    }
+    if t.Nanosecond() != 0 {
+      buf = appendTag(buf, 2, WIRE_VARINT)
+      buf = binary.AppendUvarint(buf, uint64(t.Nanosecond()))
+    }
+    return buf, nil
+  }
+
+  for i := 0; i < v.NumField(); i++ {
+    if !v.Type().Field(i).IsExported() {
+      continue
+    }
+    num++
+    buf, err = appendField(buf, num, v.Field(i))
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  return buf, nil
+}
+
+func appendField(buf []byte, num int, f reflect.Value) ([]byte, error) {
+  var err error
+
+  switch f.Kind() {
+  case reflect.Bool:
+    if f.Bool() {
+      buf = append(appendTag(buf, num, WIRE_VARINT), 1)
+    }
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    if f.Int() != 0 {
+      buf = appendTag(buf, num, WIRE_VARINT)
+      buf = binary.AppendUvarint(buf, uint64(f.Int()))
+    }
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+       reflect.Uint64:
+    if f.Uint() != 0 {
+      buf = appendTag(buf, num, WIRE_VARINT)
+      buf = binary.AppendUvarint(buf, f.Uint())
+    }
+  case reflect.Float64:
+    if f.Float() != 0 {
+      buf = appendTag(buf, num, WIRE_FIXED64)
+      buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(f.Float()))
+    }
+  case reflect.String:
+    if f.Len() > 0 {
+      buf = appendLen(buf, num, []byte(f.String()))
+    }
+  case reflect.Struct:
+    //a zero time is an unset Timestamp, not year 1
+    if f.Type() == timeType && f.Interface().(time.Time).IsZero() {
+      break
+    }
+    var sub []byte
+    sub, err = appendMessage(nil, f)
+    buf = appendLen(buf, num, sub)
+  case reflect.Slice:
+    if f.Type().Elem().Kind() == reflect.Uint8 {
+      if f.Len() > 0 {
+        buf = appendLen(buf, num, f.Bytes())
+      }
+      break
+    }
+    //repeated strings and messages, one field per element
+    for i := 0; i < f.Len() && err == nil; i++ {
+      switch f.Index(i).Kind() {
+      case reflect.String:
+        buf = appendLen(buf, num, []byte(f.Index(i).String()))
+      case reflect.Struct:
+        var sub []byte
+        sub, err = appendMessage(nil, f.Index(i))
+        buf = appendLen(buf, num, sub)
+      default:
+        err = lsplog.MakeErr("pb: cannot encode " + f.Type().String())
+      }
+    }
+  default:
+    err = lsplog.MakeErr("pb: cannot encode " + f.Type().String())
+  }
+
+  return buf, err
+}
+
+/**@brief decode a protobuf message into the settable struct v, unknown
+ *        fields are skipped
+ * @param data
+ * @param v
+ * @return error
+ */
+func decodeMessage(data []byte, v reflect.Value) error {
+  var fields []reflect.Value
+  var sec, nsec int64
+
+  if v.Type() != timeType {
+    for i := 0; i < v.NumField(); i++ {
+      if v.Type().Field(i).IsExported() {
+        fields = append(fields, v.Field(i))
+      }
+    }
+  }
+
+  for len(data) > 0 {
+    var raw []byte
+    var x uint64
+
+    tag, n := binary.Uvarint(data)
+    if n <= 0 {
+      return errShort
+    }
+    data = data[n:]
+    num, wire := int(tag >> 3), int(tag & 7)
+
+    switch wire {
+    case WIRE_VARINT:
+      x, n = binary.Uvarint(data)
+      if n <= 0 {
+        return errShort
+      }
+      data = data[n:]
+    case WIRE_FIXED64:
+      if len(data) < 8 {
+        return errShort
+      }
+      x = binary.LittleEndian.Uint64(data)
+      data = data[8:]
+    case WIRE_FIXED32:
+      if len(data) < 4 {
+        return errShort
+      }
+      x = uint64(binary.LittleEndian.Uint32(data))
+      data = data[4:]
+    case WIRE_BYTES:
+      size, n := binary.Uvarint(data)
+      if n <= 0 || uint64(len(data) - n) < size {
+        return errShort
+      }
+      raw = data[n:n + int(size)]
+      data = data[n + int(size):]
+    default:
+      return lsplog.MakeErr("pb: unsupported wire type")
+    }
+
+    if v.Type() == timeType {
+      switch num {
+      case 1:
+        sec = int64(x)
+      case 2:
+        nsec = int64(x)
+      }
+      continue
+    }
+
+    if num < 1 || num > len(fields) {
+      continue
+    }
+    if err := setField(fields[num - 1], wire, x, raw); err != nil {
+      return err
+    }
+  }
+
+  if v.Type() == timeType {
+    v.Set(reflect.ValueOf(time.Unix(sec, nsec)))
+  }
+
+  return nil
+}
+
+var errShort = lsplog.MakeErr("pb: message too short")
+
+func setField(f reflect.Value, wire int, x uint64, raw []byte) error {
+  var wantBytes bool
+
+  switch f.Kind() {
+  case reflect.String, reflect.Struct, reflect.Slice:
+    wantBytes = true
+  }
+  if wantBytes != (wire == WIRE_BYTES) {
+    return lsplog.MakeErr("pb: wrong wire type for " + f.Type().String())
+  }
+
+  switch f.Kind() {
+  case reflect.Bool:
+    f.SetBool(x != 0)
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    f.SetInt(int64(x))
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+       reflect.Uint64:
+    f.SetUint(x)
+  case reflect.Float64:
+    f.SetFloat(math.Float64frombits(x))
+  case reflect.String:
+    f.SetString(string(raw))
+  case reflect.Struct:
+    return decodeMessage(raw, f)
+  case reflect.Slice:
+    if f.Type().Elem().Kind() == reflect.Uint8 {
+      f.SetBytes(append([]byte{}, raw...))
+      return nil
+    }
+    elem := reflect.New(f.Type().Elem()).Elem()
+    switch elem.Kind() {
+    case reflect.String:
+      elem.SetString(string(raw))
+    case reflect.Struct:
+      if err := decodeMessage(raw, elem); err != nil {
+        return err
+      }
+    default:
+      return lsplog.MakeErr("pb: cannot decode " + f.Type().String())
+    }
+    f.Set(reflect.Append(f, elem))
+  default:
+    return lsplog.MakeErr("pb: cannot decode " + f.Type().String())
+  }
+
+  return nil
+}
+
+/**@brief encode the struct x points to
+ * @param x
+ * @return []byte
+ * @return error
+ */
+func Marshal(x interface{}) ([]byte, error) {
+  v := reflect.Indirect(reflect.ValueOf(x))
+  if v.Kind() != reflect.Struct {
+    return nil, lsplog.MakeErr("pb: not a message " + v.Type().String())
+  }
+
+  return appendMessage(nil, v)
+}
+
+/**@brief decode into the struct x points to
+ * @param data
+ * @param x
+ * @return error
+ */
+func Unmarshal(data []byte, x interface{}) error {
+  v := reflect.ValueOf(x)
+  if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+    return lsplog.MakeErr("pb: decode target is not a struct pointer")
+  }
+
+  return decodeMessage(data, v.Elem())
+}
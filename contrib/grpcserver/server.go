@@ -0,0 +1,237 @@
+/** @file server.go
+ *  @brief unary gRPC over cleartext HTTP/2 for the same receivers that are
+ *         registered with net/rpc, e.g. storagerpc.StorageRPC and
+ *         tribimpl.Tribserver
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package grpcserver
+
+import (
+  "encoding/binary"
+  "fmt"
+  "io"
+  "net"
+  "net/http"
+  "reflect"
+  "strconv"
+  "strings"
+  "sync"
+  "P2-f12/official/lsplog"
+)
+
+// gRPC status codes we send
+const (
+  CODE_OK = 0
+  CODE_UNKNOWN = 2
+  CODE_INVALID_ARGUMENT = 3
+  CODE_UNIMPLEMENTED = 12
+  CODE_INTERNAL = 13
+)
+
+const MAX_MESSAGE = 16 << 20 // refuse larger requests
+
+// proto package of every service name, see the .proto files
+var ProtoPackage = map[string]string {
+  "StorageRPC": "storageproto",
+  "CacheRPC":   "storageproto",
+  "Tribserver": "tribproto",
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+/**
+ *  @brief one exported method shaped like a net/rpc method,
+ *         func (t *T) Name(args *A, reply *R) error
+ */
+type method struct {
+  rcvr reflect.Value
+  fn reflect.Method
+  args reflect.Type
+  reply reflect.Type
+}
+
+/**
+ *  @brief maps "/package.Service/Method" to methods
+ */
+type Server struct {
+  methods map[string]*method
+  lock sync.RWMutex
+}
+
+/**@brief create a server without services
+ * @param void
+ * @return *Server
+ */
+func NewServer() *Server {
+  return &Server{methods: make(map[string]*method)}
+}
+
+/**@brief register the net/rpc style methods of rcvr, the service name is
+ *        its type name as with rpc.Register
+ * @param rcvr
+ * @return error
+ */
+func (s *Server) Register(rcvr interface{}) error {
+  var typ reflect.Type = reflect.TypeOf(rcvr)
+  var name string = reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+  var count int
+
+  pkg, known := ProtoPackage[name]
+  if !known {
+    return lsplog.MakeErr("grpcserver: no proto package for " + name)
+  }
+
+  s.lock.Lock()
+  defer s.lock.Unlock()
+
+  for i := 0; i < typ.NumMethod(); i++ {
+    fn := typ.Method(i)
+    mt := fn.Type
+    if !fn.IsExported() || mt.NumIn() != 3 || mt.NumOut() != 1 ||
+       mt.In(1).Kind() != reflect.Ptr || mt.In(2).Kind() != reflect.Ptr ||
+       mt.Out(0) != errorType {
+      continue
+    }
+    s.methods["/" + pkg + "." + name + "/" + fn.Name] = &method{
+        reflect.ValueOf(rcvr), fn, mt.In(1).Elem(), mt.In(2).Elem()}
+    count++
+  }
+
+  if count == 0 {
+    return lsplog.MakeErr("grpcserver: " + name + " has no rpc methods")
+  }
+
+  return nil
+}
+
+/**@brief finish a call, gRPC puts the status in the trailers
+ * @param w
+ * @param code
+ * @param msg
+ * @return void
+ */
+func finish(w http.ResponseWriter, code int, msg string) {
+  w.Header().Set(http.TrailerPrefix + "Grpc-Status", strconv.Itoa(code))
+  if msg != "" {
+    w.Header().Set(http.TrailerPrefix + "Grpc-Message", percentEncode(msg))
+  }
+}
+
+/**@brief grpc-message encoding: printable ASCII except '%' as is, every
+ *        other byte as %XX
+ * @param msg
+ * @return string
+ */
+func percentEncode(msg string) string {
+  var b strings.Builder
+
+  for i := 0; i < len(msg); i++ {
+    c := msg[i]
+    if c >= 0x20 && c <= 0x7e && c != '%' {
+      b.WriteByte(c)
+    } else {
+      fmt.Fprintf(&b, "%%%02X", c)
+    }
+  }
+
+  return b.String()
+}
+
+/**@brief read the single length-prefixed message of a unary call
+ * @param r
+ * @return []byte
+ * @return int gRPC code if the message is unusable
+ * @return error
+ */
+func readMessage(r io.Reader) ([]byte, int, error) {
+  var prefix [5]byte
+
+  if _, err := io.ReadFull(r, prefix[:]); err != nil {
+    return nil, CODE_INVALID_ARGUMENT, err
+  }
+  if prefix[0] != 0 {
+    return nil, CODE_UNIMPLEMENTED,
+        lsplog.MakeErr("compressed messages are not supported")
+  }
+
+  size := binary.BigEndian.Uint32(prefix[1:])
+  if size > MAX_MESSAGE {
+    return nil, CODE_INVALID_ARGUMENT, lsplog.MakeErr("message too large")
+  }
+
+  data := make([]byte, size)
+  if _, err := io.ReadFull(r, data); err != nil {
+    return nil, CODE_INVALID_ARGUMENT, err
+  }
+
+  return data, CODE_OK, nil
+}
+
+func frame(data []byte) []byte {
+  var buf []byte = make([]byte, 5, 5 + len(data))
+
+  binary.BigEndian.PutUint32(buf[1:], uint32(len(data)))
+  return append(buf, data...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" ||
+     !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+    http.Error(w, "gRPC only", http.StatusUnsupportedMediaType)
+    return
+  }
+
+  w.Header().Set("Content-Type", "application/grpc+proto")
+
+  s.lock.RLock()
+  m, present := s.methods[r.URL.Path]
+  s.lock.RUnlock()
+  if !present {
+    finish(w, CODE_UNIMPLEMENTED, "unknown method " + r.URL.Path)
+    return
+  }
+
+  data, code, err := readMessage(r.Body)
+  if err != nil {
+    finish(w, code, err.Error())
+    return
+  }
+
+  args := reflect.New(m.args)
+  reply := reflect.New(m.reply)
+  if err = Unmarshal(data, args.Interface()); err != nil {
+    finish(w, CODE_INVALID_ARGUMENT, err.Error())
+    return
+  }
+
+  //same handler net/rpc would call
+  out := m.fn.Func.Call([]reflect.Value{m.rcvr, args, reply})
+  if e := out[0].Interface(); e != nil {
+    finish(w, CODE_UNKNOWN, e.(error).Error())
+    return
+  }
+
+  data, err = Marshal(reply.Interface())
+  if err != nil {
+    finish(w, CODE_INTERNAL, err.Error())
+    return
+  }
+
+  w.Write(frame(data))
+  finish(w, CODE_OK, "")
+}
+
+/**@brief serve gRPC on l over cleartext HTTP/2 until l is closed
+ * @param l
+ * @return error
+ */
+func (s *Server) Serve(l net.Listener) error {
+  var protocols http.Protocols
+  var hs *http.Server = &http.Server{Handler: s}
+
+  protocols.SetUnencryptedHTTP2(true)
+  hs.Protocols = &protocols
+
+  return hs.Serve(l)
+}
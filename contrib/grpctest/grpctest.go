@@ -0,0 +1,288 @@
+package main
+
+// Round-trip tests for the gRPC services: every proto message survives
+// encoding, and StorageRPC and Tribserver answer over gRPC exactly as they
+// do over net/rpc.  Runs against an embedded cluster, no servers needed.
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"regexp"
+	"time"
+	"P2-f12/contrib/embedded"
+	"P2-f12/contrib/grpcserver"
+	"P2-f12/contrib/tribimpl"
+	"P2-f12/official/storageproto"
+	"P2-f12/official/storagerpc"
+	"P2-f12/official/tribproto"
+)
+
+type TestFunc struct {
+	name string
+	f    func()
+}
+
+var testRegex *string = flag.String("t", "", "test to run")
+var passCount int
+var failCount int
+
+var cluster *embedded.Cluster
+var ts *tribimpl.Tribserver
+var storageGRPC *grpcserver.Client
+var tribGRPC *grpcserver.Client
+
+func fail(format string, args ...interface{}) {
+	fmt.Printf("FAIL: "+format+"\n", args...)
+	failCount++
+}
+
+// serve starts a gRPC server for rcvr on a free port.
+func serve(rcvr interface{}) *grpcserver.Client {
+	gs := grpcserver.NewServer()
+	if err := gs.Register(rcvr); err != nil {
+		log.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go gs.Serve(l)
+	return grpcserver.Dial(l.Addr().String())
+}
+
+// Every message with all fields set must come back unchanged.
+func testMessageRoundTrip() {
+	posted := time.Unix(1350000000, 123456789)
+	msgs := []interface{}{
+		&storageproto.GetArgs{"alice:T", true, "localhost:9000", true},
+		&storageproto.GetReply{storageproto.EKEYNOTFOUND, "value",
+			storageproto.LeaseStruct{true, 10}, 42, true, []byte{1, 2, 0}},
+		&storageproto.GetListReply{storageproto.OK, []string{"a", "", "c"},
+			storageproto.LeaseStruct{false, 0}, 7, false, nil},
+		&storageproto.PutArgs{"k", "v"},
+		&storageproto.PutReply{storageproto.EITEMEXISTS, 1 << 40},
+		&storageproto.RegisterArgs{storageproto.Node{"localhost:1", 4000000000}},
+		&storageproto.RegisterReply{true, []storageproto.Node{
+			{"localhost:1", 1}, {"localhost:2", 2}}},
+		&storageproto.GetServersArgs{},
+		&storageproto.RevokeLeaseArgs{"alice:T"},
+		&storageproto.RevokeLeaseReply{-1},
+		&tribproto.CreateUserArgs{"alice"},
+		&tribproto.PostTribbleArgs{"alice", "hello\x00world"},
+		&tribproto.SubscriptionArgs{"alice", "bob"},
+		&tribproto.GetSubscriptionsReply{tribproto.OK, []string{"bob", "carol"}},
+		&tribproto.GetTribblesArgs{"alice", 20, "cursor"},
+		&tribproto.GetHashtagArgs{"#Ünïcode", 5, ""},
+		&tribproto.SearchArgs{"old tribble", true, 10, "c"},
+		&tribproto.GetTribblesReply{tribproto.OK, []tribproto.Tribble{
+			{Userid: "bob", Posted: posted, Contents: "first", Id: "7",
+				Edited: posted.Add(time.Minute)},
+			{Userid: "carol", Posted: posted.Add(time.Second), Id: "8",
+				Deleted: true},
+			{Userid: "dave", Posted: posted, Id: "11", RepostOf: "7",
+				RepostAuthor: "bob", Reposted: "first", Likes: 3}}, "next"},
+		&tribproto.PostTribbleReply{tribproto.OK, "9"},
+		&tribproto.EditTribbleArgs{"alice", "9", "fixed"},
+		&tribproto.DeleteTribbleReply{tribproto.ENOTOWNER},
+		&tribproto.PostReplyArgs{"bob", "9", "re"},
+		&tribproto.RetribbleArgs{"carol", "9"},
+		&tribproto.QuoteTribbleArgs{"carol", "9", "so true"},
+		&tribproto.LikeArgs{"dave", "9"},
+		&tribproto.GetLikesReply{tribproto.OK, []string{"dave", "erin"}},
+		&tribproto.GetFollowersArgs{"bob", 2, "c"},
+		&tribproto.GetFollowersReply{tribproto.OK, []string{"erin", "dave"}, 3, "d"},
+		&tribproto.GetThreadReply{tribproto.OK,
+			tribproto.Tribble{Userid: "alice", Posted: posted, Id: "9"},
+			[]tribproto.Tribble{{Userid: "bob", Posted: posted, Id: "10",
+				ReplyTo: "9", Thread: "9"}}, "more"},
+	}
+
+	for _, msg := range msgs {
+		data, err := grpcserver.Marshal(msg)
+		if err != nil {
+			fail("marshal %T: %v", msg, err)
+			return
+		}
+		back := reflect.New(reflect.TypeOf(msg).Elem()).Interface()
+		if err = grpcserver.Unmarshal(data, back); err != nil {
+			fail("unmarshal %T: %v", msg, err)
+			return
+		}
+		//times decode in the local zone, compare encodings for those
+		again, _ := grpcserver.Marshal(back)
+		if !bytes.Equal(data, again) {
+			fail("%T changed: %+v -> %+v", msg, msg, back)
+			return
+		}
+		switch msg.(type) {
+		case *tribproto.GetTribblesReply, *tribproto.GetThreadReply:
+		default:
+			if !reflect.DeepEqual(msg, back) {
+				fail("%T changed: %+v -> %+v", msg, msg, back)
+				return
+			}
+		}
+	}
+	fmt.Println("PASS")
+	passCount++
+}
+
+// StorageRPC over gRPC must give the same replies as the handler itself.
+func testStorageRoundTrip() {
+	direct := storagerpc.NewStorageRPC(cluster.Servers[0])
+	var put storageproto.PutReply
+	var get, want storageproto.GetReply
+	var list, wantList storageproto.GetListReply
+
+	if err := storageGRPC.Call("StorageRPC.Put",
+		&storageproto.PutArgs{"grpc:v", "value"}, &put); err != nil {
+		fail("Put: %v", err)
+		return
+	}
+	args := &storageproto.GetArgs{"grpc:v", false, "", false}
+	storageGRPC.Call("StorageRPC.Get", args, &get)
+	direct.Get(args, &want)
+	if !reflect.DeepEqual(get, want) || get.Value != "value" {
+		fail("Get over gRPC %+v, direct %+v", get, want)
+		return
+	}
+
+	storageGRPC.Call("StorageRPC.Put", &storageproto.PutArgs{"grpc:l", ""}, &put)
+	for _, item := range []string{"x", "y", "x"} {
+		storageGRPC.Call("StorageRPC.AppendToList",
+			&storageproto.PutArgs{"grpc:l", item}, &put)
+	}
+	if put.Status != storageproto.EITEMEXISTS {
+		fail("duplicate append returned %d", put.Status)
+		return
+	}
+	storageGRPC.Call("StorageRPC.RemoveFromList",
+		&storageproto.PutArgs{"grpc:l", "x"}, &put)
+	args = &storageproto.GetArgs{"grpc:l", false, "", false}
+	storageGRPC.Call("StorageRPC.GetList", args, &list)
+	direct.GetList(args, &wantList)
+	if !reflect.DeepEqual(list, wantList) || len(list.Value) != 1 {
+		fail("GetList over gRPC %+v, direct %+v", list, wantList)
+		return
+	}
+
+	var missing storageproto.GetReply
+	storageGRPC.Call("StorageRPC.Get",
+		&storageproto.GetArgs{"grpc:nothing", false, "", false}, &missing)
+	if missing.Status != storageproto.EKEYNOTFOUND {
+		fail("missing key returned %d", missing.Status)
+		return
+	}
+	fmt.Println("PASS")
+	passCount++
+}
+
+// Tribserver over gRPC must give the same replies as the handler itself.
+func testTribserverRoundTrip() {
+	var create tribproto.CreateUserReply
+	var sub tribproto.SubscriptionReply
+	var post tribproto.PostTribbleReply
+	var got, want tribproto.GetTribblesReply
+	var subs tribproto.GetSubscriptionsReply
+
+	for _, user := range []string{"galice", "gbob"} {
+		tribGRPC.Call("Tribserver.CreateUser",
+			&tribproto.CreateUserArgs{user}, &create)
+		if create.Status != tribproto.OK {
+			fail("CreateUser %s returned %d", user, create.Status)
+			return
+		}
+	}
+	tribGRPC.Call("Tribserver.CreateUser",
+		&tribproto.CreateUserArgs{"galice"}, &create)
+	if create.Status != tribproto.EEXISTS {
+		fail("duplicate CreateUser returned %d", create.Status)
+		return
+	}
+
+	tribGRPC.Call("Tribserver.AddSubscription",
+		&tribproto.SubscriptionArgs{"galice", "gbob"}, &sub)
+	tribGRPC.Call("Tribserver.GetSubscriptions",
+		&tribproto.GetSubscriptionsArgs{"galice"}, &subs)
+	if len(subs.Userids) != 1 || subs.Userids[0] != "gbob" {
+		fail("GetSubscriptions returned %v", subs.Userids)
+		return
+	}
+
+	tribGRPC.Call("Tribserver.PostTribble",
+		&tribproto.PostTribbleArgs{"gbob", "over grpc"}, &post)
+	args := &tribproto.GetTribblesArgs{Userid: "galice"}
+	if err := tribGRPC.Call("Tribserver.GetTribblesBySubscription",
+		args, &got); err != nil {
+		fail("GetTribblesBySubscription: %v", err)
+		return
+	}
+	ts.GetTribblesBySubscription(args, &want)
+	if len(got.Tribbles) != 1 || len(want.Tribbles) != 1 ||
+		got.Tribbles[0].Contents != want.Tribbles[0].Contents ||
+		!got.Tribbles[0].Posted.Equal(want.Tribbles[0].Posted) {
+		fail("tribbles over gRPC %+v, direct %+v", got, want)
+		return
+	}
+
+	tribGRPC.Call("Tribserver.GetTribbles",
+		&tribproto.GetTribblesArgs{Userid: "nobody"}, &got)
+	if got.Status != tribproto.ENOSUCHUSER {
+		fail("GetTribbles of unknown user returned %d", got.Status)
+		return
+	}
+	fmt.Println("PASS")
+	passCount++
+}
+
+// Methods that do not exist must fail with a gRPC error.
+func testUnknownMethod() {
+	var reply storageproto.GetReply
+	err := storageGRPC.Call("StorageRPC.Delete",
+		&storageproto.GetArgs{}, &reply)
+	if err == nil {
+		fail("unknown method did not fail")
+		return
+	}
+	fmt.Println("PASS")
+	passCount++
+}
+
+func main() {
+	tests := []TestFunc{
+		TestFunc{"testMessageRoundTrip", testMessageRoundTrip},
+		TestFunc{"testStorageRoundTrip", testStorageRoundTrip},
+		TestFunc{"testTribserverRoundTrip", testTribserverRoundTrip},
+		TestFunc{"testUnknownMethod", testUnknownMethod},
+	}
+
+	flag.Parse()
+
+	var err error
+	cluster, err = embedded.NewCluster(1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cluster.Close()
+	store, err := cluster.NewLibstore(0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ts = tribimpl.NewTribserverWithStore(store)
+
+	storageGRPC = serve(storagerpc.NewStorageRPC(cluster.Servers[0]))
+	tribGRPC = serve(ts)
+
+	for _, t := range tests {
+		if b, err := regexp.MatchString(*testRegex, t.name); b && err == nil {
+			fmt.Println("Starting " + t.name + ":")
+			t.f()
+		}
+	}
+
+	fmt.Printf("Passed (%d/%d) tests\n", passCount, passCount+failCount)
+}
@@ -0,0 +1,82 @@
+/** @file compress.go
+ *  @brief compressed replies from the storage server, kept compressed in
+ *         the lease cache and unpacked on every read
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+
+package libstore
+
+import (
+  "sync/atomic"
+  "P2-f12/contrib/packing"
+  "P2-f12/official/storageproto"
+)
+
+// cache data of replies that arrived compressed
+type packedValue []byte
+type packedList []byte
+
+/**@brief what to cache for a Get reply
+ * @param reply
+ * @return interface{} string or packedValue
+ */
+func (ls *Libstore) valueData(reply *storageproto.GetReply) interface{} {
+  if !reply.Compressed {
+    return reply.Value
+  }
+
+  return packedValue(reply.Packed)
+}
+
+/**@brief what to cache for a GetList reply
+ * @param reply
+ * @return interface{} []string or packedList
+ */
+func (ls *Libstore) listData(reply *storageproto.GetListReply) interface{} {
+  if !reply.Compressed {
+    return reply.Value
+  }
+
+  return packedList(reply.Packed)
+}
+
+/**@brief the value held by a reply or cache entry, counts the bytes
+ *        unpacked for Stats
+ * @param data string or packedValue
+ * @return string
+ * @return error
+ */
+func (ls *Libstore) unpackValue(data interface{}) (string, error) {
+  switch v := data.(type) {
+  case packedValue:
+    value, err := packing.UnpackString(v)
+    if err == nil {
+      atomic.AddUint64(&ls.packedBytes, uint64(len(v)))
+      atomic.AddUint64(&ls.unpackedBytes, uint64(len(value)))
+    }
+    return value, err
+  }
+
+  return data.(string), nil
+}
+
+/**@brief the list held by a reply or cache entry, counts the bytes
+ *        unpacked for Stats
+ * @param data []string or packedList
+ * @return []string
+ * @return error
+ */
+func (ls *Libstore) unpackList(data interface{}) ([]string, error) {
+  switch v := data.(type) {
+  case packedList:
+    list, err := packing.UnpackList(v)
+    if err == nil {
+      atomic.AddUint64(&ls.packedBytes, uint64(len(v)))
+      atomic.AddUint64(&ls.unpackedBytes, uint64(packing.ListSize(list)))
+    }
+    return list, err
+  }
+
+  return data.([]string), nil
+}
@@ -0,0 +1,404 @@
+/** @file crypt.go
+ *  @brief client-side AES-GCM encryption of values and list items
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+
+package libstore
+
+import (
+  "crypto/aes"
+  "crypto/cipher"
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "strings"
+  "sync"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/storageproto"
+)
+
+// Encrypted strings look like ENC_PREFIX + key id + ":" + base64(nonce|data).
+// Anything else read from storage is returned as is, so data written before
+// encryption was turned on stays readable.
+const ENC_PREFIX = "#enc:"
+
+/**
+ *  @brief one AES key, nonceKey derives the nonces of list items
+ */
+type ringKey struct {
+  aead cipher.AEAD
+  nonceKey []byte
+}
+
+/**
+ *  @brief the AES keys libstore may decrypt with, new data is always
+ *         encrypted with the current one. To rotate, Add a key, Use it,
+ *         rewrite old data with ReencryptValue/ReencryptList and Remove
+ *         the old key.
+ */
+type Keyring struct {
+  keys map[string]*ringKey
+  order []string
+  current string
+  lock sync.RWMutex
+}
+
+/**@brief create an empty keyring
+ * @param void
+ * @return *Keyring
+ */
+func NewKeyring() *Keyring {
+  return &Keyring{keys: make(map[string]*ringKey)}
+}
+
+/**@brief add an AES-128/192/256 key, the first key added becomes current
+ * @param id short name stored with every ciphertext, may not contain ':'
+ * @param key 16, 24 or 32 bytes
+ * @return error
+ */
+func (kr *Keyring) Add(id string, key []byte) error {
+  var mac = hmac.New(sha256.New, key)
+
+  if id == "" || strings.Contains(id, ":") {
+    return lsplog.MakeErr("Keyring.Add(): bad key id " + id)
+  }
+
+  block, err := aes.NewCipher(key)
+  if err != nil {
+    return err
+  }
+  aead, err := cipher.NewGCM(block)
+  if err != nil {
+    return err
+  }
+
+  //separate key for nonces so the AES key is only used by AES
+  mac.Write([]byte("libstore list nonce"))
+
+  kr.lock.Lock()
+  defer kr.lock.Unlock()
+
+  if _, present := kr.keys[id]; present {
+    return lsplog.MakeErr("Keyring.Add(): duplicate key id " + id)
+  }
+  kr.keys[id] = &ringKey{aead, mac.Sum(nil)}
+  kr.order = append(kr.order, id)
+  if kr.current == "" {
+    kr.current = id
+  }
+
+  return nil
+}
+
+/**@brief encrypt new data with key id from now on
+ * @param id
+ * @return error
+ */
+func (kr *Keyring) Use(id string) error {
+  kr.lock.Lock()
+  defer kr.lock.Unlock()
+
+  if _, present := kr.keys[id]; !present {
+    return lsplog.MakeErr("Keyring.Use(): unknown key id " + id)
+  }
+  kr.current = id
+
+  return nil
+}
+
+/**@brief forget a key, data still encrypted with it becomes unreadable
+ * @param id
+ * @return error
+ */
+func (kr *Keyring) Remove(id string) error {
+  kr.lock.Lock()
+  defer kr.lock.Unlock()
+
+  if id == kr.current {
+    return lsplog.MakeErr("Keyring.Remove(): key " + id + " is in use")
+  }
+  delete(kr.keys, id)
+  for i, v := range kr.order {
+    if v == id {
+      kr.order = append(kr.order[:i:i], kr.order[i + 1:]...)
+      break
+    }
+  }
+
+  return nil
+}
+
+/**@brief current key id first, then the others in the order added
+ * @param void
+ * @return []string
+ */
+func (kr *Keyring) ids() []string {
+  var ids []string
+
+  kr.lock.RLock()
+  defer kr.lock.RUnlock()
+
+  ids = append(ids, kr.current)
+  for _, id := range kr.order {
+    if id != kr.current {
+      ids = append(ids, id)
+    }
+  }
+
+  return ids
+}
+
+/**@brief encrypt plain with key id. The storage key is authenticated with
+ *        it so a ciphertext cannot be moved to another key. With
+ *        deterministic set the nonce is derived from key and plain, equal
+ *        list items then encrypt to equal strings and the storage server
+ *        can still find duplicates and items to remove.
+ * @param id
+ * @param key storage key
+ * @param plain
+ * @param deterministic
+ * @return string
+ * @return error
+ */
+func (kr *Keyring) seal(id, key, plain string,
+    deterministic bool) (string, error) {
+  var nonce []byte
+
+  kr.lock.RLock()
+  rk, present := kr.keys[id]
+  kr.lock.RUnlock()
+  if !present {
+    return "", lsplog.MakeErr("seal(): unknown key id " + id)
+  }
+
+  if deterministic {
+    mac := hmac.New(sha256.New, rk.nonceKey)
+    mac.Write([]byte(key))
+    mac.Write([]byte{0})
+    mac.Write([]byte(plain))
+    nonce = mac.Sum(nil)[:rk.aead.NonceSize()]
+  } else {
+    nonce = make([]byte, rk.aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+      return "", err
+    }
+  }
+
+  data := rk.aead.Seal(nonce, nonce, []byte(plain), []byte(key))
+
+  return ENC_PREFIX + id + ":" + base64.RawStdEncoding.EncodeToString(data),
+      nil
+}
+
+/**@brief decrypt a string read from storage, strings that are not
+ *        encrypted are returned unchanged
+ * @param key storage key
+ * @param stored
+ * @return string
+ * @return error
+ */
+func (kr *Keyring) open(key, stored string) (string, error) {
+  if !strings.HasPrefix(stored, ENC_PREFIX) {
+    return stored, nil
+  }
+
+  id, enc, found := strings.Cut(stored[len(ENC_PREFIX):], ":")
+  if !found {
+    return "", lsplog.MakeErr("open(): malformed value for " + key)
+  }
+
+  kr.lock.RLock()
+  rk, present := kr.keys[id]
+  kr.lock.RUnlock()
+  if !present {
+    return "", lsplog.MakeErr("open(): unknown key id " + id + " for " + key)
+  }
+
+  data, err := base64.RawStdEncoding.DecodeString(enc)
+  if err != nil || len(data) < rk.aead.NonceSize() {
+    return "", lsplog.MakeErr("open(): malformed value for " + key)
+  }
+
+  plain, err := rk.aead.Open(nil, data[:rk.aead.NonceSize()],
+      data[rk.aead.NonceSize():], []byte(key))
+  if err != nil {
+    return "", lsplog.MakeErr("open(): cannot decrypt " + key)
+  }
+
+  return string(plain), nil
+}
+
+/**@brief whether stored was written with key id
+ * @param id
+ * @param stored
+ * @return bool
+ */
+func sealedWith(id, stored string) bool {
+  return strings.HasPrefix(stored, ENC_PREFIX + id + ":")
+}
+
+/**@brief install a keyring, nil turns encryption off
+ * @param kr
+ * @return void
+ */
+func (ls *Libstore) iSetKeyring(kr *Keyring) {
+  ls.keyLock.Lock()
+  ls.keys = kr
+  ls.keyLock.Unlock()
+}
+
+func (ls *Libstore) keyring() *Keyring {
+  ls.keyLock.Lock()
+  defer ls.keyLock.Unlock()
+
+  return ls.keys
+}
+
+/**@brief encrypt a value for Put with the current key. "" is stored as
+ *        is, the storage server turns a first Put of "" into an empty list.
+ * @param key
+ * @param value
+ * @return string
+ * @return error
+ */
+func (ls *Libstore) sealValue(key, value string) (string, error) {
+  var kr *Keyring = ls.keyring()
+
+  if kr == nil || value == "" {
+    return value, nil
+  }
+
+  return kr.seal(kr.ids()[0], key, value, false)
+}
+
+/**@brief decrypt a value read from storage or the cache
+ * @param key
+ * @param value
+ * @return string
+ * @return error
+ */
+func (ls *Libstore) openValue(key, value string) (string, error) {
+  var kr *Keyring = ls.keyring()
+
+  if kr == nil {
+    return value, nil
+  }
+
+  return kr.open(key, value)
+}
+
+/**@brief decrypt a list into a new slice, the cached one stays untouched
+ * @param key
+ * @param list
+ * @return []string
+ * @return error
+ */
+func (ls *Libstore) openList(key string, list []string) ([]string, error) {
+  var kr *Keyring = ls.keyring()
+  var plain []string
+  var err error
+
+  if kr == nil {
+    return list, nil
+  }
+
+  plain = make([]string, len(list))
+  for i, v := range list {
+    plain[i], err = kr.open(key, v)
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  return plain, nil
+}
+
+/**@brief every string item may be stored as: encrypted with each key,
+ *        current first, and finally in plaintext
+ * @param key
+ * @param item
+ * @return []string
+ * @return error
+ */
+func (ls *Libstore) itemForms(key, item string) ([]string, error) {
+  var kr *Keyring = ls.keyring()
+  var forms []string
+
+  if kr == nil {
+    return []string{item}, nil
+  }
+
+  for _, id := range kr.ids() {
+    sealed, err := kr.seal(id, key, item, true)
+    if err != nil {
+      return nil, err
+    }
+    forms = append(forms, sealed)
+  }
+
+  return append(forms, item), nil
+}
+
+/**@brief rewrite a value with the current key
+ * @param key
+ * @return error
+ */
+func (ls *Libstore) iReencryptValue(key string) error {
+  value, err := ls.iGet(key)
+  if err != nil {
+    return err
+  }
+
+  _, err = ls.put(key, value)
+  return err
+}
+
+/**@brief rewrite every list item not encrypted with the current key
+ * @param key
+ * @return error
+ */
+func (ls *Libstore) iReencryptList(key string) error {
+  var kr *Keyring = ls.keyring()
+  var current string
+
+  if kr == nil {
+    return nil
+  }
+  current = kr.ids()[0]
+
+  list, _, err := ls.fetchListAtLeast(key, 0)
+  if err != nil {
+    return err
+  }
+
+  for _, stored := range list {
+    if sealedWith(current, stored) {
+      continue
+    }
+
+    plain, err := kr.open(key, stored)
+    if err != nil {
+      return err
+    }
+    sealed, err := kr.seal(current, key, plain, true)
+    if err != nil {
+      return err
+    }
+
+    //add the new form before dropping the old one so the item never
+    //disappears for readers
+    _, err = ls.appendRaw(key, sealed)
+    if err != nil &&
+        err != MakeErr("AppendToList()", storageproto.EITEMEXISTS) {
+      return err
+    }
+    _, err = ls.removeRaw(key, stored)
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
@@ -0,0 +1,242 @@
+/** @file hedge.go
+ *  @brief hedged reads: ask the owning node again over a second connection
+ *         when the first request is slow
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+
+package libstore
+
+import (
+  "reflect"
+  "sort"
+  "sync"
+  "sync/atomic"
+  "time"
+  "P2-f12/contrib/transport"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/storageproto"
+)
+
+const (
+  LATENCY_SAMPLES = 128     // recent read latencies kept for percentiles
+  LATENCY_MIN_SAMPLES = 16  // below this only the minimum delay is used
+)
+
+/**
+ *  @brief ring buffer of recent read latencies
+ */
+type latencyWindow struct {
+  samples [LATENCY_SAMPLES]time.Duration
+  count int
+  next int
+  lock sync.Mutex
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+  w.lock.Lock()
+  w.samples[w.next] = d
+  w.next = (w.next + 1) % LATENCY_SAMPLES
+  if w.count < LATENCY_SAMPLES {
+    w.count++
+  }
+  w.lock.Unlock()
+}
+
+/**@brief latency below which p percent of the recent reads finished
+ * @param p percentile, 0-100
+ * @return time.Duration
+ * @return bool false if there are too few samples
+ */
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+  var sorted []time.Duration
+  var idx int
+
+  w.lock.Lock()
+  if w.count < LATENCY_MIN_SAMPLES {
+    w.lock.Unlock()
+    return 0, false
+  }
+  sorted = make([]time.Duration, w.count)
+  copy(sorted, w.samples[:w.count])
+  w.lock.Unlock()
+
+  sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+  idx = int(p / 100 * float64(len(sorted)))
+  if idx >= len(sorted) {
+    idx = len(sorted) - 1
+  }
+  if idx < 0 {
+    idx = 0
+  }
+
+  return sorted[idx], true
+}
+
+/**@brief change the hedging percentile and minimum delay
+ * @param percentile
+ * @param minDelay
+ * @return void
+ */
+func (ls *Libstore) iSetHedging(percentile float64, minDelay time.Duration) {
+  if percentile < 0 {
+    percentile = 0
+  }
+  if percentile > 100 {
+    percentile = 100
+  }
+
+  ls.hedgeLock.Lock()
+  ls.hedgePercentile = percentile
+  ls.hedgeMinDelay = minDelay
+  ls.hedgeLock.Unlock()
+}
+
+/**@brief how long to wait for the first request before hedging
+ * @param void
+ * @return time.Duration
+ */
+func (ls *Libstore) hedgeDelay() time.Duration {
+  var percentile float64
+  var delay time.Duration
+
+  ls.hedgeLock.Lock()
+  percentile = ls.hedgePercentile
+  delay = ls.hedgeMinDelay
+  ls.hedgeLock.Unlock()
+
+  if d, ok := ls.latency.percentile(percentile); ok && d > delay {
+    delay = d
+  }
+
+  return delay
+}
+
+/**@brief second RPC connection to the svr-th node, dialed on first use,
+ *        so a hedged read does not queue behind the slow one
+ * @param svr index into ls.Nodes
+ * @return transport.Caller
+ * @return error
+ */
+func (ls *Libstore) hedgeConn(svr int) (transport.Caller, error) {
+  var err error
+
+  ls.connLock.Lock()
+  defer ls.connLock.Unlock()
+
+  if ls.HedgeConn[svr] == nil {
+    ls.HedgeConn[svr], err = ls.Trans.Dial(ls.Nodes[svr].HostPort)
+    if lsplog.CheckReport(1, err) {
+      return nil, err
+    }
+  }
+
+  return ls.HedgeConn[svr], nil
+}
+
+/**@brief call over cli and record how long a successful call took
+ * @param cli
+ * @param method
+ * @param args
+ * @param reply
+ * @return error
+ */
+func (ls *Libstore) timedCall(cli transport.Caller, method string,
+    args *storageproto.GetArgs, reply interface{}) error {
+  var start time.Time = time.Now()
+  var err error
+
+  err = cli.Call(method, args, reply)
+  if err == nil {
+    ls.latency.add(time.Since(start))
+  }
+
+  return err
+}
+
+/**@brief send a read to the node owning key, and again over a second
+ *        connection if the first fails or has not answered within
+ *        hedgeDelay. Only the first request may ask for a lease and only
+ *        the first reply is used, so the cache never records a second
+ *        lease for the same read; a lease granted to a losing first
+ *        request is left for storage to revoke.
+ * @param key
+ * @param method StorageRPC.Get or StorageRPC.GetList
+ * @param args
+ * @param reply *GetReply or *GetListReply, filled with the winning reply
+ * @return error
+ */
+func (ls *Libstore) readCall(key, method string,
+    args *storageproto.GetArgs, reply interface{}) error {
+  type result struct {
+    reply interface{}
+    err error
+  }
+
+  var svr int = ls.Part.Place(key, ls.Nodes)
+  var results chan result
+  var hedgeArgs storageproto.GetArgs
+  var res result
+  var outstanding int
+  var hedged bool
+
+  cli, err := ls.conn(svr)
+  if err != nil {
+    return err
+  }
+
+  if (ls.Flags & HEDGE_READS) == 0 {
+    return ls.timedCall(cli, method, args, reply)
+  }
+
+  //buffered so the losing call never blocks
+  results = make(chan result, 2)
+  send := func(cli transport.Caller, a *storageproto.GetArgs) {
+    r := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+    err := ls.timedCall(cli, method, a, r)
+    results <- result{r, err}
+  }
+
+  hedgeArgs = *args
+  hedgeArgs.WantLease = false
+
+  go send(cli, args)
+  outstanding = 1
+
+  timer := time.NewTimer(ls.hedgeDelay())
+  defer timer.Stop()
+
+  for outstanding > 0 {
+    select {
+    case res = <-results:
+      outstanding--
+      if res.err == nil {
+        reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(res.reply).Elem())
+        return nil
+      }
+      if hedged {
+        continue
+      }
+    case <-timer.C:
+      if hedged {
+        continue
+      }
+    }
+
+    //first request is slow or failed, ask again without a lease
+    hedged = true
+    hcli, err := ls.hedgeConn(svr)
+    if err != nil {
+      if outstanding == 0 {
+        return err
+      }
+      continue
+    }
+    outstanding++
+    atomic.AddUint64(&ls.hedgedReads, 1)
+    go send(hcli, &hedgeArgs)
+  }
+
+  return res.err
+}
@@ -0,0 +1,133 @@
+/** @file hedge_test.go
+ *  @brief tests of hedged reads against a storage server reached over a
+ *         connection that can be made slow
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package libstore_test
+
+import (
+  "sync"
+  "sync/atomic"
+  "testing"
+  "time"
+  "P2-f12/contrib/libstore"
+  "P2-f12/contrib/transport"
+)
+
+/**
+ *  @brief HTTP transport whose slow-th dialed connection takes delay longer
+ *         for every read while delay is set
+ */
+type slowTransport struct {
+  transport.HTTP
+  slow int
+  delay int64
+  dials int
+  lock sync.Mutex
+}
+
+type slowCaller struct {
+  transport.Caller
+  tr *slowTransport
+  slow bool
+}
+
+func (tr *slowTransport) Dial(hostport string) (transport.Caller, error) {
+  cli, err := tr.HTTP.Dial(hostport)
+  if err != nil {
+    return nil, err
+  }
+
+  tr.lock.Lock()
+  tr.dials++
+  slow := tr.dials == tr.slow
+  tr.lock.Unlock()
+
+  return &slowCaller{cli, tr, slow}, nil
+}
+
+func (tr *slowTransport) setDelay(d time.Duration) {
+  atomic.StoreInt64(&tr.delay, int64(d))
+}
+
+func (c *slowCaller) Call(method string, args, reply interface{}) error {
+  if c.slow && (method == "StorageRPC.Get" || method == "StorageRPC.GetList") {
+    time.Sleep(time.Duration(atomic.LoadInt64(&c.tr.delay)))
+  }
+
+  return c.Caller.Call(method, args, reply)
+}
+
+/**@brief slow reads are answered by the hedge without a lease, fast ones
+ *        by the first request with exactly one lease
+ * @param t
+ * @return void
+ */
+func TestHedgedReads(t *testing.T) {
+  master := newStorage(t)
+  writer := newLibstore(t, master, libstore.NONE)
+
+  //the master is dialed and closed first, then the connection for reads
+  tr := &slowTransport{slow: 2}
+  ls, err := libstore.NewLibstoreTransport(master, "localhost:0",
+      libstore.ALWAYS_LEASE | libstore.HEDGE_READS,
+      libstore.HashPartitioner{}, tr)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer ls.Close()
+  ls.SetHedging(95, 100 * time.Millisecond)
+
+  for _, key := range []string{"hedge:a", "hedge:b"} {
+    if err = writer.Put(key, key); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if err = writer.AppendToList("hedge:l", "x"); err != nil {
+    t.Fatal(err)
+  }
+
+  tr.setDelay(2 * time.Second)
+  start := time.Now()
+
+  if v, err := ls.Get("hedge:a"); err != nil || v != "hedge:a" {
+    t.Errorf("Get = %q, %v", v, err)
+  }
+  if l, err := ls.GetList("hedge:l"); err != nil || len(l) != 1 {
+    t.Errorf("GetList = %v, %v", l, err)
+  }
+  vals, errs := ls.MultiGet([]string{"hedge:a", "hedge:b"})
+  for i, v := range vals {
+    if errs[i] != nil || v != []string{"hedge:a", "hedge:b"}[i] {
+      t.Errorf("MultiGet[%d] = %q, %v", i, v, errs[i])
+    }
+  }
+
+  if d := time.Since(start); d > time.Second {
+    t.Errorf("slow reads took %v", d)
+  }
+  stats := ls.Stats()
+  if stats.HedgedReads != 4 {
+    t.Errorf("%d hedged reads, want 4", stats.HedgedReads)
+  }
+  if stats.LeasesGranted != 0 || stats.Hits != 0 {
+    t.Errorf("hedged reads left a lease: %+v", stats)
+  }
+
+  //the slow requests have answered, a fast one records a single lease
+  time.Sleep(2 * time.Second)
+  tr.setDelay(0)
+  for i := 0; i < 2; i++ {
+    if v, err := ls.Get("hedge:a"); err != nil || v != "hedge:a" {
+      t.Errorf("Get = %q, %v", v, err)
+    }
+  }
+  after := ls.Stats()
+  if after.HedgedReads != stats.HedgedReads {
+    t.Errorf("fast read hedged")
+  }
+  if after.LeasesGranted != 1 || after.Hits != 1 {
+    t.Errorf("stats after fast reads = %+v", after)
+  }
+}
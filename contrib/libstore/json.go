@@ -0,0 +1,319 @@
+/** @file json.go
+ *  @brief typed JSON values and lists on top of any KVStore
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+
+package libstore
+
+import (
+  "encoding/json"
+  "fmt"
+  "P2-f12/official/lsplog"
+)
+
+/**
+ *  @brief wrapper written by the *Schema helpers
+ */
+type schemaEnvelope struct {
+  Schema int
+  Data json.RawMessage
+}
+
+/**@brief build the error returned for undecodable data
+ * @param function
+ * @param key
+ * @param err
+ * @return error
+ */
+func jsonErr(function, key string, err error) error {
+  return lsplog.MakeErr(fmt.Sprintf("%s failed for %s: %s", function, key, err))
+}
+
+/**@brief the libstore under store, and the oldest version of key it may
+ *        return to store
+ * @param store
+ * @param key
+ * @return *Libstore
+ * @return uint64
+ * @return bool false if store is neither a libstore nor a session
+ */
+func libstoreOf(store KVStore, key string) (*Libstore, uint64, bool) {
+  switch s := store.(type) {
+  case *Libstore:
+    return s, 0, true
+  case *Session:
+    return s.ls, s.lastWrite(key), true
+  }
+  return nil, 0, false
+}
+
+/**@brief read key, with the storage version if store is a libstore or
+ *        session
+ * @param store
+ * @param key
+ * @return string
+ * @return uint64 0 if unknown
+ * @return error
+ */
+func readVersioned(store KVStore, key string) (string, uint64, error) {
+  if ls, min, ok := libstoreOf(store, key); ok {
+    return ls.readAtLeast(key, min)
+  }
+
+  raw, err := store.Get(key)
+  return raw, 0, err
+}
+
+/**@brief read list key, with the storage version if store is a libstore or
+ *        session
+ * @param store
+ * @param key
+ * @return []string
+ * @return uint64 0 if unknown
+ * @return error
+ */
+func readListVersioned(store KVStore, key string) ([]string, uint64, error) {
+  if ls, min, ok := libstoreOf(store, key); ok {
+    return ls.readListAtLeast(key, min)
+  }
+
+  raw, err := store.GetList(key)
+  return raw, 0, err
+}
+
+/**@brief decoded value cached with the lease of the version read
+ * @param store
+ * @param key
+ * @param slot
+ * @param version
+ * @return T
+ * @return bool
+ */
+func cachedDecoded[T any](store KVStore, key, slot string,
+    version uint64) (T, bool) {
+  var zero T
+
+  ls, _, ok := libstoreOf(store, key)
+  if !ok {
+    return zero, false
+  }
+
+  obj, ok := ls.Leases.Decoded(key, slot, version)
+  if !ok {
+    return zero, false
+  }
+
+  val, ok := obj.(T)
+  return val, ok
+}
+
+/**@brief keep the decoded value next to the lease of the version read
+ * @param store
+ * @param key
+ * @param slot
+ * @param version
+ * @param obj
+ * @return void
+ */
+func cacheDecoded(store KVStore, key, slot string, version uint64,
+    obj interface{}) {
+  if ls, _, ok := libstoreOf(store, key); ok {
+    ls.Leases.SetDecoded(key, slot, version, obj)
+  }
+}
+
+/**@brief unwrap a schema envelope and check its tag
+ * @param key
+ * @param raw
+ * @param schema
+ * @return []byte
+ * @return error
+ */
+func unwrapSchema(key, raw string, schema int) ([]byte, error) {
+  var env schemaEnvelope
+  var err error
+
+  err = json.Unmarshal([]byte(raw), &env)
+  if err != nil {
+    return nil, jsonErr("GetJSONSchema()", key, err)
+  }
+
+  if env.Schema != schema {
+    return nil, lsplog.MakeErr(fmt.Sprintf(
+        "GetJSONSchema() failed for %s: schema %d, want %d",
+        key, env.Schema, schema))
+  }
+
+  return env.Data, nil
+}
+
+/**@brief read key and decode it as a T
+ * @param store
+ * @param key
+ * @return T
+ * @return error
+ */
+func GetJSON[T any](store KVStore, key string) (T, error) {
+  var val T
+
+  raw, version, err := readVersioned(store, key)
+  if err != nil {
+    return val, err
+  }
+
+  if cached, ok := cachedDecoded[T](store, key, "json", version); ok {
+    return cached, nil
+  }
+
+  err = json.Unmarshal([]byte(raw), &val)
+  if err != nil {
+    return val, jsonErr("GetJSON()", key, err)
+  }
+
+  cacheDecoded(store, key, "json", version, val)
+
+  return val, nil
+}
+
+/**@brief encode val and store it under key
+ * @param store
+ * @param key
+ * @param val
+ * @return error
+ */
+func PutJSON[T any](store KVStore, key string, val T) error {
+  enc, err := json.Marshal(val)
+  if err != nil {
+    return jsonErr("PutJSON()", key, err)
+  }
+
+  return store.Put(key, string(enc))
+}
+
+/**@brief like GetJSON, for values written by PutJSONSchema; fails if the
+ *        stored schema tag is not schema
+ * @param store
+ * @param key
+ * @param schema
+ * @return T
+ * @return error
+ */
+func GetJSONSchema[T any](store KVStore, key string, schema int) (T, error) {
+  var val T
+  var data []byte
+  //only values that passed this schema check may come from the cache
+  var slot string = fmt.Sprintf("schema:%d", schema)
+
+  raw, version, err := readVersioned(store, key)
+  if err != nil {
+    return val, err
+  }
+
+  if cached, ok := cachedDecoded[T](store, key, slot, version); ok {
+    return cached, nil
+  }
+
+  data, err = unwrapSchema(key, raw, schema)
+  if err != nil {
+    return val, err
+  }
+
+  err = json.Unmarshal(data, &val)
+  if err != nil {
+    return val, jsonErr("GetJSONSchema()", key, err)
+  }
+
+  cacheDecoded(store, key, slot, version, val)
+
+  return val, nil
+}
+
+/**@brief like PutJSON, tagging the value with a schema version
+ * @param store
+ * @param key
+ * @param schema
+ * @param val
+ * @return error
+ */
+func PutJSONSchema[T any](store KVStore, key string, schema int, val T) error {
+  var env schemaEnvelope
+  var enc []byte
+  var err error
+
+  env.Schema = schema
+  env.Data, err = json.Marshal(val)
+  if err != nil {
+    return jsonErr("PutJSONSchema()", key, err)
+  }
+
+  enc, err = json.Marshal(env)
+  if err != nil {
+    return jsonErr("PutJSONSchema()", key, err)
+  }
+
+  return store.Put(key, string(enc))
+}
+
+/**@brief read a list and decode every item as a T, the slice may be shared
+ *        with the lease cache and must not be modified
+ * @param store
+ * @param key
+ * @return []T
+ * @return error
+ */
+func GetListJSON[T any](store KVStore, key string) ([]T, error) {
+  var vals []T
+
+  raw, version, err := readListVersioned(store, key)
+  if err != nil {
+    return nil, err
+  }
+
+  if cached, ok := cachedDecoded[[]T](store, key, "list", version); ok {
+    return cached, nil
+  }
+
+  vals = make([]T, len(raw))
+  for i, item := range raw {
+    err = json.Unmarshal([]byte(item), &vals[i])
+    if err != nil {
+      return nil, jsonErr("GetListJSON()", key, err)
+    }
+  }
+
+  cacheDecoded(store, key, "list", version, vals)
+
+  return vals, nil
+}
+
+/**@brief encode val and append it to a list, encoding is deterministic so
+ *        duplicates are still detected by the storage server
+ * @param store
+ * @param key
+ * @param val
+ * @return error
+ */
+func AppendToListJSON[T any](store KVStore, key string, val T) error {
+  enc, err := json.Marshal(val)
+  if err != nil {
+    return jsonErr("AppendToListJSON()", key, err)
+  }
+
+  return store.AppendToList(key, string(enc))
+}
+
+/**@brief encode val and remove it from a list
+ * @param store
+ * @param key
+ * @param val
+ * @return error
+ */
+func RemoveFromListJSON[T any](store KVStore, key string, val T) error {
+  enc, err := json.Marshal(val)
+  if err != nil {
+    return jsonErr("RemoveFromListJSON()", key, err)
+  }
+
+  return store.RemoveFromList(key, string(enc))
+}
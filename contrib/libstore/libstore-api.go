@@ -9,23 +9,78 @@ package libstore
 
 import (
 	"hash/fnv"
+	"time"
+	"P2-f12/contrib/transport"
 )
 
 // Debugging mode flags
 const (
 	NONE = 0
 	ALWAYS_LEASE = iota  // Request leases for every Get and GetList
+	RENEW_LEASES = 1 << iota  // Renew leases of hot keys before they expire
+	HEDGE_READS = 1 << iota   // Ask again when a read is slow
+	BINARY_TRANSPORT = 1 << iota  // Use transport.Binary instead of HTTP
 )
 
+// Lease renewal defaults, used when RENEW_LEASES is set.
+const (
+	RENEW_WINDOW_SECONDS = 2  // renew this long before the lease runs out
+	RENEW_HIT_THRESH     = 3  // only renew keys read this often per lease
+	RENEW_MIN_WINDOW_MS  = 100  // shorter windows are raised to this
+)
+
+// Hedged read defaults, used when HEDGE_READS is set.
+const (
+	HEDGE_PERCENTILE   = 95 // hedge reads slower than this latency percentile
+	HEDGE_MIN_DELAY_MS = 2  // but never sooner than this
+)
+
+// KVStore is the set of libstore operations the tribble server relies on.
+// *Libstore implements it; contrib/fakestore provides an in-memory fake.
+type KVStore interface {
+	Get(key string) (string, error)
+	Put(key, value string) error
+	GetList(key string) ([]string, error)
+	RemoveFromList(key, removeitem string) error
+	AppendToList(key, newitem string) error
+	Stats() Stats
+	Close() error
+}
+
 // NewLibstore creates a new instance of the libstore client (*Libstore),
 // telling it to contact _server_ as the master storage server.
 // Myhostport is the lease revocation callback port.
 //  - If "", the client will never request leases;
-//  - If set to a non-zero value (e.g., "localhost:port"), the caller
-//    must have an HTTP listener and RPC listener reachable at that port.
+//  - If set to a non-zero value (e.g., "localhost:port"), the libstore
+//    listens there with its own HTTP and RPC server.  Port 0 picks a free
+//    port; the address actually used is left in Libstore.Addr.
 // Flags is one of the debugging mode flags from above.
 func NewLibstore(server, myhostport string, flags int) (*Libstore, error) {
-	return iNewLibstore(server, myhostport, flags)
+	return iNewLibstore(server, myhostport, flags, HashPartitioner{},
+		flagTransport(flags))
+}
+
+// NewLibstorePartitioned is NewLibstore with a custom key placement
+// strategy.  All clients of one storage cluster must agree on it, and the
+// storage servers must be started with it too, see
+// storageimpl.NewStorageserverPartitioned.
+func NewLibstorePartitioned(server, myhostport string, flags int,
+	part Partitioner) (*Libstore, error) {
+	return iNewLibstore(server, myhostport, flags, part, flagTransport(flags))
+}
+
+// NewLibstoreTransport is NewLibstorePartitioned over a custom transport,
+// e.g. transport.Local to talk to storage servers in the same process.
+// BINARY_TRANSPORT is ignored.
+func NewLibstoreTransport(server, myhostport string, flags int,
+	part Partitioner, tr transport.Transport) (*Libstore, error) {
+	return iNewLibstore(server, myhostport, flags, part, tr)
+}
+
+// Close stops background work, the lease callback listener and all
+// connections to storage servers.
+func (ls *Libstore) Close() error {
+	return ls.iClose()
 }
 
 func (ls *Libstore) Get(key string) (string, error) {
@@ -48,6 +103,72 @@ func (ls *Libstore) AppendToList(key, newitem string) error {
 	return ls.iAppendToList(key, newitem)
 }
 
+// SetRenewal changes how close to expiry a lease is renewed and how many
+// cache hits under the current lease make a key hot enough to renew.
+// Only takes effect when the libstore was created with RENEW_LEASES.
+// Windows below RENEW_MIN_WINDOW_MS and thresholds below 1 are raised.
+func (ls *Libstore) SetRenewal(window time.Duration, thresh int) {
+	ls.iSetRenewal(window, thresh)
+}
+
+// Renewal returns the renewal window and threshold in effect.
+func (ls *Libstore) Renewal() (time.Duration, int) {
+	return ls.iRenewal()
+}
+
+// SetHedging changes after which latency percentile of recent reads a
+// read is sent again, and the minimum delay before doing so.  The second
+// request goes to the same node over another connection and asks for no
+// lease.  Only takes effect when the libstore was created with HEDGE_READS.
+func (ls *Libstore) SetHedging(percentile float64, minDelay time.Duration) {
+	ls.iSetHedging(percentile, minDelay)
+}
+
+// MultiGet reads several keys in parallel.  Values and errors are in the
+// same order as keys.
+func (ls *Libstore) MultiGet(keys []string) ([]string, []error) {
+	return ls.iMultiGet(keys)
+}
+
+// SetKeyring turns on client-side AES-GCM encryption of values and list
+// items with the current key of kr; nil turns it off.  Every libstore
+// sharing data must use the same keys.  List items are encrypted
+// deterministically so AppendToList and RemoveFromList keep working.
+func (ls *Libstore) SetKeyring(kr *Keyring) {
+	ls.iSetKeyring(kr)
+}
+
+// ReencryptValue rewrites the value of key with the current key.
+func (ls *Libstore) ReencryptValue(key string) error {
+	return ls.iReencryptValue(key)
+}
+
+// ReencryptList rewrites every item of the list at key that is not yet
+// encrypted with the current key.  Rewritten items move to the end.
+func (ls *Libstore) ReencryptList(key string) error {
+	return ls.iReencryptList(key)
+}
+
+// Stats counts cache and lease activity since the libstore was created.
+type Stats struct {
+	Hits          uint64 // Get/GetList answered from the lease cache
+	Misses        uint64 // Get/GetList that went to storage
+	LeaseRequests uint64 // requests sent with WantLease set
+	LeasesGranted uint64
+	Expirations   uint64 // leases that ran out without a revocation
+	Revocations   uint64 // RevokeLease callbacks received
+	Evictions     uint64 // cache entries dropped for lack of queries
+	HedgedReads   uint64 // reads sent a second time because they were slow
+
+	CompressedBytes   uint64  // compressed data received or read from cache
+	UncompressedBytes uint64  // the same data unpacked
+	CompressionRatio  float64 // UncompressedBytes / CompressedBytes
+}
+
+func (ls *Libstore) Stats() Stats {
+	return ls.iStats()
+}
+
 // Partitioning:  Defined here so that all implementations
 // use the same mechanism.
 
@@ -8,12 +8,14 @@ package libstore
 
 import (
   "fmt"
-  "net"
+  "io"
   "net/rpc"
   "sort"
-  "strings"
+  "sync"
+  "sync/atomic"
   "time"
   "P2-f12/contrib/cache"
+  "P2-f12/contrib/transport"
   "P2-f12/official/cacherpc"
   "P2-f12/official/lsplog"
   "P2-f12/official/storageproto"
@@ -23,13 +25,39 @@ type NodeList []storageproto.Node
 
 type Libstore struct {
   Nodes NodeList
-  RPCConn []*rpc.Client
+  RPCConn []transport.Caller
+  HedgeConn []transport.Caller //second connections for hedged reads
+  connLock sync.Mutex
+  Trans transport.Transport
 
-  LeaseConn net.Listener
+  LeaseConn io.Closer
+  RPCServer *rpc.Server
   Addr string
   Flags int
+  Part Partitioner
 
   Leases *cache.Cache
+
+  leaseRequests uint64
+  leasesGranted uint64
+  revocations uint64
+  hedgedReads uint64
+  packedBytes uint64
+  unpackedBytes uint64
+
+  latency latencyWindow
+  hedgeLock sync.Mutex
+  hedgePercentile float64
+  hedgeMinDelay time.Duration
+
+  keys *Keyring
+  keyLock sync.Mutex
+
+  renewLock sync.Mutex
+  renewWindow time.Duration
+  renewThresh int
+  quit chan bool
+  closeOnce sync.Once
 }
 
 var StatusName = map[int]string {
@@ -67,30 +95,43 @@ func (list NodeList) Less(i, j int) bool {
   return list[i].NodeID < list[j].NodeID
 }
 
+/**@brief the transport NewLibstore uses for flags
+ * @param flags
+ * @return transport.Transport
+ */
+func flagTransport(flags int) transport.Transport {
+  if (flags & BINARY_TRANSPORT) != 0 {
+    return transport.Binary{}
+  }
+
+  return transport.HTTP{}
+}
+
 /**@brief helper function for sorting  
  * @param server master storage server addr 
  * @param myhostport trib server's port  
  * @param flags 
+ * @param part key placement strategy
+ * @param tr how to reach storage servers and receive revocations
  * @return *Libstore 
  * @return error
  */
-func iNewLibstore(server, myhostport string, flags int) (*Libstore, error) {
+func iNewLibstore(server, myhostport string, flags int,
+    part Partitioner, tr transport.Transport) (*Libstore, error) {
   var store Libstore
-  var master *rpc.Client
+  var master transport.Caller
   var args storageproto.GetServersArgs
   var reply storageproto.RegisterReply
   var err error
 
   store.Addr = myhostport
   store.Flags = flags
-
-  if store.Addr != "" {
-    rpc.Register(cacherpc.NewCacheRPC(&store))
-  }
+  store.Part = part
+  store.Trans = tr
 
   lsplog.Vlogf(3, "libstore try to connect to master storage %s", server)
 
-  master, err = rpc.DialHTTP("tcp", server)
+  master, err = store.Trans.Dial(server)
   if lsplog.CheckReport(1, err) {
     return nil, err
   }
@@ -117,7 +158,8 @@ func iNewLibstore(server, myhostport string, flags int) (*Libstore, error) {
   }
 
   store.Nodes = reply.Servers
-  store.RPCConn = make([]*rpc.Client, len(store.Nodes))
+  store.RPCConn = make([]transport.Caller, len(store.Nodes))
+  store.HedgeConn = make([]transport.Caller, len(store.Nodes))
 
   sort.Sort(store.Nodes)
   /*
@@ -125,9 +167,24 @@ func iNewLibstore(server, myhostport string, flags int) (*Libstore, error) {
     fmt.Printf("%v\n", store.Nodes[i])
   }*/
 
+  if store.Addr != "" {
+    err = store.listenLeases()
+    if lsplog.CheckReport(1, err) {
+      return nil, err
+    }
+  }
+
+  //no lease is granted before the first Get, nothing can fail from here on
+  //so the reaper of the cache is never left behind
   store.Leases = cache.NewCache()
-  if lsplog.CheckReport(1, err) {
-    return nil, err
+
+  store.hedgePercentile = HEDGE_PERCENTILE
+  store.hedgeMinDelay = HEDGE_MIN_DELAY_MS * time.Millisecond
+  store.renewWindow = RENEW_WINDOW_SECONDS * time.Second
+  store.renewThresh = RENEW_HIT_THRESH
+  store.quit = make(chan bool)
+  if (store.Flags & RENEW_LEASES) != 0 && store.Addr != "" {
+    go store.renewer()
   }
 
   lsplog.Vlogf(3, "libstore create complete")
@@ -135,6 +192,90 @@ func iNewLibstore(server, myhostport string, flags int) (*Libstore, error) {
   return &store, nil
 }
 
+/**@brief start a private rpc server for lease revocations on ls.Addr, so
+ *        several libstores can live in one process
+ * @param void
+ * @return error
+ */
+func (ls *Libstore) listenLeases() error {
+  var err error
+
+  ls.RPCServer = rpc.NewServer()
+  err = ls.RPCServer.Register(cacherpc.NewCacheRPC(ls))
+  if lsplog.CheckReport(1, err) {
+    return err
+  }
+
+  //port 0 means pick one, tell storage servers the real one
+  ls.LeaseConn, ls.Addr, err = ls.Trans.Listen(ls.Addr, ls.RPCServer)
+  if lsplog.CheckReport(1, err) {
+    return err
+  }
+
+  lsplog.Vlogf(3, "libstore lease callbacks on %s", ls.Addr)
+
+  return nil
+}
+
+/**@brief shut down the libstore, safe to call more than once
+ * @param void
+ * @return error
+ */
+func (ls *Libstore) iClose() error {
+  var err error
+
+  ls.closeOnce.Do(func() {
+    close(ls.quit)
+    ls.Leases.Stop()
+
+    if ls.LeaseConn != nil {
+      err = ls.LeaseConn.Close()
+    }
+
+    ls.connLock.Lock()
+    for i := 0; i < len(ls.RPCConn); i++ {
+      if ls.RPCConn[i] != nil {
+        ls.RPCConn[i].Close()
+        ls.RPCConn[i] = nil
+      }
+      if ls.HedgeConn[i] != nil {
+        ls.HedgeConn[i].Close()
+        ls.HedgeConn[i] = nil
+      }
+    }
+    ls.connLock.Unlock()
+  })
+
+  return err
+}
+
+/**@brief snapshot of cache and lease counters
+ * @param void
+ * @return Stats
+ */
+func (ls *Libstore) iStats() Stats {
+  var stats Stats
+  var cstats cache.Stats = ls.Leases.Stats()
+
+  stats.Hits = cstats.Hits
+  stats.Misses = cstats.Misses
+  stats.Expirations = cstats.Expirations
+  stats.Evictions = cstats.Evictions
+
+  stats.LeaseRequests = atomic.LoadUint64(&ls.leaseRequests)
+  stats.LeasesGranted = atomic.LoadUint64(&ls.leasesGranted)
+  stats.Revocations = atomic.LoadUint64(&ls.revocations)
+  stats.HedgedReads = atomic.LoadUint64(&ls.hedgedReads)
+  stats.CompressedBytes = atomic.LoadUint64(&ls.packedBytes)
+  stats.UncompressedBytes = atomic.LoadUint64(&ls.unpackedBytes)
+  if stats.CompressedBytes > 0 {
+    stats.CompressionRatio =
+        float64(stats.UncompressedBytes) / float64(stats.CompressedBytes)
+  }
+
+  return stats
+}
+
 /**@brief helper function for sorting  
  * @param function 
  * @param status  
@@ -147,7 +288,7 @@ func MakeErr(function string, status int) lsplog.LspErr {
   return lsplog.MakeErr(str)
 }
 
-/**@brief Hashes a key and returns an RPC connection to the server 
+/**@brief Places a key with ls.Part and returns an RPC connection to the server 
           responsible for storing it. If an RPC connection is not 
           established, create one and store it for future accesses.  
  * @param server master server addr 
@@ -156,25 +297,28 @@ func MakeErr(function string, status int) lsplog.LspErr {
  * @return *Libstore 
  * @return error
  */
-func (ls *Libstore) GetServer(key string) (*rpc.Client, error) {
-  var id uint32
-  var svr int
-  var err error
-
+func (ls *Libstore) GetServer(key string) (transport.Caller, error) {
   //lsplog.Vlogf(3, "libstore GetServer Invoked")
 
-  id = Storehash(strings.Split(key, ":")[0])
+  //lsplog.Vlogf(0, "%s -> %d (%d)\n", key, id, svr)
 
-  // returns the index of the first server after the key's hash
-  svr = sort.Search(
-      len(ls.Nodes), func(i int) bool { return ls.Nodes[i].NodeID >= id })
-  svr = (svr) % len(ls.Nodes)
+  return ls.conn(ls.Part.Place(key, ls.Nodes))
+}
 
-  //lsplog.Vlogf(0, "%s -> %d (%d)\n", key, id, svr)
+/**@brief cached RPC connection to the svr-th node, dialed on first use
+ * @param svr index into ls.Nodes
+ * @return transport.Caller
+ * @return error
+ */
+func (ls *Libstore) conn(svr int) (transport.Caller, error) {
+  var err error
+
+  ls.connLock.Lock()
+  defer ls.connLock.Unlock()
 
   if ls.RPCConn[svr] == nil {
     lsplog.Vlogf(0, "Caching RPC connection to %s.\n", ls.Nodes[svr].HostPort)
-    ls.RPCConn[svr], err = rpc.DialHTTP("tcp", ls.Nodes[svr].HostPort)
+    ls.RPCConn[svr], err = ls.Trans.Dial(ls.Nodes[svr].HostPort)
     if lsplog.CheckReport(1, err) {
       return nil, err
     }
@@ -189,15 +333,82 @@ func (ls *Libstore) GetServer(key string) (*rpc.Client, error) {
  * @return error
  */
 func (ls *Libstore) iGet(key string) (string, error) {
-  var cli *rpc.Client
-  var args storageproto.GetArgs = storageproto.GetArgs{key, false, ls.Addr}
+  return ls.getAtLeast(key, 0)
+}
+
+/**@brief read several keys in parallel through the normal Get path, each
+ *        read is hedged on its own
+ * @param keys
+ * @return []string
+ * @return []error
+ */
+func (ls *Libstore) iMultiGet(keys []string) ([]string, []error) {
+  var vals []string = make([]string, len(keys))
+  var errs []error = make([]error, len(keys))
+  var wg sync.WaitGroup
+
+  for i := range keys {
+    wg.Add(1)
+    go func(i int) {
+      defer wg.Done()
+      vals[i], errs[i] = ls.iGet(keys[i])
+    }(i)
+  }
+  wg.Wait()
+
+  return vals, errs
+}
+
+/**@brief same as iGet, but cached data older than minVersion is not used
+ * @param key
+ * @param minVersion
+ * @return string
+ * @return error
+ */
+func (ls *Libstore) getAtLeast(
+    key string, minVersion uint64) (string, error) {
+  value, _, err := ls.readAtLeast(key, minVersion)
+  return value, err
+}
+
+/**@brief same as getAtLeast, also returning the storage version read
+ * @param key
+ * @param minVersion
+ * @return string
+ * @return uint64 0 if storage did not say
+ * @return error
+ */
+func (ls *Libstore) readAtLeast(
+    key string, minVersion uint64) (string, uint64, error) {
+  value, version, err := ls.fetchAtLeast(key, minVersion)
+  if err != nil {
+    return "", 0, err
+  }
+
+  value, err = ls.openValue(key, value)
+  return value, version, err
+}
+
+/**@brief the value as stored, still encrypted, from cache or storage
+ * @param key
+ * @param minVersion
+ * @return string
+ * @return uint64 its storage version
+ * @return error
+ */
+func (ls *Libstore) fetchAtLeast(
+    key string, minVersion uint64) (string, uint64, error) {
+  var args storageproto.GetArgs = storageproto.GetArgs{key, false, ls.Addr, true}
   var reply storageproto.GetReply
   var err error
 
   //try cache first
-  if tmp, err := ls.Leases.Get(key, &args); err == nil {
-    reply.Value = tmp.(string)
-    return reply.Value, nil
+  if tmp, version, err := ls.Leases.Get(key, minVersion, &args); err == nil {
+    if _, missing := tmp.(cache.NotFound); missing {
+      return "", 0, MakeErr("Get()", storageproto.EKEYNOTFOUND)
+    }
+    value, err := ls.unpackValue(tmp)
+    return value, version, err
   }
 
   if (ls.Flags & ALWAYS_LEASE) != 0 {
@@ -206,11 +417,6 @@ func (ls *Libstore) iGet(key string) (string, error) {
 
   //lsplog.Vlogf(0, "libstore Get %s\n", key)
 
-  cli, err = ls.GetServer(key)
-  if lsplog.CheckReport(1, err) {
-    return "", err
-  }
-
   //listen on no port to accept revoke
   if ls.Addr == "" {
     args.WantLease = false
@@ -218,23 +424,36 @@ func (ls *Libstore) iGet(key string) (string, error) {
 
   //lsplog.Vlogf(0, "Get args:%v\n", args)
 
-  err = cli.Call("StorageRPC.Get", &args, &reply)
+  if args.WantLease {
+    atomic.AddUint64(&ls.leaseRequests, 1)
+  }
+
+  err = ls.readCall(key, "StorageRPC.Get", &args, &reply)
   if lsplog.CheckReport(1, err) {
-    return "", err
+    return "", 0, err
   }
 
   //lsplog.Vlogf(0, "Get reply:%v#!!\n", reply)
   //fmt.Printf("Get reply granted:%v#!#\n", reply.Lease.Granted)
 
+  data := ls.valueData(&reply)
+
   if reply.Lease.Granted {
-    ls.Leases.LeaseGranted(key, reply.Value, reply.Lease)
+    atomic.AddUint64(&ls.leasesGranted, 1)
+    if reply.Status == storageproto.EKEYNOTFOUND {
+      ls.Leases.LeaseGranted(
+          key, cache.NotFound{}, reply.Version, reply.Lease)
+    } else {
+      ls.Leases.LeaseGranted(key, data, reply.Version, reply.Lease)
+    }
   }
 
   if reply.Status != storageproto.OK {
-    return "", MakeErr("Get()", reply.Status)
+    return "", 0, MakeErr("Get()", reply.Status)
   }
 
-  return reply.Value, nil
+  value, err := ls.unpackValue(data)
+  return value, reply.Version, err
 }
 
 /**@brief store key-value into backend 
@@ -243,16 +462,32 @@ func (ls *Libstore) iGet(key string) (string, error) {
  * @return error
  */
 func (ls *Libstore) iPut(key, value string) error {
-  var cli *rpc.Client
+  _, err := ls.put(key, value)
+  return err
+}
+
+/**@brief same as iPut, also returns the version the key has afterwards
+ * @param key
+ * @param value
+ * @return uint64
+ * @return error
+ */
+func (ls *Libstore) put(key, value string) (uint64, error) {
+  var cli transport.Caller
   var args storageproto.PutArgs = storageproto.PutArgs{key, value}
   var reply storageproto.PutReply
   var err error
 
   //lsplog.Vlogf(0, "libstore put %s->%s!", key, value)
 
+  args.Value, err = ls.sealValue(key, value)
+  if lsplog.CheckReport(1, err) {
+    return 0, err
+  }
+
   cli, err = ls.GetServer(key)
   if lsplog.CheckReport(1, err) {
-    return err
+    return 0, err
   }
 
   //lsplog.Vlogf(0, "libstore getserver complete!")
@@ -264,17 +499,17 @@ func (ls *Libstore) iPut(key, value string) error {
 
   err = cli.Call("StorageRPC.Put", &args, &reply)
   if lsplog.CheckReport(1, err) {
-    return err
+    return 0, err
   }
 
   //fmt.Printf("put reply %v\n", reply)
   //lsplog.Vlogf(0, "put reply %v\n", reply)
 
   if reply.Status != storageproto.OK {
-    return MakeErr("Put()", reply.Status)
+    return 0, MakeErr("Put()", reply.Status)
   }
 
-  return nil
+  return reply.Version, nil
 }
 
 /**@brief given a key, get list of strings  
@@ -283,44 +518,96 @@ func (ls *Libstore) iPut(key, value string) error {
  * @return error
  */
 func (ls *Libstore) iGetList(key string) ([]string, error) {
-  var cli *rpc.Client
-  var args storageproto.GetArgs = storageproto.GetArgs{key, false, ls.Addr}
+  return ls.getListAtLeast(key, 0)
+}
+
+/**@brief same as iGetList, but cached data older than minVersion is not used
+ * @param key
+ * @param minVersion
+ * @return []string
+ * @return error
+ */
+func (ls *Libstore) getListAtLeast(
+    key string, minVersion uint64) ([]string, error) {
+  list, _, err := ls.readListAtLeast(key, minVersion)
+  return list, err
+}
+
+/**@brief same as getListAtLeast, also returning the storage version read
+ * @param key
+ * @param minVersion
+ * @return []string
+ * @return uint64 0 if storage did not say
+ * @return error
+ */
+func (ls *Libstore) readListAtLeast(
+    key string, minVersion uint64) ([]string, uint64, error) {
+  list, version, err := ls.fetchListAtLeast(key, minVersion)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  list, err = ls.openList(key, list)
+  return list, version, err
+}
+
+/**@brief the list as stored, still encrypted, from cache or storage
+ * @param key
+ * @param minVersion
+ * @return []string
+ * @return uint64 its storage version
+ * @return error
+ */
+func (ls *Libstore) fetchListAtLeast(
+    key string, minVersion uint64) ([]string, uint64, error) {
+  var args storageproto.GetArgs = storageproto.GetArgs{key, false, ls.Addr, true}
   var reply storageproto.GetListReply
   var err error
 
   //try cache first
-  if tmp, err := ls.Leases.Get(key, &args); err == nil {
-    reply.Value = tmp.([]string)
-    return reply.Value, nil
+  if tmp, version, err := ls.Leases.Get(key, minVersion, &args); err == nil {
+    if _, missing := tmp.(cache.NotFound); missing {
+      return nil, 0, MakeErr("GetList()", storageproto.EKEYNOTFOUND)
+    }
+    list, err := ls.unpackList(tmp)
+    return list, version, err
   }
 
   if (ls.Flags & ALWAYS_LEASE) != 0 {
     args.WantLease = true
   }
 
-  cli, err = ls.GetServer(key)
-  if lsplog.CheckReport(1, err) {
-    return nil, err
-  }
-
   //lsplog.Vlogf(0, "GetList args %v", args)
 
-  err = cli.Call("StorageRPC.GetList", &args, &reply)
+  if args.WantLease {
+    atomic.AddUint64(&ls.leaseRequests, 1)
+  }
+
+  err = ls.readCall(key, "StorageRPC.GetList", &args, &reply)
   if lsplog.CheckReport(1, err) {
-    return nil, err
+    return nil, 0, err
   }
 
   //lsplog.Vlogf(0, "GetList reply %v", reply)
 
+  data := ls.listData(&reply)
+
   if reply.Lease.Granted {
-    ls.Leases.LeaseGranted(key, reply.Value, reply.Lease)
+    atomic.AddUint64(&ls.leasesGranted, 1)
+    if reply.Status == storageproto.EKEYNOTFOUND {
+      ls.Leases.LeaseGranted(
+          key, cache.NotFound{}, reply.Version, reply.Lease)
+    } else {
+      ls.Leases.LeaseGranted(key, data, reply.Version, reply.Lease)
+    }
   }
 
   if reply.Status != storageproto.OK {
-    return nil, MakeErr("GetList()", reply.Status)
+    return nil, 0, MakeErr("GetList()", reply.Status)
   }
 
-  return reply.Value, nil
+  list, err := ls.unpackList(data)
+  return list, reply.Version, err
 }
 
 /**@brief remove a item from backend storage   
@@ -329,26 +616,64 @@ func (ls *Libstore) iGetList(key string) ([]string, error) {
  * @return error
  */
 func (ls *Libstore) iRemoveFromList(key, removeitem string) error {
-  var cli *rpc.Client
+  _, err := ls.removeFromList(key, removeitem)
+  return err
+}
+
+/**@brief same as iRemoveFromList, also returns the version of the key
+ * @param key
+ * @param removeitem
+ * @return uint64
+ * @return error
+ */
+func (ls *Libstore) removeFromList(key, removeitem string) (uint64, error) {
+  var forms []string
+  var version uint64
+  var err error
+
+  //the item may still be encrypted with an older key, or not at all
+  forms, err = ls.itemForms(key, removeitem)
+  if err != nil {
+    return 0, err
+  }
+
+  for _, stored := range forms {
+    version, err = ls.removeRaw(key, stored)
+    if err != MakeErr("RemoveFromList()", storageproto.EITEMNOTFOUND) {
+      break
+    }
+  }
+
+  return version, err
+}
+
+/**@brief remove an item exactly as stored
+ * @param key
+ * @param removeitem
+ * @return uint64
+ * @return error
+ */
+func (ls *Libstore) removeRaw(key, removeitem string) (uint64, error) {
+  var cli transport.Caller
   var args storageproto.PutArgs = storageproto.PutArgs{key, removeitem}
   var reply storageproto.PutReply
   var err error
 
   cli, err = ls.GetServer(key)
   if lsplog.CheckReport(1, err) {
-    return err
+    return 0, err
   }
 
   err = cli.Call("StorageRPC.RemoveFromList", &args, &reply)
   if lsplog.CheckReport(1, err) {
-    return err
+    return 0, err
   }
 
   if reply.Status != storageproto.OK {
-    return MakeErr("RemoveFromList()", reply.Status)
+    return 0, MakeErr("RemoveFromList()", reply.Status)
   }
 
-  return nil
+  return reply.Version, nil
 }
 
 /**@brief append newitem to list  
@@ -357,30 +682,175 @@ func (ls *Libstore) iRemoveFromList(key, removeitem string) error {
  * @return error
  */
 func (ls *Libstore) iAppendToList(key, newitem string) error {
-  var cli *rpc.Client
+  _, err := ls.appendToList(key, newitem)
+  return err
+}
+
+/**@brief same as iAppendToList, also returns the version of the key
+ * @param key
+ * @param newitem
+ * @return uint64
+ * @return error
+ */
+func (ls *Libstore) appendToList(key, newitem string) (uint64, error) {
+  var forms []string
+  var err error
+
+  forms, err = ls.itemForms(key, newitem)
+  if err != nil {
+    return 0, err
+  }
+
+  //while keys are rotated the storage server cannot see that an item
+  //encrypted with an older key is the same, look for it ourselves
+  if len(forms) > 2 {
+    list, err := ls.getListAtLeast(key, 0)
+    if err != nil &&
+        err != MakeErr("GetList()", storageproto.EKEYNOTFOUND) {
+      return 0, err
+    }
+    for _, v := range list {
+      if v == newitem {
+        return 0, MakeErr("AppendToList()", storageproto.EITEMEXISTS)
+      }
+    }
+  }
+
+  return ls.appendRaw(key, forms[0])
+}
+
+/**@brief append an item exactly as given
+ * @param key
+ * @param newitem
+ * @return uint64
+ * @return error
+ */
+func (ls *Libstore) appendRaw(key, newitem string) (uint64, error) {
+  var cli transport.Caller
   var args storageproto.PutArgs = storageproto.PutArgs{key, newitem}
   var reply storageproto.PutReply
   var err error
 
   cli, err = ls.GetServer(key)
   if lsplog.CheckReport(1, err) {
-    return err
+    return 0, err
   }
 
   //lsplog.Vlogf(0, "AppendToList args %v\n", args)
 
   err = cli.Call("StorageRPC.AppendToList", &args, &reply)
   if lsplog.CheckReport(1, err) {
-    return err
+    return 0, err
   }
 
   //lsplog.Vlogf(0, "AppendToList reply %v\n", reply)
 
   if reply.Status != storageproto.OK {
-    return MakeErr("AppendToList()", reply.Status)
+    return 0, MakeErr("AppendToList()", reply.Status)
   }
 
-  return nil
+  return reply.Version, nil
+}
+
+/**@brief change the lease renewal window and hotness threshold, a window
+ *        under RENEW_MIN_WINDOW_MS would make the renewer spin
+ * @param window
+ * @param thresh
+ * @return void
+ */
+func (ls *Libstore) iSetRenewal(window time.Duration, thresh int) {
+  if window < RENEW_MIN_WINDOW_MS * time.Millisecond {
+    window = RENEW_MIN_WINDOW_MS * time.Millisecond
+  }
+  if thresh < 1 {
+    thresh = 1
+  }
+
+  ls.renewLock.Lock()
+  ls.renewWindow = window
+  ls.renewThresh = thresh
+  ls.renewLock.Unlock()
+}
+
+/**@brief the lease renewal window and hotness threshold in effect
+ * @param void
+ * @return time.Duration
+ * @return int
+ */
+func (ls *Libstore) iRenewal() (time.Duration, int) {
+  ls.renewLock.Lock()
+  defer ls.renewLock.Unlock()
+
+  return ls.renewWindow, ls.renewThresh
+}
+
+/**@brief background goroutine renewing leases of hot keys shortly before
+ *        they expire, so readers never fall back to storage
+ * @param void
+ * @return void
+ */
+func (ls *Libstore) renewer() {
+  var window time.Duration
+  var thresh int
+
+  for {
+    ls.renewLock.Lock()
+    window = ls.renewWindow
+    thresh = ls.renewThresh
+    ls.renewLock.Unlock()
+
+    select {
+    case <-ls.quit:
+      return
+    case <-time.After(window / 2):
+    }
+
+    for key, data := range ls.Leases.Renewable(window, thresh) {
+      switch data.(type) {
+      case string, packedValue:
+        ls.renewLease(key, "StorageRPC.Get", &storageproto.GetReply{})
+      case []string, packedList:
+        ls.renewLease(key, "StorageRPC.GetList", &storageproto.GetListReply{})
+      }
+    }
+  }
+}
+
+/**@brief ask storage for a fresh lease on key and store the new value
+ * @param key
+ * @param method StorageRPC.Get or StorageRPC.GetList
+ * @param reply *GetReply or *GetListReply matching method
+ * @return void
+ */
+func (ls *Libstore) renewLease(key, method string, reply interface{}) {
+  var cli transport.Caller
+  var args storageproto.GetArgs = storageproto.GetArgs{key, true, ls.Addr, true}
+  var err error
+
+  cli, err = ls.GetServer(key)
+  if lsplog.CheckReport(1, err) {
+    return
+  }
+
+  atomic.AddUint64(&ls.leaseRequests, 1)
+
+  err = cli.Call(method, &args, reply)
+  if lsplog.CheckReport(1, err) {
+    return
+  }
+
+  switch r := reply.(type) {
+  case *storageproto.GetReply:
+    if r.Status == storageproto.OK && r.Lease.Granted {
+      atomic.AddUint64(&ls.leasesGranted, 1)
+      ls.Leases.LeaseGranted(key, ls.valueData(r), r.Version, r.Lease)
+    }
+  case *storageproto.GetListReply:
+    if r.Status == storageproto.OK && r.Lease.Granted {
+      atomic.AddUint64(&ls.leasesGranted, 1)
+      ls.Leases.LeaseGranted(key, ls.listData(r), r.Version, r.Lease)
+    }
+  }
 }
 
 /**@brief revoke function called by storage server to invalidate  
@@ -398,6 +868,8 @@ func (ls *Libstore) RevokeLease(
 
   //fmt.Printf("libstore Revoking lease: %s\n", args.Key)
 
+  atomic.AddUint64(&ls.revocations, 1)
+
   valid = ls.Leases.ClearEntry(args.Key)
   if !valid {
     reply.Status = storageproto.EKEYNOTFOUND
@@ -0,0 +1,318 @@
+/** @file libstore_test.go
+ *  @brief tests of libstore against a storage server in the same process,
+ *         reached over HTTP like a real one
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package libstore_test
+
+import (
+  "fmt"
+  "net"
+  "net/http"
+  "net/rpc"
+  "runtime"
+  "strings"
+  "testing"
+  "time"
+  "P2-f12/contrib/libstore"
+  "P2-f12/contrib/storageimpl"
+  "P2-f12/official/storageproto"
+  "P2-f12/official/storagerpc"
+)
+
+/**@brief start a single node storage server on a free port
+ * @param t
+ * @return string its address
+ */
+func newStorage(t *testing.T) string {
+  t.Helper()
+
+  l, err := net.Listen("tcp", "localhost:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { l.Close() })
+
+  //a storage server is its own master if it has this very address
+  portnum := l.Addr().(*net.TCPAddr).Port
+  master := fmt.Sprintf("localhost:%d", portnum)
+
+  srv := rpc.NewServer()
+  ss := storageimpl.NewStorageserver(master, 1, portnum, 1)
+  if err = srv.Register(storagerpc.NewStorageRPC(ss)); err != nil {
+    t.Fatal(err)
+  }
+  go http.Serve(l, srv)
+
+  return master
+}
+
+/**@brief a libstore on master receiving lease callbacks, closed when the
+ *        test ends
+ * @param t
+ * @param master
+ * @param flags
+ * @return *libstore.Libstore
+ */
+func newLibstore(t *testing.T, master string,
+    flags int) *libstore.Libstore {
+  t.Helper()
+
+  ls, err := libstore.NewLibstore(master, "localhost:0", flags)
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { ls.Close() })
+
+  return ls
+}
+
+/**@brief hits, misses, leases and revocations after a known sequence of
+ *        reads and writes
+ * @param t
+ * @return void
+ */
+func TestStats(t *testing.T) {
+  master := newStorage(t)
+  ls := newLibstore(t, master, libstore.ALWAYS_LEASE)
+
+  if err := ls.Put("stats:a", "1"); err != nil {
+    t.Fatal(err)
+  }
+  if err := ls.AppendToList("stats:l", "x"); err != nil {
+    t.Fatal(err)
+  }
+
+  //one miss leasing each key, then hits
+  for i := 0; i < 3; i++ {
+    if v, err := ls.Get("stats:a"); err != nil || v != "1" {
+      t.Fatalf("Get = %q, %v", v, err)
+    }
+    if l, err := ls.GetList("stats:l"); err != nil || len(l) != 1 {
+      t.Fatalf("GetList = %v, %v", l, err)
+    }
+  }
+
+  //the write revokes our own lease before it returns
+  if err := ls.Put("stats:a", "2"); err != nil {
+    t.Fatal(err)
+  }
+  if v, err := ls.Get("stats:a"); err != nil || v != "2" {
+    t.Fatalf("Get after Put = %q, %v", v, err)
+  }
+
+  stats := ls.Stats()
+  want := libstore.Stats{Hits: 4, Misses: 3, LeaseRequests: 3,
+      LeasesGranted: 3, Revocations: 1}
+  if stats.Hits != want.Hits || stats.Misses != want.Misses ||
+      stats.LeaseRequests != want.LeaseRequests ||
+      stats.LeasesGranted != want.LeasesGranted ||
+      stats.Revocations != want.Revocations {
+    t.Errorf("stats = %+v, want %+v", stats, want)
+  }
+}
+
+/**@brief a key read often enough gets a new lease before the old one
+ *        runs out, without a read going to storage
+ * @param t
+ * @return void
+ */
+func TestRenewHotKey(t *testing.T) {
+  master := newStorage(t)
+  ls := newLibstore(t, master, libstore.ALWAYS_LEASE | libstore.RENEW_LEASES)
+
+  //renew right away, checking every half window
+  ls.SetRenewal(storageproto.LEASE_SECONDS * time.Second -
+      100 * time.Millisecond, 2)
+
+  if err := ls.Put("renew:a", "v"); err != nil {
+    t.Fatal(err)
+  }
+  for i := 0; i < 3; i++ {
+    if _, err := ls.Get("renew:a"); err != nil {
+      t.Fatal(err)
+    }
+  }
+  before := ls.Stats()
+
+  deadline := time.Now().Add(storageproto.LEASE_SECONDS * time.Second)
+  for ls.Stats().LeasesGranted == before.LeasesGranted {
+    if time.Now().After(deadline) {
+      t.Fatal("hot key not renewed before its lease ran out")
+    }
+    time.Sleep(50 * time.Millisecond)
+  }
+
+  if _, err := ls.Get("renew:a"); err != nil {
+    t.Fatal(err)
+  }
+  after := ls.Stats()
+  if after.Misses != before.Misses || after.Hits != before.Hits + 1 {
+    t.Errorf("read after renewal missed: %+v", after)
+  }
+}
+
+/**@brief empty and negative renewal windows are raised to the minimum,
+ *        so the renewer keeps sleeping between rounds
+ * @param t
+ * @return void
+ */
+func TestRenewalWindowClamped(t *testing.T) {
+  master := newStorage(t)
+  ls := newLibstore(t, master, libstore.ALWAYS_LEASE | libstore.RENEW_LEASES)
+  min := libstore.RENEW_MIN_WINDOW_MS * time.Millisecond
+
+  for _, window := range []time.Duration{0, -time.Second, min / 2} {
+    ls.SetRenewal(window, 0)
+    if got, thresh := ls.Renewal(); got != min || thresh != 1 {
+      t.Errorf("SetRenewal(%v, 0) = %v, %d", window, got, thresh)
+    }
+  }
+
+  ls.SetRenewal(time.Second, 2)
+  if got, thresh := ls.Renewal(); got != time.Second || thresh != 2 {
+    t.Errorf("SetRenewal(1s, 2) = %v, %d", got, thresh)
+  }
+}
+
+/**@brief two libstores in one process get their own revocations, and a
+ *        closed one gives its callback port back
+ * @param t
+ * @return void
+ */
+func TestLeaseCallbacksPerLibstore(t *testing.T) {
+  master := newStorage(t)
+  first := newLibstore(t, master, libstore.ALWAYS_LEASE)
+  second := newLibstore(t, master, libstore.ALWAYS_LEASE)
+  writer := newLibstore(t, master, libstore.NONE)
+
+  if first.Addr == second.Addr {
+    t.Fatalf("both libstores listen on %s", first.Addr)
+  }
+
+  //each libstore leases a key of its own, a write revokes only that one
+  for i, ls := range []*libstore.Libstore{first, second} {
+    key := fmt.Sprintf("cb:%d", i)
+    if err := writer.Put(key, "1"); err != nil {
+      t.Fatal(err)
+    }
+    if v, err := ls.Get(key); err != nil || v != "1" {
+      t.Fatalf("Get = %q, %v", v, err)
+    }
+    if err := writer.Put(key, "2"); err != nil {
+      t.Fatal(err)
+    }
+    if n := ls.Stats().Revocations; n != 1 {
+      t.Errorf("libstore %d got %d revocations, want 1", i, n)
+    }
+    if v, err := ls.Get(key); err != nil || v != "2" {
+      t.Errorf("libstore %d read %q, %v after the put", i, v, err)
+    }
+  }
+  if n := first.Stats().Revocations; n != 1 {
+    t.Errorf("libstore 0 got %d revocations for both keys", n)
+  }
+
+  addr := first.Addr
+  if err := first.Close(); err != nil {
+    t.Fatal(err)
+  }
+  l, err := net.Listen("tcp", addr)
+  if err != nil {
+    t.Fatalf("callback port still taken after Close: %v", err)
+  }
+  l.Close()
+}
+
+/**@brief a libstore that cannot listen for callbacks fails without leaving
+ *        goroutines of its cache behind
+ * @param t
+ * @return void
+ */
+func TestNewLibstoreListenFails(t *testing.T) {
+  master := newStorage(t)
+  taken, err := net.Listen("tcp", "localhost:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer taken.Close()
+
+  //let the storage server settle before counting
+  time.Sleep(100 * time.Millisecond)
+  before := runtime.NumGoroutine()
+
+  for i := 0; i < 5; i++ {
+    ls, err := libstore.NewLibstore(master, taken.Addr().String(),
+        libstore.ALWAYS_LEASE)
+    if err == nil {
+      ls.Close()
+      t.Fatal("libstore listening on a taken port")
+    }
+  }
+
+  deadline := time.Now().Add(2 * time.Second)
+  for runtime.NumGoroutine() > before {
+    if time.Now().After(deadline) {
+      t.Fatalf("%d goroutines before, %d after failed libstores",
+          before, runtime.NumGoroutine())
+    }
+    time.Sleep(20 * time.Millisecond)
+  }
+}
+
+/**@brief values and lists above COMPRESS_THRESHOLD travel compressed and
+ *        count towards the ratio, smaller ones do not
+ * @param t
+ * @return void
+ */
+func TestCompression(t *testing.T) {
+  master := newStorage(t)
+  ls := newLibstore(t, master, libstore.NONE)
+
+  small := "small"
+  large := strings.Repeat("tribble ", storageproto.COMPRESS_THRESHOLD)
+  if err := ls.Put("zip:small", small); err != nil {
+    t.Fatal(err)
+  }
+  if v, err := ls.Get("zip:small"); err != nil || v != small {
+    t.Fatalf("Get small = %q, %v", v, err)
+  }
+  if stats := ls.Stats(); stats.CompressedBytes != 0 ||
+      stats.CompressionRatio != 0 {
+    t.Errorf("small value counted as compressed: %+v", stats)
+  }
+
+  if err := ls.Put("zip:large", large); err != nil {
+    t.Fatal(err)
+  }
+  if v, err := ls.Get("zip:large"); err != nil || v != large {
+    t.Fatalf("Get large: %d bytes, %v", len(v), err)
+  }
+  stats := ls.Stats()
+  if stats.UncompressedBytes != uint64(len(large)) ||
+      stats.CompressedBytes == 0 ||
+      stats.CompressedBytes >= stats.UncompressedBytes {
+    t.Errorf("stats after a large value = %+v", stats)
+  }
+  if ratio := float64(stats.UncompressedBytes) /
+      float64(stats.CompressedBytes); stats.CompressionRatio != ratio {
+    t.Errorf("ratio %f, want %f", stats.CompressionRatio, ratio)
+  }
+
+  var items []string
+  for i := 0; i < storageproto.COMPRESS_THRESHOLD / 8; i++ {
+    item := fmt.Sprintf("item %04d of a long list", i)
+    items = append(items, item)
+    if err := ls.AppendToList("zip:list", item); err != nil {
+      t.Fatal(err)
+    }
+  }
+  list, err := ls.GetList("zip:list")
+  if err != nil || strings.Join(list, ",") != strings.Join(items, ",") {
+    t.Fatalf("GetList: %d items, %v", len(list), err)
+  }
+  if ls.Stats().UncompressedBytes <= stats.UncompressedBytes {
+    t.Errorf("large list not counted: %+v", ls.Stats())
+  }
+}
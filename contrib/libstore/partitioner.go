@@ -0,0 +1,20 @@
+/** @file partitioner.go
+ *  @brief key placement strategies for libstore, they live in
+ *         contrib/partition so the storage servers place keys the same way
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+
+package libstore
+
+import (
+  "P2-f12/contrib/partition"
+)
+
+type Partitioner = partition.Partitioner
+
+type HashPartitioner = partition.Hash
+
+type RendezvousPartitioner = partition.Rendezvous
+
+type HashTagPartitioner = partition.HashTag
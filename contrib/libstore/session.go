@@ -0,0 +1,145 @@
+/** @file session.go
+ *  @brief read-your-writes sessions on top of libstore
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+
+package libstore
+
+import (
+  "sync"
+  "time"
+  "P2-f12/official/storageproto"
+)
+
+// a write is forgotten once every lease granted before it has run out
+const SESSION_FORGET = (storageproto.LEASE_SECONDS +
+    storageproto.LEASE_GUARD_SECONDS) * time.Second
+
+/**
+ *  @brief a write made through a session
+ */
+type write struct {
+  version uint64
+  at time.Time
+}
+
+/**
+ *  @brief remembers the storage version of every key written through it,
+ *         reads never return cached data older than those writes even if
+ *         the lease revocation has not arrived yet. A Session is a KVStore
+ *         of its own, so a client of the libstore can use it in place of
+ *         the libstore.
+ */
+type Session struct {
+  ls *Libstore
+  written map[string]write
+  sweepAt int // size of written at which expired writes are dropped
+  lock sync.Mutex
+}
+
+/**@brief start a new session on this libstore
+ * @param void
+ * @return *Session
+ */
+func (ls *Libstore) NewSession() *Session {
+  return &Session{ls: ls, written: make(map[string]write), sweepAt: 64}
+}
+
+/**@brief the version the session last wrote to key, 0 if none or if no
+ *        lease from before the write can still be cached
+ * @param key
+ * @return uint64
+ */
+func (s *Session) lastWrite(key string) uint64 {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+
+  w, ok := s.written[key]
+  if ok && time.Since(w.at) > SESSION_FORGET {
+    delete(s.written, key)
+    return 0
+  }
+  return w.version
+}
+
+/**@brief record a write, versions only move forward. Writes that need no
+ *        longer be remembered are dropped whenever the number remembered
+ *        has doubled
+ * @param key
+ * @param version
+ * @return void
+ */
+func (s *Session) wrote(key string, version uint64) {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+
+  now := time.Now()
+  if version > s.written[key].version {
+    s.written[key] = write{version, now}
+  }
+
+  if len(s.written) < s.sweepAt {
+    return
+  }
+  for k, w := range s.written {
+    if now.Sub(w.at) > SESSION_FORGET {
+      delete(s.written, k)
+    }
+  }
+  s.sweepAt = 2 * len(s.written)
+  if s.sweepAt < 64 {
+    s.sweepAt = 64
+  }
+}
+
+func (s *Session) Get(key string) (string, error) {
+  return s.ls.getAtLeast(key, s.lastWrite(key))
+}
+
+func (s *Session) GetList(key string) ([]string, error) {
+  return s.ls.getListAtLeast(key, s.lastWrite(key))
+}
+
+func (s *Session) Put(key, value string) error {
+  version, err := s.ls.put(key, value)
+  if err == nil {
+    s.wrote(key, version)
+  }
+  return err
+}
+
+func (s *Session) AppendToList(key, newitem string) error {
+  version, err := s.ls.appendToList(key, newitem)
+  if err == nil {
+    s.wrote(key, version)
+  }
+  return err
+}
+
+func (s *Session) RemoveFromList(key, removeitem string) error {
+  version, err := s.ls.removeFromList(key, removeitem)
+  if err == nil {
+    s.wrote(key, version)
+  }
+  return err
+}
+
+/**@brief statistics of the libstore under the session
+ * @param void
+ * @return Stats
+ */
+func (s *Session) Stats() Stats {
+  return s.ls.Stats()
+}
+
+/**@brief end the session, the libstore stays open for other sessions
+ * @param void
+ * @return error
+ */
+func (s *Session) Close() error {
+  s.lock.Lock()
+  s.written = make(map[string]write)
+  s.lock.Unlock()
+  return nil
+}
@@ -0,0 +1,105 @@
+/** @file packing.go
+ *  @brief flate compression of values and lists sent by the storage server
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package packing
+
+import (
+  "bytes"
+  "compress/flate"
+  "encoding/binary"
+  "io"
+  "P2-f12/official/lsplog"
+)
+
+/**@brief compress a value
+ * @param s
+ * @return []byte
+ */
+func PackString(s string) []byte {
+  return deflate([]byte(s))
+}
+
+/**@brief decompress a value packed by PackString
+ * @param data
+ * @return string
+ * @return error
+ */
+func UnpackString(data []byte) (string, error) {
+  raw, err := inflate(data)
+  return string(raw), err
+}
+
+/**@brief bytes a list occupies uncompressed
+ * @param list
+ * @return int
+ */
+func ListSize(list []string) int {
+  var size int
+
+  for _, v := range list {
+    size += len(v)
+  }
+
+  return size
+}
+
+/**@brief compress a list, every item is prefixed with its length
+ * @param list
+ * @return []byte
+ */
+func PackList(list []string) []byte {
+  var buf bytes.Buffer
+  var n [binary.MaxVarintLen64]byte
+
+  for _, v := range list {
+    buf.Write(n[:binary.PutUvarint(n[:], uint64(len(v)))])
+    buf.WriteString(v)
+  }
+
+  return deflate(buf.Bytes())
+}
+
+/**@brief decompress a list packed by PackList
+ * @param data
+ * @return []string
+ * @return error
+ */
+func UnpackList(data []byte) ([]string, error) {
+  var list []string = []string{}
+
+  raw, err := inflate(data)
+  if err != nil {
+    return nil, err
+  }
+
+  for len(raw) > 0 {
+    size, n := binary.Uvarint(raw)
+    if n <= 0 || uint64(len(raw) - n) < size {
+      return nil, lsplog.MakeErr("UnpackList(): corrupt list")
+    }
+    list = append(list, string(raw[n:n + int(size)]))
+    raw = raw[n + int(size):]
+  }
+
+  return list, nil
+}
+
+func deflate(raw []byte) []byte {
+  var buf bytes.Buffer
+
+  //BestSpeed, every large read is compressed again
+  w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+  w.Write(raw)
+  w.Close()
+
+  return buf.Bytes()
+}
+
+func inflate(data []byte) ([]byte, error) {
+  r := flate.NewReader(bytes.NewReader(data))
+  defer r.Close()
+
+  return io.ReadAll(r)
+}
@@ -0,0 +1,66 @@
+/** @file packing_test.go
+ *  @brief tests of value and list compression
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package packing
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+)
+
+/**@brief values come back as they were packed and repetitive ones shrink
+ * @param t
+ * @return void
+ */
+func TestPackString(t *testing.T) {
+  for _, s := range []string{"", "x", strings.Repeat("tribble ", 1000)} {
+    packed := PackString(s)
+    got, err := UnpackString(packed)
+    if err != nil || got != s {
+      t.Errorf("round trip of %d bytes: %d bytes, %v", len(s), len(got), err)
+    }
+  }
+
+  large := strings.Repeat("tribble ", 1000)
+  if n := len(PackString(large)); n >= len(large) / 10 {
+    t.Errorf("%d bytes packed to %d", len(large), n)
+  }
+}
+
+/**@brief lists keep their items, empty ones included, and their order
+ * @param t
+ * @return void
+ */
+func TestPackList(t *testing.T) {
+  for _, list := range [][]string{
+      {},
+      {""},
+      {"a", "", "bc"},
+      strings.Split(strings.Repeat("item,", 500), ",")} {
+    got, err := UnpackList(PackList(list))
+    if err != nil || !reflect.DeepEqual(got, list) {
+      t.Errorf("round trip of %d items: %d items, %v", len(list), len(got), err)
+    }
+    if ListSize(list) != len(strings.Join(list, "")) {
+      t.Errorf("ListSize = %d", ListSize(list))
+    }
+  }
+}
+
+/**@brief data that is not a packed list is refused
+ * @param t
+ * @return void
+ */
+func TestUnpackCorrupt(t *testing.T) {
+  if _, err := UnpackString([]byte("not deflated")); err == nil {
+    t.Errorf("garbage unpacked as a value")
+  }
+
+  //an item claiming more bytes than follow
+  if _, err := UnpackList(deflate([]byte{10, 'a'})); err == nil {
+    t.Errorf("truncated list unpacked")
+  }
+}
@@ -0,0 +1,117 @@
+/** @file partition.go
+ *  @brief key placement strategies, shared by libstore, which sends each
+ *         key to its node, and the storage servers, which check that a
+ *         key they miss is really theirs
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+
+package partition
+
+import (
+  "encoding/binary"
+  "hash/fnv"
+  "sort"
+  "strings"
+  "P2-f12/official/storageproto"
+)
+
+/**
+ *  @brief maps a key to the storage node responsible for it. Nodes are
+ *         sorted by NodeID, Place returns an index into that slice. Every
+ *         libstore and storage server of one cluster must use the same
+ *         strategy.
+ */
+type Partitioner interface {
+  Place(key string, nodes []storageproto.Node) int
+}
+
+/**@brief FNV-32 of key, the same as libstore.Storehash
+ * @param key
+ * @return uint32
+ */
+func hash(key string) uint32 {
+  hasher := fnv.New32()
+  hasher.Write([]byte(key))
+  return hasher.Sum32()
+}
+
+/**@brief the part of a key that decides its placement, everything before
+ *        the first ':' so that all keys of one user live together
+ * @param key
+ * @return string
+ */
+func routingKey(key string) string {
+  return strings.Split(key, ":")[0]
+}
+
+/**@brief index of the first node whose id is not smaller than id,
+ *        wrapping around to the first node
+ * @param id
+ * @param nodes
+ * @return int
+ */
+func successor(id uint32, nodes []storageproto.Node) int {
+  var svr int
+
+  // returns the index of the first server after the key's hash
+  svr = sort.Search(
+      len(nodes), func(i int) bool { return nodes[i].NodeID >= id })
+
+  return svr % len(nodes)
+}
+
+/**
+ *  @brief default strategy: FNV-32 of the routing key on the node id ring
+ */
+type Hash struct{}
+
+func (Hash) Place(key string, nodes []storageproto.Node) int {
+  return successor(hash(routingKey(key)), nodes)
+}
+
+/**
+ *  @brief highest random weight: every node scores the routing key and the
+ *         best score wins, so removing a node only moves its own keys
+ */
+type Rendezvous struct{}
+
+func (Rendezvous) Place(key string, nodes []storageproto.Node) int {
+  var best int
+  var bestScore, score uint32
+  var buf []byte = make([]byte, 4)
+  var rkey string = routingKey(key)
+
+  for i := 0; i < len(nodes); i++ {
+    binary.BigEndian.PutUint32(buf, nodes[i].NodeID)
+    score = hash(rkey + string(buf))
+    if i == 0 || score > bestScore {
+      best = i
+      bestScore = score
+    }
+  }
+
+  return best
+}
+
+/**
+ *  @brief hash tags: if the key contains a non-empty "{...}" only the text
+ *         between the first pair of braces is hashed, so unrelated keys can
+ *         be forced onto one node. Keys without a tag fall back to the
+ *         default placement.
+ */
+type HashTag struct{}
+
+func (HashTag) Place(key string, nodes []storageproto.Node) int {
+  var start, end int
+
+  start = strings.Index(key, "{")
+  if start >= 0 {
+    end = strings.Index(key[start + 1:], "}")
+    if end > 0 {
+      return successor(hash(key[start + 1:start + 1 + end]), nodes)
+    }
+  }
+
+  return Hash{}.Place(key, nodes)
+}
@@ -0,0 +1,114 @@
+/** @file partitioner_test.go
+ *  @brief tests of the key placement strategies
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+
+package partition
+
+import (
+  "fmt"
+  "testing"
+  "P2-f12/official/storageproto"
+)
+
+/**@brief count nodes spread evenly over the id ring, sorted by id
+ * @param count
+ * @return []storageproto.Node
+ */
+func evenNodes(count int) []storageproto.Node {
+  var nodes []storageproto.Node
+
+  for i := 0; i < count; i++ {
+    id := uint32(uint64(i) * (1 << 32) / uint64(count))
+    nodes = append(nodes,
+        storageproto.Node{fmt.Sprintf("localhost:%d", 9000 + i), id})
+  }
+
+  return nodes
+}
+
+var partitioners = map[string]Partitioner{
+  "hash": Hash{},
+  "rendezvous": Rendezvous{},
+  "hashtag": HashTag{},
+}
+
+/**@brief every strategy places a key on the same node each time, keeps the
+ *        keys of one user together and spreads users evenly
+ * @param t
+ * @return void
+ */
+func TestPartitionerPlacement(t *testing.T) {
+  const users = 10000
+  nodes := evenNodes(5)
+
+  for name, part := range partitioners {
+    var counts []int = make([]int, len(nodes))
+
+    for i := 0; i < users; i++ {
+      user := fmt.Sprintf("user%d", i)
+      svr := part.Place(user + ":T", nodes)
+      if svr < 0 || svr >= len(nodes) {
+        t.Fatalf("%s placed %s on node %d of %d", name, user, svr, len(nodes))
+      }
+      if again := part.Place(user + ":T", nodes); again != svr {
+        t.Errorf("%s placed %s on %d, then %d", name, user, svr, again)
+      }
+      if other := part.Place(user + ":F", nodes); other != svr {
+        t.Errorf("%s split the keys of %s over %d and %d",
+            name, user, svr, other)
+      }
+      counts[svr]++
+    }
+
+    for svr, n := range counts {
+      if n < users / len(nodes) * 3 / 4 || n > users / len(nodes) * 5 / 4 {
+        t.Errorf("%s put %d of %d users on node %d", name, n, users, svr)
+      }
+    }
+  }
+}
+
+/**@brief removing a node only moves the keys it held under rendezvous
+ * @param t
+ * @return void
+ */
+func TestRendezvousRemoveNode(t *testing.T) {
+  nodes := evenNodes(5)
+  fewer := append(append([]storageproto.Node{}, nodes[:2]...), nodes[3:]...)
+
+  for i := 0; i < 1000; i++ {
+    key := fmt.Sprintf("user%d:T", i)
+    before := nodes[Rendezvous{}.Place(key, nodes)]
+    after := fewer[Rendezvous{}.Place(key, fewer)]
+    if before != nodes[2] && before != after {
+      t.Errorf("%s moved from %v to %v", key, before, after)
+    }
+  }
+}
+
+/**@brief keys with the same hash tag share a node, keys without one are
+ *        placed as by the default strategy
+ * @param t
+ * @return void
+ */
+func TestHashTagColocates(t *testing.T) {
+  nodes := evenNodes(5)
+
+  for i := 0; i < 100; i++ {
+    tag := fmt.Sprintf("{room%d}", i)
+    svr := HashTag{}.Place("alice:" + tag + ":T", nodes)
+    for _, key := range []string{tag, "bob:" + tag, tag + ":x:y"} {
+      if other := (HashTag{}).Place(key, nodes); other != svr {
+        t.Errorf("%s on %d, %s on %d", tag, svr, key, other)
+      }
+    }
+
+    key := fmt.Sprintf("user%d:{}:T", i)
+    tagged := HashTag{}.Place(key, nodes)
+    if tagged != (Hash{}).Place(key, nodes) {
+      t.Errorf("%s with an empty tag not placed by its user", key)
+    }
+  }
+}
@@ -12,8 +12,11 @@ package storageimpl
 import (
   //"bytes"
   "fmt"
-  "net/rpc"
+  "sort"
   "time"
+  "P2-f12/contrib/partition"
+  "P2-f12/contrib/transport"
+  "P2-f12/contrib/packing"
   "P2-f12/official/storageproto"
   "P2-f12/official/lsplog"
   "encoding/json"
@@ -39,16 +42,26 @@ type leaseEntry struct {
 
 type Storageserver struct {
   hash map[string] []byte
+  versions map[string] uint64 //bumped on every write, for read-your-writes
   portnum int
   nodeid uint32
   isMaster bool //identify whether this node is master node
   nodes map[storageproto.Node] bool //master node store all other servers info
+  nodesLock sync.Mutex //slaves register concurrently
   numnodes int
-  //rwlock sync.RWMutex //reader writer lock 
+  ring []storageproto.Node //all nodes by id once every node registered
+  ringLock sync.Mutex
+  rwlock sync.RWMutex //reader writer lock, guards versions
 
   leasePool map[string] leaseEntry
+
+  trans transport.Transport //reaches the master and lease holders
+  part partition.Partitioner //must be the one the libstores use
 }
 
+//servers of an embedded cluster start in parallel, set verbosity only once
+var verboseOnce sync.Once
+
 func reallySeedTheDamnRNG() {
 	//randint, _ := crand.Int(crand.Reader, big.NewInt(math.MaxInt64))
 	//rand.Seed( randint.Int64())
@@ -56,12 +69,29 @@ func reallySeedTheDamnRNG() {
 
 func NewStorageserver(master string, numnodes int, portnum int,
                                         nodeid uint32) *Storageserver {
+  return NewStorageserverTransport(master, numnodes, portnum, nodeid,
+                                   transport.HTTP{})
+}
+
+// Same as NewStorageserver, but the master and lease holders are contacted
+// through tr, e.g. a transport.Local for an embedded cluster.
+func NewStorageserverTransport(master string, numnodes int, portnum int,
+                  nodeid uint32, tr transport.Transport) *Storageserver {
+  return NewStorageserverPartitioned(master, numnodes, portnum, nodeid, tr,
+                                     partition.Hash{})
+}
 
-  lsplog.SetVerbose(3)
+// Same as NewStorageserverTransport for clusters whose libstores place keys
+// with part instead of the default hash partitioning.
+func NewStorageserverPartitioned(master string, numnodes int, portnum int,
+    nodeid uint32, tr transport.Transport,
+    part partition.Partitioner) *Storageserver {
+
+  verboseOnce.Do(func() { lsplog.SetVerbose(3) })
   fmt.Println("Create New Storage Server")
   fmt.Printf("master:%s, numnodes:%d, portnum:%d, nodeid:%d\n",
                                       master, numnodes, portnum, nodeid)
-  var masterNode *rpc.Client
+  var masterNode transport.Caller
   var regArgs storageproto.RegisterArgs
   var regReply storageproto.RegisterReply
   var storage Storageserver
@@ -69,6 +99,8 @@ func NewStorageserver(master string, numnodes int, portnum int,
   var nodes = make(map[storageproto.Node] bool)
 
   storage.nodeid = nodeid
+  storage.trans = tr
+  storage.part = part
   storage.leasePool = make(map[string] leaseEntry)
 
   selfAddr := fmt.Sprintf("localhost:%d",portnum)
@@ -89,7 +121,7 @@ func NewStorageserver(master string, numnodes int, portnum int,
     storage.nodes[self] = true
   } else {
 
-    masterNode, err = rpc.DialHTTP("tcp", master)
+    masterNode, err = tr.Dial(master)
     if lsplog.CheckReport(1, err) {
       return nil
     }
@@ -100,6 +132,7 @@ func NewStorageserver(master string, numnodes int, portnum int,
     //for slave node
     storage.isMaster = false
     storage.portnum = portnum
+    storage.numnodes = numnodes
 
     fmt.Printf("for slave node\n")
     fmt.Printf("begin try $$$\n")
@@ -114,9 +147,11 @@ func NewStorageserver(master string, numnodes int, portnum int,
       }*/
       time.Sleep(1000 * time.Millisecond)
     }
+    storage.setRing(regReply.Servers)
   }
 
   storage.hash = make(map[string] []byte)
+  storage.versions = make(map[string] uint64)
 
 	return &storage
 }
@@ -131,6 +166,9 @@ func (ss *Storageserver) RegisterServer(args *storageproto.RegisterArgs,
     return lsplog.MakeErr("Calling a non-master node to register")
   }
 
+  ss.nodesLock.Lock()
+  defer ss.nodesLock.Unlock()
+
   _, present := ss.nodes[args.ServerInfo]
   if !present {
     //add to nodes
@@ -153,6 +191,7 @@ func (ss *Storageserver) RegisterServer(args *storageproto.RegisterArgs,
       i++
     }
   reply.Servers = servers
+  ss.setRing(servers)
   } else {
     reply.Ready = false
   }
@@ -170,7 +209,9 @@ func (ss *Storageserver) GetServers(args *storageproto.GetServersArgs,
     return lsplog.MakeErr("Calling a non-master node to GetServers")
   }
 
+  ss.nodesLock.Lock()
   if len(ss.nodes) != ss.numnodes {
+    ss.nodesLock.Unlock()
     fmt.Println("GetServer not ready")
 
     //what a hack here, need change if time possible
@@ -187,12 +228,64 @@ func (ss *Storageserver) GetServers(args *storageproto.GetServersArgs,
     servers[i] = node
     i++
   }
+  ss.nodesLock.Unlock()
   reply.Servers = servers
   reply.Ready = true
 
   return nil
 }
 
+// Remember the complete node list, sorted by id the way partitioners expect
+func (ss *Storageserver) setRing(servers []storageproto.Node) {
+  if len(servers) != ss.numnodes {
+    return
+  }
+
+  ring := make([]storageproto.Node, len(servers))
+  copy(ring, servers)
+  sort.Slice(ring, func(i, j int) bool {
+    return ring[i].NodeID < ring[j].NodeID
+  })
+
+  ss.ringLock.Lock()
+  ss.ring = ring
+  ss.ringLock.Unlock()
+}
+
+// Whether the cluster's partitioner places key on this node, so that a miss
+// is a real not-found rather than a wrong server
+func (ss *Storageserver) owns(key string) bool {
+  if ss.numnodes == 1 {
+    return true
+  }
+
+  ss.ringLock.Lock()
+  ring := ss.ring
+  ss.ringLock.Unlock()
+
+  if ring == nil {
+    return false
+  }
+  return ring[ss.part.Place(key, ring)].NodeID == ss.nodeid
+}
+
+// Version of key as of its last write, 0 if never written
+func (ss *Storageserver) version(key string) uint64 {
+  ss.rwlock.RLock()
+  defer ss.rwlock.RUnlock()
+
+  return ss.versions[key]
+}
+
+// Count a write to key, returning its new version
+func (ss *Storageserver) bumpVersion(key string) uint64 {
+  ss.rwlock.Lock()
+  defer ss.rwlock.Unlock()
+
+  ss.versions[key]++
+  return ss.versions[key]
+}
+
 func isTimeout(holder leaseHolder) bool {
   dur := time.Since(holder.issueTime).Seconds()
   if dur > (storageproto.LEASE_SECONDS + storageproto.LEASE_GUARD_SECONDS) {
@@ -202,10 +295,11 @@ func isTimeout(holder leaseHolder) bool {
   return false
 }
 
+//points into list, so renewing through it updates the pool
 func search(list []leaseHolder, addr string) *leaseHolder {
-  for _, v := range list {
-    if v.holderAddr == addr {
-      return &v
+  for i := range list {
+    if list[i].holderAddr == addr {
+      return &list[i]
     }
   }
   return nil
@@ -265,14 +359,19 @@ func (ss *Storageserver) Get(args *storageproto.GetArgs,
 
   val, present := ss.hash[args.Key]
   if !present {
-    //if the whole system only have one storage node
-    if ss.numnodes == 1 {
+    //only the node the key belongs to knows it is missing
+    if ss.owns(args.Key) {
       reply.Status = storageproto.EKEYNOTFOUND
     } else {
       //reply.Status = storageproto.EKEYNOTFOUND
       reply.Status = storageproto.EWRONGSERVER
     }
 
+    //lease the miss too, a later put revokes it like any other lease
+    if args.WantLease && reply.Status == storageproto.EKEYNOTFOUND {
+      ss.addLeasePool(args, &(reply.Lease))
+    }
+
     fmt.Printf("storage GET key %s failed, nonexist\n", args.Key)
     //ss.rwlock.RUnlock()
     return nil
@@ -290,6 +389,17 @@ func (ss *Storageserver) Get(args *storageproto.GetArgs,
   fmt.Printf("Storage Get key %s, val %s, lease %t\n",
                                 args.Key, reply.Value, reply.Lease.Granted)
   reply.Status = storageproto.OK
+  reply.Version = ss.version(args.Key)
+
+  //large values travel compressed if the client can unpack them
+  if args.Compress && len(reply.Value) > storageproto.COMPRESS_THRESHOLD {
+    packed := packing.PackString(reply.Value)
+    if len(packed) < len(reply.Value) {
+      reply.Compressed = true
+      reply.Packed = packed
+      reply.Value = ""
+    }
+  }
   //ss.rwlock.RUnlock()
 	return nil
 }
@@ -303,12 +413,16 @@ func (ss *Storageserver) GetList(args *storageproto.GetArgs,
 
   val, present := ss.hash[args.Key]
   if !present {
-    if ss.numnodes == 1 {
+    if ss.owns(args.Key) {
       reply.Status = storageproto.EKEYNOTFOUND
     } else {
       reply.Status = storageproto.EWRONGSERVER
     }
     reply.Value = nil
+
+    if args.WantLease && reply.Status == storageproto.EKEYNOTFOUND {
+      ss.addLeasePool(args, &(reply.Lease))
+    }
     //ss.rwlock.RUnlock()
     return nil
   }
@@ -321,6 +435,17 @@ func (ss *Storageserver) GetList(args *storageproto.GetArgs,
   }
 
   reply.Status = storageproto.OK
+  reply.Version = ss.version(args.Key)
+
+  if args.Compress &&
+     packing.ListSize(reply.Value) > storageproto.COMPRESS_THRESHOLD {
+    packed := packing.PackList(reply.Value)
+    if len(packed) < packing.ListSize(reply.Value) {
+      reply.Compressed = true
+      reply.Packed = packed
+      reply.Value = nil
+    }
+  }
 
   if args.WantLease {
     ss.addLeasePool(args, &(reply.Lease))
@@ -347,6 +472,7 @@ func (ss *Storageserver) Put(args *storageproto.PutArgs,
   _, present := ss.hash[args.Key]
   if present {
     ss.hash[args.Key], _ = json.Marshal(args.Value)
+    reply.Version = ss.bumpVersion(args.Key)
     reply.Status = storageproto.OK
     //ss.rwlock.Unlock()
     return nil
@@ -364,6 +490,7 @@ func (ss *Storageserver) Put(args *storageproto.PutArgs,
     lsplog.Vlogf(0, "WARNING: Marshal data generate an error")
   }
 
+  reply.Version = ss.bumpVersion(args.Key)
   reply.Status = storageproto.OK
 
   //ss.rwlock.Unlock()
@@ -372,12 +499,14 @@ func (ss *Storageserver) Put(args *storageproto.PutArgs,
 }
 
 func boundedWaitCall(args *storageproto.RevokeLeaseArgs,
-  reply *storageproto.RevokeLeaseReply, con *rpc.Client, doneChan chan int) {
+  reply *storageproto.RevokeLeaseReply, con transport.Caller,
+  doneChan chan int) {
 
   err := con.Call("CacheRPC.RevokeLease", &args, &reply)
   if lsplog.CheckReport(1, err) {
       fmt.Printf("Try revoke lease holder failed\n!")
   }
+  con.Close()
 
   doneChan <- 1
   return
@@ -400,12 +529,15 @@ func (ss *Storageserver) revokeLeaseHolders(key string) error {
       continue;
     }
 
-    svr, err := rpc.DialHTTP("tcp", holder.holderAddr)
+    svr, err := ss.trans.Dial(holder.holderAddr)
     if lsplog.CheckReport(1, err) {
       fmt.Printf("revoke dial %s failed", holder.holderAddr)
+      continue
     }
 
     args.Key = key
+    //buffered, so a holder that answers after the timeout does not block
+    doneChan = make(chan int, 1)
 
     //ensure rpc is bounded waiting
     go boundedWaitCall(&args, &reply, svr, doneChan)
@@ -463,7 +595,11 @@ func (ss *Storageserver) AppendToList(args *storageproto.PutArgs,
   for _, v := range list {
     if v == args.Value {
       reply.Status = storageproto.EITEMEXISTS
+      reply.Version = ss.version(args.Key)
       //ss.rwlock.Unlock()
+      if present {
+        entry.mtx.Unlock()
+      }
       return nil
     }
   }
@@ -475,6 +611,7 @@ func (ss *Storageserver) AppendToList(args *storageproto.PutArgs,
     lsplog.Vlogf(0, "WARNING: Marshal data generate an error")
   }
 
+  reply.Version = ss.bumpVersion(args.Key)
   reply.Status = storageproto.OK
 
   fmt.Printf("comp apd %s to %s,val %s\n", args.Value, args.Key, ss.hash[args.Key])
@@ -524,13 +661,18 @@ func (ss *Storageserver) RemoveFromList(args *storageproto.PutArgs,
         lsplog.Vlogf(0, "WARNING: Marshal data generate an error")
       }
 
+      reply.Version = ss.bumpVersion(args.Key)
       reply.Status = storageproto.OK
       //ss.rwlock.Unlock()
+      if present {
+        entry.mtx.Unlock()
+      }
       return nil
     }
   }
 
   reply.Status = storageproto.EITEMNOTFOUND
+  reply.Version = ss.version(args.Key)
   if present {
     entry.mtx.Unlock()
   }
@@ -81,7 +81,7 @@ func (tc *Tribbleclient) GetTribblesBySubscription(Userid string) ([]tribproto.T
 }
 
 func (tc *Tribbleclient) dotrib(funcname, Userid string) ([]tribproto.Tribble, int, error) {
-	args := &tribproto.GetTribblesArgs{Userid}
+	args := &tribproto.GetTribblesArgs{Userid: Userid}
 	var reply tribproto.GetTribblesReply
 	err := tc.client.Call(funcname, args, &reply)
 	if err != nil {
@@ -0,0 +1,207 @@
+/** @file binary.go
+ *  @brief net/rpc over plain TCP with length-prefixed binary frames, no
+ *         HTTP handshake and no gob. net/rpc matches replies to calls by
+ *         sequence number, so many calls share one connection.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package transport
+
+import (
+  "bufio"
+  "encoding/binary"
+  "io"
+  "net"
+  "net/rpc"
+  "sync"
+  "P2-f12/official/lsplog"
+)
+
+const (
+  MAX_FRAME = 64 << 20 // refuse frames larger than this
+)
+
+/**
+ *  @brief the binary transport
+ */
+type Binary struct{}
+
+func (Binary) Dial(hostport string) (Caller, error) {
+  conn, err := net.Dial("tcp", hostport)
+  if err != nil {
+    return nil, err
+  }
+
+  return rpc.NewClientWithCodec(newBinaryCodec(conn)), nil
+}
+
+func (Binary) Listen(
+    hostport string, srv *rpc.Server) (io.Closer, string, error) {
+  l, addr, err := listenTCP(hostport)
+  if err != nil {
+    return nil, "", err
+  }
+
+  go ServeBinary(l, srv)
+
+  return l, addr, nil
+}
+
+/**@brief serve srv on every connection accepted from l until l is closed
+ * @param l
+ * @param srv
+ * @return error
+ */
+func ServeBinary(l net.Listener, srv *rpc.Server) error {
+  for {
+    conn, err := l.Accept()
+    if err != nil {
+      return err
+    }
+    go srv.ServeCodec(newBinaryCodec(conn))
+  }
+}
+
+/**
+ *  @brief one connection, works as client and as server codec.
+ *         frame: uint32 length, uvarint seq, method, error, body
+ */
+type binaryCodec struct {
+  conn io.ReadWriteCloser
+  r *bufio.Reader
+  body []byte //body of the frame whose header was read last
+
+  wlock sync.Mutex
+  w *bufio.Writer
+  frame []byte
+}
+
+func newBinaryCodec(conn io.ReadWriteCloser) *binaryCodec {
+  return &binaryCodec{conn: conn, r: bufio.NewReader(conn),
+                      w: bufio.NewWriter(conn)}
+}
+
+/**@brief write one frame
+ * @param seq
+ * @param method
+ * @param errmsg
+ * @param body nil if there is no body, e.g. after an error
+ * @return error
+ */
+func (c *binaryCodec) write(
+    seq uint64, method, errmsg string, body interface{}) error {
+  var err error
+
+  c.wlock.Lock()
+  defer c.wlock.Unlock()
+
+  //room for the length, filled in below
+  c.frame = append(c.frame[:0], 0, 0, 0, 0)
+  c.frame = binary.AppendUvarint(c.frame, seq)
+  c.frame = appendBytes(c.frame, []byte(method))
+  c.frame = appendBytes(c.frame, []byte(errmsg))
+  if body != nil {
+    c.frame, err = marshal(c.frame, body)
+    if err != nil {
+      return err
+    }
+  }
+  binary.BigEndian.PutUint32(c.frame, uint32(len(c.frame) - 4))
+
+  if _, err = c.w.Write(c.frame); err != nil {
+    return err
+  }
+
+  return c.w.Flush()
+}
+
+/**@brief read one frame and keep its body for the following read*Body
+ * @param void
+ * @return uint64 seq
+ * @return string method
+ * @return string error
+ * @return error
+ */
+func (c *binaryCodec) read() (uint64, string, string, error) {
+  var size [4]byte
+  var r wireReader
+
+  if _, err := io.ReadFull(c.r, size[:]); err != nil {
+    return 0, "", "", err
+  }
+  n := binary.BigEndian.Uint32(size[:])
+  if n > MAX_FRAME {
+    return 0, "", "", lsplog.MakeErr("binary transport: frame too large")
+  }
+
+  r.data = make([]byte, n)
+  if _, err := io.ReadFull(c.r, r.data); err != nil {
+    return 0, "", "", err
+  }
+
+  seq, err := r.uvarint()
+  if err != nil {
+    return 0, "", "", err
+  }
+  method, err := r.bytes()
+  if err != nil {
+    return 0, "", "", err
+  }
+  errmsg, err := r.bytes()
+  if err != nil {
+    return 0, "", "", err
+  }
+
+  c.body = r.data
+  return seq, string(method), string(errmsg), nil
+}
+
+func (c *binaryCodec) readBody(x interface{}) error {
+  var body []byte = c.body
+
+  c.body = nil
+  //nil means the caller wants the body discarded
+  if x == nil {
+    return nil
+  }
+
+  return unmarshal(body, x)
+}
+
+func (c *binaryCodec) WriteRequest(req *rpc.Request, body interface{}) error {
+  return c.write(req.Seq, req.ServiceMethod, "", body)
+}
+
+func (c *binaryCodec) ReadResponseHeader(resp *rpc.Response) error {
+  var err error
+
+  resp.Seq, resp.ServiceMethod, resp.Error, err = c.read()
+  return err
+}
+
+func (c *binaryCodec) ReadResponseBody(body interface{}) error {
+  return c.readBody(body)
+}
+
+func (c *binaryCodec) ReadRequestHeader(req *rpc.Request) error {
+  var err error
+
+  req.Seq, req.ServiceMethod, _, err = c.read()
+  return err
+}
+
+func (c *binaryCodec) ReadRequestBody(body interface{}) error {
+  return c.readBody(body)
+}
+
+func (c *binaryCodec) WriteResponse(resp *rpc.Response, body interface{}) error {
+  //net/rpc sends an invalid placeholder body along with errors
+  if resp.Error != "" {
+    body = nil
+  }
+  return c.write(resp.Seq, resp.ServiceMethod, resp.Error, body)
+}
+
+func (c *binaryCodec) Close() error {
+  return c.conn.Close()
+}
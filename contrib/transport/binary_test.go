@@ -0,0 +1,177 @@
+/** @file binary_test.go
+ *  @brief tests of the binary transport on real TCP connections
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package transport
+
+import (
+  "bytes"
+  "encoding/binary"
+  "fmt"
+  "io"
+  "net"
+  "strings"
+  "sync"
+  "testing"
+)
+
+/**@brief an Echo server on the binary transport and a client of it, both
+ *        closed when the test ends
+ * @param t
+ * @return Caller
+ * @return string address of the server
+ */
+func dialEcho(t *testing.T) (Caller, string) {
+  t.Helper()
+
+  l, addr, err := Binary{}.Listen("localhost:0", newEchoServer(t))
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { l.Close() })
+
+  cli, err := Binary{}.Dial(addr)
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { cli.Close() })
+
+  return cli, addr
+}
+
+/**@brief many calls in flight on one connection each get their own reply
+ * @param t
+ * @return void
+ */
+func TestBinaryConcurrentCalls(t *testing.T) {
+  var wg sync.WaitGroup
+
+  cli, _ := dialEcho(t)
+
+  errs := make(chan error, 64)
+  for i := 0; i < 64; i++ {
+    wg.Add(1)
+    go func(i int) {
+      defer wg.Done()
+      //sizes from empty to a few frames of the bufio buffers
+      msg := strings.Repeat(fmt.Sprintf("%d,", i), i * i * 10)
+      for round := 0; round < 20; round++ {
+        if err := echo(cli, msg); err != nil {
+          errs <- err
+          return
+        }
+      }
+    }(i)
+  }
+  wg.Wait()
+  close(errs)
+
+  for err := range errs {
+    t.Error(err)
+  }
+}
+
+/**@brief an error reaches the caller and the connection stays usable
+ * @param t
+ * @return void
+ */
+func TestBinaryErrorReply(t *testing.T) {
+  var reply string
+
+  cli, _ := dialEcho(t)
+
+  msg := "no such tribble"
+  err := cli.Call("Echo.Fail", &msg, &reply)
+  if err == nil || err.Error() != msg {
+    t.Errorf("Fail returned %v", err)
+  }
+  if err = cli.Call("Echo.Missing", &msg, &reply); err == nil {
+    t.Errorf("unknown method called")
+  }
+  if err = echo(cli, "still there"); err != nil {
+    t.Error(err)
+  }
+}
+
+/**
+ *  @brief in-memory connection reading the given bytes
+ */
+type frames struct {
+  bytes.Reader
+  bytes.Buffer
+}
+
+func (f *frames) Read(p []byte) (int, error) { return f.Reader.Read(p) }
+func (f *frames) Write(p []byte) (int, error) { return f.Buffer.Write(p) }
+func (f *frames) Close() error { return nil }
+
+/**@brief frames whose data ends early or claims too many bytes are
+ *        refused, a good one before them is still read
+ * @param t
+ * @return void
+ */
+func TestBinaryBadFrames(t *testing.T) {
+  var out frames
+  var size [4]byte
+
+  //a good frame as a client writes it
+  msg := "hello"
+  if err := newBinaryCodec(&out).write(7, "Echo.Echo", "", &msg); err != nil {
+    t.Fatal(err)
+  }
+  good := out.Bytes()
+
+  binary.BigEndian.PutUint32(size[:], MAX_FRAME + 1)
+  for name, data := range map[string][]byte{
+      "truncated length": good[:2],
+      "truncated frame": good[:len(good) - 1],
+      "oversized frame": append(size[:], good[4:]...)} {
+    var conn frames
+
+    conn.Reader = *bytes.NewReader(append(append([]byte{}, good...), data...))
+    c := newBinaryCodec(&conn)
+
+    seq, method, _, err := c.read()
+    if err != nil || seq != 7 || method != "Echo.Echo" {
+      t.Errorf("%s: good frame read as %d %q, %v", name, seq, method, err)
+    }
+    var body string
+    if err = c.readBody(&body); err != nil || body != msg {
+      t.Errorf("%s: good body %q, %v", name, body, err)
+    }
+
+    if _, _, _, err = c.read(); err == nil || err == io.EOF {
+      t.Errorf("%s: read gave %v", name, err)
+    }
+  }
+}
+
+/**@brief a server drops a connection sending an oversized frame without
+ *        reading it, and keeps serving others
+ * @param t
+ * @return void
+ */
+func TestBinaryServerRefusesOversized(t *testing.T) {
+  var size [4]byte
+
+  cli, addr := dialEcho(t)
+
+  conn, err := net.Dial("tcp", addr)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer conn.Close()
+
+  binary.BigEndian.PutUint32(size[:], MAX_FRAME + 1)
+  if _, err = conn.Write(size[:]); err != nil {
+    t.Fatal(err)
+  }
+  if _, err = conn.Read(make([]byte, 1)); err == nil {
+    t.Errorf("server answered an oversized frame")
+  }
+
+  if err = echo(cli, "still serving"); err != nil {
+    t.Error(err)
+  }
+}
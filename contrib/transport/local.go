@@ -0,0 +1,106 @@
+/** @file local.go
+ *  @brief in-process transport, rpc servers are looked up by address and
+ *         reached through net.Pipe without any TCP or HTTP
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package transport
+
+import (
+  "net"
+  "net/rpc"
+  "io"
+  "strconv"
+  "sync"
+  "P2-f12/official/lsplog"
+)
+
+/**
+ *  @brief registry of in-process rpc servers
+ */
+type Local struct {
+  servers map[string]*rpc.Server
+  nextPort int
+  lock sync.Mutex
+}
+
+/**
+ *  @brief unregisters an address of a Local transport
+ */
+type localListener struct {
+  local *Local
+  addr string
+}
+
+/**@brief create an empty in-process network
+ * @param void
+ * @return *Local
+ */
+func NewLocal() *Local {
+  var local Local
+
+  local.servers = make(map[string]*rpc.Server)
+  local.nextPort = 40000
+
+  return &local
+}
+
+func (local *Local) Dial(hostport string) (Caller, error) {
+  var srv *rpc.Server
+  var present bool
+  var cli, svr net.Conn
+
+  local.lock.Lock()
+  srv, present = local.servers[hostport]
+  local.lock.Unlock()
+
+  if !present {
+    return nil, lsplog.MakeErr("dial " + hostport + ": connection refused")
+  }
+
+  cli, svr = net.Pipe()
+  go srv.ServeConn(svr)
+
+  return rpc.NewClient(cli), nil
+}
+
+func (local *Local) Listen(
+    hostport string, srv *rpc.Server) (io.Closer, string, error) {
+  var host, port string
+  var err error
+
+  host, port, err = net.SplitHostPort(hostport)
+  if err != nil {
+    return nil, "", err
+  }
+
+  local.lock.Lock()
+  defer local.lock.Unlock()
+
+  if port == "0" {
+    for {
+      port = strconv.Itoa(local.nextPort)
+      local.nextPort++
+      if _, used := local.servers[net.JoinHostPort(host, port)]; !used {
+        break
+      }
+    }
+  }
+
+  hostport = net.JoinHostPort(host, port)
+  if _, used := local.servers[hostport]; used {
+    return nil, "", lsplog.MakeErr("listen " + hostport + ": address in use")
+  }
+
+  local.servers[hostport] = srv
+
+  return &localListener{local, hostport}, hostport, nil
+}
+
+func (ll *localListener) Close() error {
+  ll.local.lock.Lock()
+  delete(ll.local.servers, ll.addr)
+  ll.local.lock.Unlock()
+
+  return nil
+}
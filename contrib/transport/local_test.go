@@ -0,0 +1,99 @@
+/** @file local_test.go
+ *  @brief tests of the in-process transport
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package transport
+
+import (
+  "errors"
+  "net/rpc"
+  "testing"
+)
+
+/**
+ *  @brief rpc service answering with its argument or failing with it
+ */
+type Echo struct{}
+
+func (*Echo) Echo(args *string, reply *string) error {
+  *reply = *args
+  return nil
+}
+
+func (*Echo) Fail(args *string, reply *string) error {
+  return errors.New(*args)
+}
+
+/**@brief an rpc server offering Echo
+ * @param t
+ * @return *rpc.Server
+ */
+func newEchoServer(t *testing.T) *rpc.Server {
+  srv := rpc.NewServer()
+  if err := srv.Register(&Echo{}); err != nil {
+    t.Fatal(err)
+  }
+
+  return srv
+}
+
+/**@brief call Echo.Echo with s
+ * @param cli
+ * @param s
+ * @return error unless s came back
+ */
+func echo(cli Caller, s string) error {
+  var reply string
+
+  if err := cli.Call("Echo.Echo", &s, &reply); err != nil {
+    return err
+  }
+  if reply != s {
+    return errors.New("sent " + s + ", got " + reply)
+  }
+
+  return nil
+}
+
+/**@brief listening, dialing, calling and closing on a Local network
+ * @param t
+ * @return void
+ */
+func TestLocal(t *testing.T) {
+  local := NewLocal()
+  srv := newEchoServer(t)
+
+  if _, err := local.Dial("localhost:1"); err == nil {
+    t.Errorf("dialed an address nobody listens on")
+  }
+
+  first, addr, err := local.Listen("localhost:0", srv)
+  if err != nil {
+    t.Fatal(err)
+  }
+  _, other, err := local.Listen("localhost:0", srv)
+  if err != nil || other == addr {
+    t.Fatalf("second free port %q, %v, first %q", other, err, addr)
+  }
+  if _, _, err = local.Listen(addr, srv); err == nil {
+    t.Errorf("listened twice on %s", addr)
+  }
+
+  cli, err := local.Dial(addr)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer cli.Close()
+  if err = echo(cli, "hello"); err != nil {
+    t.Error(err)
+  }
+
+  first.Close()
+  if _, err = local.Dial(addr); err == nil {
+    t.Errorf("dialed %s after Close", addr)
+  }
+  if _, _, err = local.Listen(addr, srv); err != nil {
+    t.Errorf("%s not free after Close: %v", addr, err)
+  }
+}
@@ -0,0 +1,103 @@
+/** @file transport.go
+ *  @brief how storage servers, libstores and tribservers reach each other
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package transport
+
+import (
+  "io"
+  "net"
+  "net/http"
+  "net/rpc"
+  "P2-f12/official/lsplog"
+)
+
+/**
+ *  @brief client side of a connection, *rpc.Client satisfies it
+ */
+type Caller interface {
+  Call(serviceMethod string, args interface{}, reply interface{}) error
+  Close() error
+}
+
+/**
+ *  @brief a way to dial rpc servers and to serve one
+ */
+type Transport interface {
+  Dial(hostport string) (Caller, error)
+
+  // Listen serves srv at hostport until the returned closer is closed.
+  // Port 0 picks a free port, the address actually used is returned.
+  Listen(hostport string, srv *rpc.Server) (io.Closer, string, error)
+}
+
+/**@brief transport for a command line flag value
+ * @param name "http" or "binary"
+ * @return Transport
+ * @return error
+ */
+func ByName(name string) (Transport, error) {
+  switch name {
+  case "http":
+    return HTTP{}, nil
+  case "binary":
+    return Binary{}, nil
+  }
+
+  return nil, lsplog.MakeErr("unknown transport " + name)
+}
+
+/**
+ *  @brief the classic net/rpc over HTTP CONNECT on TCP
+ */
+type HTTP struct{}
+
+func (HTTP) Dial(hostport string) (Caller, error) {
+  return rpc.DialHTTP("tcp", hostport)
+}
+
+func (HTTP) Listen(
+    hostport string, srv *rpc.Server) (io.Closer, string, error) {
+  var mux *http.ServeMux = http.NewServeMux()
+  var l net.Listener
+  var addr string
+  var err error
+
+  l, addr, err = listenTCP(hostport)
+  if err != nil {
+    return nil, "", err
+  }
+
+  mux.Handle(rpc.DefaultRPCPath, srv)
+  go http.Serve(l, mux)
+
+  return l, addr, nil
+}
+
+/**@brief listen on hostport and report the address with the real port but
+ *        the caller's host name, so "localhost:0" becomes "localhost:4711"
+ * @param hostport
+ * @return net.Listener
+ * @return string
+ * @return error
+ */
+func listenTCP(hostport string) (net.Listener, string, error) {
+  var l net.Listener
+  var host, port string
+  var err error
+
+  host, _, err = net.SplitHostPort(hostport)
+  if err != nil {
+    return nil, "", err
+  }
+
+  l, err = net.Listen("tcp", hostport)
+  if err != nil {
+    return nil, "", err
+  }
+
+  _, port, _ = net.SplitHostPort(l.Addr().String())
+
+  return l, net.JoinHostPort(host, port), nil
+}
@@ -0,0 +1,310 @@
+/** @file wire.go
+ *  @brief compact binary encoding of rpc arguments and replies. Values are
+ *         written field by field without any type information, both sides
+ *         must use the same Go types, which they do since they share the
+ *         proto packages.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package transport
+
+import (
+  "encoding"
+  "encoding/binary"
+  "math"
+  "reflect"
+  "P2-f12/official/lsplog"
+)
+
+var (
+  binaryMarshaler = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+  binaryUnmarshaler =
+      reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+/**@brief append v to buf
+ *        bool: 1 byte, ints: zigzag varint, uints: uvarint,
+ *        floats: 8 bytes IEEE, strings and []byte: uvarint length + bytes,
+ *        slices and maps: uvarint length+1 (0 for nil) + elements,
+ *        pointers: 1 byte present + value, structs: exported fields in
+ *        order, types with MarshalBinary (time.Time): their bytes
+ * @param buf
+ * @param v
+ * @return []byte
+ * @return error
+ */
+func appendValue(buf []byte, v reflect.Value) ([]byte, error) {
+  var err error
+
+  if v.Type().Implements(binaryMarshaler) && v.Kind() != reflect.Ptr {
+    data, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+    if err != nil {
+      return nil, err
+    }
+    return appendBytes(buf, data), nil
+  }
+
+  switch v.Kind() {
+  case reflect.Bool:
+    if v.Bool() {
+      return append(buf, 1), nil
+    }
+    return append(buf, 0), nil
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return binary.AppendVarint(buf, v.Int()), nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+       reflect.Uint64, reflect.Uintptr:
+    return binary.AppendUvarint(buf, v.Uint()), nil
+  case reflect.Float32, reflect.Float64:
+    return binary.BigEndian.AppendUint64(buf, math.Float64bits(v.Float())), nil
+  case reflect.String:
+    return appendBytes(buf, []byte(v.String())), nil
+  case reflect.Slice:
+    if v.IsNil() {
+      return append(buf, 0), nil
+    }
+    if v.Type().Elem().Kind() == reflect.Uint8 {
+      return appendBytes(binary.AppendUvarint(buf, 1), v.Bytes()), nil
+    }
+    buf = binary.AppendUvarint(buf, uint64(v.Len()) + 1)
+    for i := 0; i < v.Len() && err == nil; i++ {
+      buf, err = appendValue(buf, v.Index(i))
+    }
+    return buf, err
+  case reflect.Array:
+    for i := 0; i < v.Len() && err == nil; i++ {
+      buf, err = appendValue(buf, v.Index(i))
+    }
+    return buf, err
+  case reflect.Map:
+    if v.IsNil() {
+      return append(buf, 0), nil
+    }
+    buf = binary.AppendUvarint(buf, uint64(v.Len()) + 1)
+    iter := v.MapRange()
+    for iter.Next() && err == nil {
+      buf, err = appendValue(buf, iter.Key())
+      if err == nil {
+        buf, err = appendValue(buf, iter.Value())
+      }
+    }
+    return buf, err
+  case reflect.Ptr:
+    if v.IsNil() {
+      return append(buf, 0), nil
+    }
+    return appendValue(append(buf, 1), v.Elem())
+  case reflect.Struct:
+    for i := 0; i < v.NumField() && err == nil; i++ {
+      if v.Type().Field(i).IsExported() {
+        buf, err = appendValue(buf, v.Field(i))
+      }
+    }
+    return buf, err
+  }
+
+  return nil, lsplog.MakeErr("wire: cannot encode " + v.Type().String())
+}
+
+func appendBytes(buf, data []byte) []byte {
+  return append(binary.AppendUvarint(buf, uint64(len(data))), data...)
+}
+
+/**
+ *  @brief reads values written by appendValue
+ */
+type wireReader struct {
+  data []byte
+}
+
+var errShort = lsplog.MakeErr("wire: message too short")
+
+func (r *wireReader) uvarint() (uint64, error) {
+  x, n := binary.Uvarint(r.data)
+  if n <= 0 {
+    return 0, errShort
+  }
+  r.data = r.data[n:]
+  return x, nil
+}
+
+func (r *wireReader) varint() (int64, error) {
+  x, n := binary.Varint(r.data)
+  if n <= 0 {
+    return 0, errShort
+  }
+  r.data = r.data[n:]
+  return x, nil
+}
+
+func (r *wireReader) next(n uint64) ([]byte, error) {
+  if uint64(len(r.data)) < n {
+    return nil, errShort
+  }
+  b := r.data[:n]
+  r.data = r.data[n:]
+  return b, nil
+}
+
+func (r *wireReader) bytes() ([]byte, error) {
+  n, err := r.uvarint()
+  if err != nil {
+    return nil, err
+  }
+  return r.next(n)
+}
+
+/**@brief decode into the settable value v
+ * @param v
+ * @return error
+ */
+func (r *wireReader) value(v reflect.Value) error {
+  if reflect.PtrTo(v.Type()).Implements(binaryUnmarshaler) &&
+     v.Kind() != reflect.Ptr {
+    data, err := r.bytes()
+    if err != nil {
+      return err
+    }
+    return v.Addr().Interface().(encoding.BinaryUnmarshaler).
+        UnmarshalBinary(data)
+  }
+
+  switch v.Kind() {
+  case reflect.Bool:
+    b, err := r.next(1)
+    if err != nil {
+      return err
+    }
+    v.SetBool(b[0] != 0)
+    return nil
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    x, err := r.varint()
+    v.SetInt(x)
+    return err
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+       reflect.Uint64, reflect.Uintptr:
+    x, err := r.uvarint()
+    v.SetUint(x)
+    return err
+  case reflect.Float32, reflect.Float64:
+    b, err := r.next(8)
+    if err != nil {
+      return err
+    }
+    v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(b)))
+    return nil
+  case reflect.String:
+    b, err := r.bytes()
+    v.SetString(string(b))
+    return err
+  case reflect.Slice:
+    n, err := r.uvarint()
+    if err != nil || n == 0 {
+      v.Set(reflect.Zero(v.Type()))
+      return err
+    }
+    if v.Type().Elem().Kind() == reflect.Uint8 {
+      b, err := r.bytes()
+      v.SetBytes(append([]byte{}, b...))
+      return err
+    }
+    //every element takes at least one byte, don't trust larger counts
+    if n - 1 > uint64(len(r.data)) {
+      return errShort
+    }
+    v.Set(reflect.MakeSlice(v.Type(), int(n - 1), int(n - 1)))
+    for i := 0; i < v.Len(); i++ {
+      if err = r.value(v.Index(i)); err != nil {
+        return err
+      }
+    }
+    return nil
+  case reflect.Array:
+    for i := 0; i < v.Len(); i++ {
+      if err := r.value(v.Index(i)); err != nil {
+        return err
+      }
+    }
+    return nil
+  case reflect.Map:
+    n, err := r.uvarint()
+    if err != nil || n == 0 {
+      v.Set(reflect.Zero(v.Type()))
+      return err
+    }
+    v.Set(reflect.MakeMap(v.Type()))
+    for i := uint64(1); i < n; i++ {
+      key := reflect.New(v.Type().Key()).Elem()
+      val := reflect.New(v.Type().Elem()).Elem()
+      if err = r.value(key); err != nil {
+        return err
+      }
+      if err = r.value(val); err != nil {
+        return err
+      }
+      v.SetMapIndex(key, val)
+    }
+    return nil
+  case reflect.Ptr:
+    b, err := r.next(1)
+    if err != nil {
+      return err
+    }
+    if b[0] == 0 {
+      v.Set(reflect.Zero(v.Type()))
+      return nil
+    }
+    if v.IsNil() {
+      v.Set(reflect.New(v.Type().Elem()))
+    }
+    return r.value(v.Elem())
+  case reflect.Struct:
+    for i := 0; i < v.NumField(); i++ {
+      if v.Type().Field(i).IsExported() {
+        if err := r.value(v.Field(i)); err != nil {
+          return err
+        }
+      }
+    }
+    return nil
+  }
+
+  return lsplog.MakeErr("wire: cannot decode " + v.Type().String())
+}
+
+/**@brief encode x, which may be a pointer, as appendValue does
+ * @param buf
+ * @param x
+ * @return []byte
+ * @return error
+ */
+func marshal(buf []byte, x interface{}) ([]byte, error) {
+  v := reflect.ValueOf(x)
+  for v.Kind() == reflect.Ptr && !v.IsNil() {
+    v = v.Elem()
+  }
+
+  return appendValue(buf, v)
+}
+
+/**@brief decode data into what the pointer x points to
+ * @param data
+ * @param x
+ * @return error
+ */
+func unmarshal(data []byte, x interface{}) error {
+  var r wireReader = wireReader{data}
+
+  v := reflect.ValueOf(x)
+  if v.Kind() != reflect.Ptr || v.IsNil() {
+    return lsplog.MakeErr("wire: decode target is not a pointer")
+  }
+  for v.Elem().Kind() == reflect.Ptr {
+    if v.Elem().IsNil() {
+      v.Elem().Set(reflect.New(v.Elem().Type().Elem()))
+    }
+    v = v.Elem()
+  }
+
+  return r.value(v.Elem())
+}
@@ -0,0 +1,172 @@
+/** @file wire_test.go
+ *  @brief round trips of every rpc argument and reply through the binary
+ *         encoding
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package transport
+
+import (
+  "fmt"
+  "reflect"
+  "testing"
+  "time"
+  "P2-f12/official/storageproto"
+  "P2-f12/official/tribproto"
+)
+
+//one of each type sent over rpc by the storage servers and tribservers
+var wireTypes = []interface{}{
+  &storageproto.LeaseStruct{},
+  &storageproto.GetArgs{},
+  &storageproto.GetReply{},
+  &storageproto.GetListReply{},
+  &storageproto.PutArgs{},
+  &storageproto.PutReply{},
+  &storageproto.Node{},
+  &storageproto.RegisterArgs{},
+  &storageproto.RegisterReply{},
+  &storageproto.GetServersArgs{},
+  &storageproto.RevokeLeaseArgs{},
+  &storageproto.RevokeLeaseReply{},
+  &tribproto.Tribble{},
+  &tribproto.CreateUserArgs{},
+  &tribproto.CreateUserReply{},
+  &tribproto.PostTribbleArgs{},
+  &tribproto.PostTribbleReply{},
+  &tribproto.PostReplyArgs{},
+  &tribproto.RetribbleArgs{},
+  &tribproto.QuoteTribbleArgs{},
+  &tribproto.LikeArgs{},
+  &tribproto.LikeReply{},
+  &tribproto.GetLikesArgs{},
+  &tribproto.GetLikesReply{},
+  &tribproto.GetThreadArgs{},
+  &tribproto.GetThreadReply{},
+  &tribproto.DeleteTribbleArgs{},
+  &tribproto.DeleteTribbleReply{},
+  &tribproto.EditTribbleArgs{},
+  &tribproto.EditTribbleReply{},
+  &tribproto.GetTribbleHistoryArgs{},
+  &tribproto.GetTribbleHistoryReply{},
+  &tribproto.SubscriptionArgs{},
+  &tribproto.SubscriptionReply{},
+  &tribproto.GetSubscriptionsArgs{},
+  &tribproto.GetSubscriptionsReply{},
+  &tribproto.GetFollowersArgs{},
+  &tribproto.GetFollowersReply{},
+  &tribproto.GetTribblesArgs{},
+  &tribproto.GetHashtagArgs{},
+  &tribproto.SearchArgs{},
+  &tribproto.GetTribblesReply{},
+}
+
+/**@brief give every field of v a value depending on seed, slices get two
+ *        elements so nothing is left at its zero value
+ * @param v settable
+ * @param seed
+ * @return void
+ */
+func fill(v reflect.Value, seed int) {
+  if v.Type() == reflect.TypeOf(time.Time{}) {
+    v.Set(reflect.ValueOf(time.Unix(int64(seed) * 1000, 17).UTC()))
+    return
+  }
+
+  switch v.Kind() {
+  case reflect.Bool:
+    v.SetBool(true)
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    v.SetInt(int64(-seed))
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+       reflect.Uint64:
+    v.SetUint(uint64(seed) * 1000003)
+  case reflect.Float32, reflect.Float64:
+    v.SetFloat(float64(seed) / 3)
+  case reflect.String:
+    v.SetString(fmt.Sprintf("field %d", seed))
+  case reflect.Slice:
+    v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+    for i := 0; i < 2; i++ {
+      fill(v.Index(i), seed * 2 + i)
+    }
+  case reflect.Struct:
+    for i := 0; i < v.NumField(); i++ {
+      if v.Type().Field(i).IsExported() {
+        fill(v.Field(i), seed + i + 1)
+      }
+    }
+  }
+}
+
+/**@brief filled values of every type decode to what was encoded
+ * @param t
+ * @return void
+ */
+func TestWireRoundTrip(t *testing.T) {
+  for _, x := range wireTypes {
+    in := reflect.New(reflect.TypeOf(x).Elem())
+    fill(in.Elem(), 1)
+
+    data, err := marshal(nil, in.Interface())
+    if err != nil {
+      t.Errorf("%T: %v", x, err)
+      continue
+    }
+    out := reflect.New(in.Type().Elem())
+    if err = unmarshal(data, out.Interface()); err != nil {
+      t.Errorf("%T: %v", x, err)
+      continue
+    }
+    if !reflect.DeepEqual(in.Interface(), out.Interface()) {
+      t.Errorf("%T: sent %+v, got %+v", x, in.Elem(), out.Elem())
+    }
+
+    //every field takes at least a byte, so every shorter message is short
+    for n := 0; n < len(data); n++ {
+      if err = unmarshal(data[:n], out.Interface()); err == nil {
+        t.Errorf("%T: decoded the first %d of %d bytes", x, n, len(data))
+        break
+      }
+    }
+  }
+}
+
+/**@brief nil and empty slices stay apart, zero values survive
+ * @param t
+ * @return void
+ */
+func TestWireEmpty(t *testing.T) {
+  for _, in := range []storageproto.GetListReply{
+      {},
+      {Value: []string{}, Packed: []byte{}},
+      {Value: []string{""}, Packed: []byte{0}}} {
+    var out storageproto.GetListReply
+
+    data, err := marshal(nil, &in)
+    if err == nil {
+      err = unmarshal(data, &out)
+    }
+    if err != nil || !reflect.DeepEqual(in, out) {
+      t.Errorf("sent %#v, got %#v, %v", in, out, err)
+    }
+  }
+}
+
+/**@brief values the encoding has no rule for are refused
+ * @param t
+ * @return void
+ */
+func TestWireUnsupported(t *testing.T) {
+  var out chan int
+
+  if _, err := marshal(nil, make(chan int)); err == nil {
+    t.Errorf("channel encoded")
+  }
+  if err := unmarshal([]byte{0}, &out); err == nil {
+    t.Errorf("channel decoded")
+  }
+  if err := unmarshal([]byte{0}, out); err == nil {
+    t.Errorf("decoded into a non pointer")
+  }
+}
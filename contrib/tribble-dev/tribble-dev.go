@@ -0,0 +1,47 @@
+package main
+
+// Development driver - runs storage servers, libstore and the tribble
+// server in one process and serves the tribble server on a single port.
+
+import (
+	"P2-f12/contrib/embedded"
+	"P2-f12/contrib/libstore"
+	"P2-f12/contrib/tribimpl"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+)
+
+var portnum *int = flag.Int("port", 9010, "port # to listen on")
+var numNodes *int = flag.Int("N", 3, "Number of embedded storage servers.")
+var exportStats *bool = flag.Bool("stats", false, "Serve libstore cache statistics as JSON at /stats")
+
+func main() {
+	flag.Parse()
+
+	l, e := net.Listen("tcp", fmt.Sprintf(":%d", *portnum))
+	if e != nil {
+		log.Fatal("listen error:", e)
+	}
+
+	cluster, err := embedded.NewCluster(*numNodes)
+	if err != nil {
+		log.Fatal("could not start storage cluster:", err)
+	}
+	ls, err := cluster.NewLibstore(libstore.NONE)
+	if err != nil {
+		log.Fatal("could not start libstore:", err)
+	}
+
+	log.Printf("Server starting on port %d with %d storage nodes\n", *portnum, *numNodes)
+	ts := tribimpl.NewTribserverWithStore(ls.NewSession())
+	rpc.Register(ts)
+	rpc.HandleHTTP()
+	if *exportStats {
+		http.Handle("/stats", ts.StatsHandler())
+	}
+	http.Serve(l, nil)
+}
@@ -0,0 +1,203 @@
+/** @file edit.go
+ *  @brief deleting and editing tribbles. A deleted tribble leaves a
+ *         tombstone under its id and leaves the author's :T list, its
+ *         conversation and the mention, tag and search lists. An edited
+ *         tribble keeps its id and place in the timelines, moves between
+ *         the mention, tag and search lists of its old and new contents,
+ *         and its earlier revisions are appended to the <id>:H list.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "encoding/json"
+  "fmt"
+  "time"
+  "P2-f12/contrib/libstore"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/storageproto"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief key of the list holding earlier revisions of a tribble
+ * @param id
+ * @return string
+ */
+func historyKey(id string) string {
+  return fmt.Sprintf("%s:H", id)
+}
+
+/**@brief read a tribble that userid may change
+ * @param userid
+ * @param id
+ * @return entry
+ * @return int tribproto status, OK if userid posted it
+ */
+func (ts *Tribserver) ownTribble(userid, id string) (entry, int) {
+  e, err := ts.getEntry(id)
+  if lsplog.CheckReport(1, err) || e.trib.Deleted {
+    return e, tribproto.ENOSUCHTRIBBLE
+  }
+  if e.trib.Userid != userid {
+    return e, tribproto.ENOTOWNER
+  }
+
+  return e, tribproto.OK
+}
+
+/**@brief delete a tribble of args.Userid. Readers that fetched the :T
+ *        list before it changed find the tombstone and skip it.
+ * @param DeleteTribbleArgs
+ * @param DeleteTribbleReply
+ * @return error
+ */
+func (ts *Tribserver) DeleteTribble(
+    args *tribproto.DeleteTribbleArgs,
+    reply *tribproto.DeleteTribbleReply) error {
+  var e entry
+  var revs []string
+  var err error
+
+  e, reply.Status = ts.ownTribble(args.Userid, args.Id)
+  if reply.Status != tribproto.OK {
+    return nil
+  }
+
+  //the user may retribble the original again
+  if isRetribble(e.trib) {
+    err = ts.Store.RemoveFromList(retribbleKey(args.Userid), e.trib.RepostOf)
+    if err != nil && !notInList(err) {
+      lsplog.CheckReport(1, err)
+    }
+  }
+
+  //tombstone first, so no reader sees the contents once we return
+  old := e.trib
+  e.trib.Contents = ""
+  e.trib.Deleted = true
+  err = libstore.PutJSON(ts.Store, args.Id, e.trib)
+  if lsplog.CheckReport(1, err) {
+    return err
+  }
+
+  ts.reindexMentions(old, e.trib)
+  ts.reindexHashtags(old, e.trib)
+  ts.reindexTerms(old, e.trib)
+
+  err = ts.Store.RemoveFromList(fmt.Sprintf("%s:T", args.Userid), args.Id)
+  if err != nil && err != libstore.MakeErr("RemoveFromList()",
+      storageproto.EITEMNOTFOUND) {
+    return err
+  }
+
+  //replies leave their conversation, the rest of it stays readable
+  if e.trib.Thread != "" {
+    err = ts.Store.RemoveFromList(threadKey(e.trib.Thread), args.Id)
+    if err != nil && !notInList(err) {
+      lsplog.CheckReport(1, err)
+    }
+  }
+
+  //earlier revisions go as well
+  revs, err = ts.Store.GetList(historyKey(args.Id))
+  if err == nil {
+    for _, rev := range revs {
+      err = ts.Store.RemoveFromList(historyKey(args.Id), rev)
+      if err != nil && !notInList(err) {
+        lsplog.CheckReport(1, err)
+      }
+    }
+  }
+
+  return nil
+}
+
+/**@brief replace the contents of a tribble of args.Userid, the old
+ *        contents are kept in its history
+ * @param EditTribbleArgs
+ * @param EditTribbleReply
+ * @return error
+ */
+func (ts *Tribserver) EditTribble(
+    args *tribproto.EditTribbleArgs,
+    reply *tribproto.EditTribbleReply) error {
+  var e entry
+  var old []byte
+  var err error
+
+  //do not allow empty edit, DeleteTribble is for that
+  if args.Contents == "" {
+    return nil
+  }
+
+  e, reply.Status = ts.ownTribble(args.Userid, args.Id)
+  if reply.Status != tribproto.OK || e.trib.Contents == args.Contents {
+    return nil
+  }
+
+  //the contents of a retribble are those of the original author
+  if isRetribble(e.trib) {
+    reply.Status = tribproto.ENOTOWNER
+    return nil
+  }
+
+  //history before the new contents, an edit is never lost from both
+  old, err = json.Marshal(e.trib)
+  if err != nil {
+    return err
+  }
+  err = ts.Store.AppendToList(historyKey(args.Id), string(old))
+  if err != nil && err != libstore.MakeErr("AppendToList()",
+      storageproto.EITEMEXISTS) {
+    return err
+  }
+
+  prev := e.trib
+  e.trib.Contents = args.Contents
+  e.trib.Edited = time.Now()
+  err = libstore.PutJSON(ts.Store, args.Id, e.trib)
+  if lsplog.CheckReport(1, err) {
+    return err
+  }
+
+  ts.reindexMentions(prev, e.trib)
+  ts.reindexHashtags(prev, e.trib)
+  ts.reindexTerms(prev, e.trib)
+
+  return nil
+}
+
+/**@brief all revisions of a tribble, oldest first
+ * @param GetTribbleHistoryArgs
+ * @param GetTribbleHistoryReply
+ * @return error
+ */
+func (ts *Tribserver) GetTribbleHistory(
+    args *tribproto.GetTribbleHistoryArgs,
+    reply *tribproto.GetTribbleHistoryReply) error {
+  var e entry
+  var revs []string
+  var err error
+
+  e, err = ts.getEntry(args.Id)
+  if lsplog.CheckReport(1, err) || e.trib.Deleted {
+    reply.Status = tribproto.ENOSUCHTRIBBLE
+    return nil
+  }
+
+  //never edited tribbles have no history list
+  revs, _ = ts.Store.GetList(historyKey(args.Id))
+
+  reply.Status = tribproto.OK
+  reply.Revisions = make([]tribproto.Tribble, 0, len(revs) + 1)
+  for _, rev := range revs {
+    var trib tribproto.Tribble
+    if json.Unmarshal([]byte(rev), &trib) == nil {
+      reply.Revisions = append(reply.Revisions, trib)
+    }
+  }
+  reply.Revisions = append(reply.Revisions, e.trib)
+
+  return nil
+}
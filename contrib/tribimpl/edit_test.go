@@ -0,0 +1,131 @@
+/** @file edit_test.go
+ *  @brief tests of what editing and deleting tribbles does to the lists
+ *         they are in
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "reflect"
+  "testing"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief an edit moves a tribble between the mention, tag and search
+ *        lists of its old and new contents, in its place by posting time
+ * @param t
+ * @return void
+ */
+func TestEditReindexes(t *testing.T) {
+  var edit tribproto.EditTribbleReply
+  var history tribproto.GetTribbleHistoryReply
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob", "carol")
+  first := mustPost(t, ts, "alice", "hello #go @bob world")
+  expectIds(t, "mentions of bob", mentionIds(t, ts, "bob"), first)
+  expectIds(t, "#go", tagIds(t, ts, "go"), first)
+  expectIds(t, "search hello", searchIds(t, ts, "hello", false), first)
+
+  second := mustPost(t, ts, "alice", "later #rust @carol")
+
+  err := ts.EditTribble(&tribproto.EditTribbleArgs{"alice", first,
+      "goodbye #rust @carol world"}, &edit)
+  if err != nil || edit.Status != tribproto.OK {
+    t.Fatalf("EditTribble: %d, %v", edit.Status, err)
+  }
+
+  expectIds(t, "mentions of bob", mentionIds(t, ts, "bob"))
+  expectIds(t, "mentions of carol", mentionIds(t, ts, "carol"),
+      second, first)
+  expectIds(t, "#go", tagIds(t, ts, "go"))
+  expectIds(t, "#rust", tagIds(t, ts, "rust"), second, first)
+  expectIds(t, "search hello", searchIds(t, ts, "hello", false))
+  expectIds(t, "search goodbye", searchIds(t, ts, "goodbye", false), first)
+  expectIds(t, "search world", searchIds(t, ts, "world", false), first)
+
+  err = ts.GetTribbleHistory(
+      &tribproto.GetTribbleHistoryArgs{first}, &history)
+  if err != nil || !reflect.DeepEqual(contentsOf(history.Revisions),
+      []string{"hello #go @bob world", "goodbye #rust @carol world"}) {
+    t.Errorf("history = %v, %v", contentsOf(history.Revisions), err)
+  }
+}
+
+/**@brief a deleted tribble leaves every list it was in, and its history
+ * @param t
+ * @return void
+ */
+func TestDeleteUnindexes(t *testing.T) {
+  var del tribproto.DeleteTribbleReply
+  var edit tribproto.EditTribbleReply
+  var reply, rt tribproto.PostTribbleReply
+  var thread tribproto.GetThreadReply
+  var history tribproto.GetTribbleHistoryReply
+  var page tribproto.GetTribblesReply
+
+  ts, store := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob")
+  root := mustPost(t, ts, "alice", "root #go @bob words")
+  kept := mustPost(t, ts, "alice", "kept #go words")
+
+  err := ts.EditTribble(
+      &tribproto.EditTribbleArgs{"alice", root, "edited #go @bob words"},
+      &edit)
+  if err != nil || edit.Status != tribproto.OK {
+    t.Fatalf("EditTribble: %d, %v", edit.Status, err)
+  }
+  err = ts.PostReply(
+      &tribproto.PostReplyArgs{"bob", root, "a reply"}, &reply)
+  if err != nil || reply.Status != tribproto.OK {
+    t.Fatalf("PostReply: %d, %v", reply.Status, err)
+  }
+  err = ts.Retribble(&tribproto.RetribbleArgs{"bob", kept}, &rt)
+  if err != nil || rt.Status != tribproto.OK {
+    t.Fatalf("Retribble: %d, %v", rt.Status, err)
+  }
+
+  for _, args := range []tribproto.DeleteTribbleArgs{
+      {"bob", reply.Id}, {"bob", rt.Id}, {"alice", root}} {
+    err = ts.DeleteTribble(&args, &del)
+    if err != nil || del.Status != tribproto.OK {
+      t.Fatalf("DeleteTribble(%v): %d, %v", args, del.Status, err)
+    }
+  }
+
+  expectIds(t, "mentions of bob", mentionIds(t, ts, "bob"))
+  expectIds(t, "#go", tagIds(t, ts, "go"), kept)
+  expectIds(t, "search words", searchIds(t, ts, "words", false), kept)
+
+  err = ts.GetTribbles(&tribproto.GetTribblesArgs{"alice", 0, ""}, &page)
+  if err != nil {
+    t.Fatal(err)
+  }
+  expectIds(t, "tribbles of alice", idsOf(page.Tribbles), kept)
+
+  //a deleted root still holds its conversation together
+  err = ts.GetThread(&tribproto.GetThreadArgs{root, 0, ""}, &thread)
+  if err != nil || thread.Status != tribproto.OK ||
+      !thread.Root.Deleted || len(thread.Replies) != 0 {
+    t.Errorf("thread of a deleted root = %+v, %v", thread, err)
+  }
+  if replies, _ := store.GetList(threadKey(root)); len(replies) != 0 {
+    t.Errorf("deleted reply still in the thread list: %v", replies)
+  }
+
+  err = ts.GetTribbleHistory(
+      &tribproto.GetTribbleHistoryArgs{root}, &history)
+  if err != nil || history.Status != tribproto.ENOSUCHTRIBBLE {
+    t.Errorf("history of a deleted tribble: %d, %v", history.Status, err)
+  }
+  if revs, _ := store.GetList(historyKey(root)); len(revs) != 0 {
+    t.Errorf("revisions left behind: %v", revs)
+  }
+
+  //the retribble is gone, so it can be made again
+  err = ts.Retribble(&tribproto.RetribbleArgs{"bob", kept}, &rt)
+  if err != nil || rt.Status != tribproto.OK {
+    t.Errorf("Retribble after delete: %d, %v", rt.Status, err)
+  }
+}
@@ -0,0 +1,172 @@
+/** @file follow.go
+ *  @brief followers. <userid>:F lists the users userid subscribes to, and
+ *         <target>:FOLLOWERS lists the users subscribed to target, oldest
+ *         first, so followers can be shown without reading the :F list of
+ *         every user. The :F list stays the truth: it is written first, and
+ *         RepairFollowers rebuilds :FOLLOWERS lists from it after a crash
+ *         between the two writes or for users who subscribed before the
+ *         index existed.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "fmt"
+  "P2-f12/contrib/libstore"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/storageproto"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief key of the list of users subscribed to target
+ * @param target
+ * @return string
+ */
+func followersKey(target string) string {
+  return fmt.Sprintf("%s:FOLLOWERS", target)
+}
+
+/**@brief note that userid now follows target, already noted is fine
+ * @param target
+ * @param userid
+ * @return void
+ */
+func (ts *Tribserver) addFollower(target, userid string) {
+  err := ts.Store.AppendToList(followersKey(target), userid)
+  if err != nil && err != libstore.MakeErr("AppendToList()",
+      storageproto.EITEMEXISTS) {
+    lsplog.CheckReport(1, err)
+  }
+}
+
+/**@brief note that userid no longer follows target
+ * @param target
+ * @param userid
+ * @return void
+ */
+func (ts *Tribserver) removeFollower(target, userid string) {
+  err := ts.Store.RemoveFromList(followersKey(target), userid)
+  if err != nil && !notInList(err) {
+    lsplog.CheckReport(1, err)
+  }
+}
+
+/**@brief users subscribed to args.Userid, a page of at most args.Limit
+ *        after args.Cursor, latest first
+ * @param GetFollowersArgs
+ * @param GetFollowersReply
+ * @return error
+ */
+func (ts *Tribserver) GetFollowers(
+    args *tribproto.GetFollowersArgs,
+    reply *tribproto.GetFollowersReply) error {
+  var ids []string
+  var start, end int
+  var err error
+
+  _, err = ts.Store.GetList(fmt.Sprintf("%s:T", args.Userid))
+  if lsplog.CheckReport(1, err) {
+    reply.Status = tribproto.ENOSUCHUSER
+    reply.Userids = nil
+    return nil
+  }
+
+  //no list until the first follower
+  ids, _ = ts.Store.GetList(followersKey(args.Userid))
+
+  start, err = listStart(ids, args.Cursor)
+  if err != nil {
+    return err
+  }
+
+  end = start - pageLimit(args.Limit)
+  if end < 0 {
+    end = 0
+  }
+
+  reply.Status = tribproto.OK
+  reply.Count = len(ids)
+  reply.Userids = []string{}
+  for i := start - 1; i >= end; i-- {
+    reply.Userids = append(reply.Userids, ids[i])
+  }
+
+  reply.NextCursor = ""
+  if end > 0 && end < start {
+    reply.NextCursor = listCursorAt(ids, end)
+  }
+
+  return nil
+}
+
+/**@brief rebuild the :FOLLOWERS lists of userids and of the users they
+ *        subscribe to from the :F lists of userids. Followers missing from
+ *        a list are added, and followers among userids that no longer
+ *        subscribe are removed; others are left alone, since storage
+ *        cannot list every user and their :F lists are not read.
+ * @param store
+ * @param userids
+ * @return int followers added
+ * @return int followers removed
+ * @return error
+ */
+func RepairFollowers(store libstore.KVStore,
+    userids []string) (int, int, error) {
+  var ts *Tribserver = NewTribserverWithStore(store)
+  var follows map[string]map[string]bool = make(map[string]map[string]bool)
+  var want map[string][]string = make(map[string][]string)
+  var targets []string
+  var added, removed int
+
+  for _, userid := range userids {
+    if _, read := follows[userid]; read {
+      continue
+    }
+    subs, err := store.GetList(fmt.Sprintf("%s:F", userid))
+    if err != nil {
+      //users without subscriptions have no list either
+      if _, err = store.GetList(fmt.Sprintf("%s:T", userid)); err != nil {
+        return added, removed,
+            lsplog.MakeErr(fmt.Sprintf("no such user %s", userid))
+      }
+    }
+
+    follows[userid] = make(map[string]bool)
+    targets = append(targets, userid)
+    for _, target := range subs {
+      follows[userid][target] = true
+      want[target] = append(want[target], userid)
+    }
+  }
+
+  for target := range want {
+    if _, listed := follows[target]; !listed {
+      targets = append(targets, target)
+      follows[target] = nil
+    }
+  }
+
+  for _, target := range targets {
+    have, _ := store.GetList(followersKey(target))
+    present := make(map[string]bool)
+
+    for _, userid := range have {
+      present[userid] = true
+      subs, read := follows[userid]
+      if read && subs != nil && !subs[target] {
+        ts.removeFollower(target, userid)
+        removed++
+      }
+    }
+
+    for _, userid := range want[target] {
+      if !present[userid] {
+        ts.addFollower(target, userid)
+        added++
+      }
+    }
+  }
+
+  return added, removed, nil
+}
@@ -0,0 +1,141 @@
+/** @file follow_test.go
+ *  @brief tests of the follower index and its repair
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "reflect"
+  "testing"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief followers of target, newest first, following every cursor
+ * @param t
+ * @param ts
+ * @param target
+ * @param limit
+ * @return [][]string userids per page
+ * @return int Count of the first page
+ */
+func followerPages(t *testing.T, ts *Tribserver, target string,
+    limit int) ([][]string, int) {
+  t.Helper()
+  var pages [][]string
+  var count int
+
+  args := tribproto.GetFollowersArgs{target, limit, ""}
+  for {
+    var reply tribproto.GetFollowersReply
+    err := ts.GetFollowers(&args, &reply)
+    if err != nil || reply.Status != tribproto.OK || len(pages) > 100 {
+      t.Fatalf("page %d of followers of %s: %d, %v", len(pages), target,
+          reply.Status, err)
+    }
+    if len(pages) == 0 {
+      count = reply.Count
+    }
+    pages = append(pages, reply.Userids)
+    if reply.NextCursor == "" {
+      return pages, count
+    }
+    args.Cursor = reply.NextCursor
+  }
+}
+
+/**@brief followers page newest first, with empty and exactly full last
+ *        pages and a cursor whose follower left
+ * @param t
+ * @return void
+ */
+func TestGetFollowers(t *testing.T) {
+  var reply tribproto.GetFollowersReply
+  var sub tribproto.SubscriptionReply
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "target", "b", "c", "d", "e")
+
+  err := ts.GetFollowers(&tribproto.GetFollowersArgs{"nobody", 0, ""}, &reply)
+  if err != nil || reply.Status != tribproto.ENOSUCHUSER {
+    t.Errorf("followers of an unknown user: %d, %v", reply.Status, err)
+  }
+
+  pages, count := followerPages(t, ts, "target", 2)
+  if count != 0 || len(pages) != 1 || len(pages[0]) != 0 {
+    t.Errorf("no followers = %v, count %d", pages, count)
+  }
+
+  for _, userid := range []string{"b", "c", "d", "e"} {
+    mustFollow(t, ts, userid, "target")
+  }
+  pages, count = followerPages(t, ts, "target", 2)
+  if count != 4 || !reflect.DeepEqual(pages,
+      [][]string{{"e", "d"}, {"c", "b"}}) {
+    t.Errorf("followers = %v, count %d", pages, count)
+  }
+
+  args := tribproto.GetFollowersArgs{"target", 2, ""}
+  if err = ts.GetFollowers(&args, &reply); err != nil {
+    t.Fatal(err)
+  }
+  err = ts.RemoveSubscription(&tribproto.SubscriptionArgs{"d", "target"}, &sub)
+  if err != nil || sub.Status != tribproto.OK {
+    t.Fatalf("RemoveSubscription: %d, %v", sub.Status, err)
+  }
+  args.Cursor = reply.NextCursor
+  if err = ts.GetFollowers(&args, &reply); err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(reply.Userids, []string{"c", "b"}) ||
+      reply.NextCursor != "" {
+    t.Errorf("page after a stale cursor = %v, %q",
+        reply.Userids, reply.NextCursor)
+  }
+}
+
+/**@brief a repair adds followers missing from the index and removes
+ *        those among the users repaired that no longer subscribe
+ * @param t
+ * @return void
+ */
+func TestRepairFollowers(t *testing.T) {
+  ts, store := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob", "carol", "dave")
+  mustFollow(t, ts, "alice", "bob")
+
+  //subscribed before the index existed
+  if err := store.AppendToList("carol:F", "bob"); err != nil {
+    t.Fatal(err)
+  }
+  //a crash between the two writes of an unsubscribe
+  if err := store.RemoveFromList("alice:F", "bob"); err != nil {
+    t.Fatal(err)
+  }
+  //not repaired, so left alone
+  if err := store.AppendToList(followersKey("bob"), "dave"); err != nil {
+    t.Fatal(err)
+  }
+
+  added, removed, err := RepairFollowers(store, []string{"alice", "carol"})
+  if err != nil || added != 1 || removed != 1 {
+    t.Errorf("RepairFollowers = %d added, %d removed, %v",
+        added, removed, err)
+  }
+  followers, _ := store.GetList(followersKey("bob"))
+  if !reflect.DeepEqual(followers, []string{"dave", "carol"}) {
+    t.Errorf("followers of bob = %v", followers)
+  }
+
+  //nothing left to do
+  added, removed, err = RepairFollowers(store, []string{"alice", "carol"})
+  if err != nil || added != 0 || removed != 0 {
+    t.Errorf("second RepairFollowers = %d added, %d removed, %v",
+        added, removed, err)
+  }
+
+  _, _, err = RepairFollowers(store, []string{"nobody"})
+  if err == nil {
+    t.Errorf("repair of an unknown user succeeded")
+  }
+}
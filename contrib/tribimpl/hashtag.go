@@ -0,0 +1,186 @@
+/** @file hashtag.go
+ *  @brief #tag timelines. Posting a tribble appends an index item
+ *         "<posted>:<id>" to the <tag>:TAG list of every tag in it, and
+ *         editing it moves it between the lists of its old and new tags.
+ *         Tags are normalized first so that #Go, #ＧＯ and #go share a list:
+ *         case is folded, fullwidth forms become ASCII and accents are
+ *         dropped, which also makes composed and decomposed letters agree
+ *         without the normalization tables the standard library lacks.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "fmt"
+  "regexp"
+  "strings"
+  "unicode"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/tribproto"
+)
+
+const HASHTAG_MAX = 10 // tags indexed per tribble, later tags are ignored
+
+// # or fullwidth ＃ at the start or after a character that cannot be part
+// of a tag, so "C#" and "&#39;" are not tags
+var hashtagRegex = regexp.MustCompile(
+    `(?:^|[^\pL\pN\pM_&#＃])[#＃]([\pL\pN\pM_]+)`)
+
+// precomposed lower case Latin letters and their unaccented base letters
+const accentFrom = "àáâãäåçèéêëìíîïñòóôõöùúûüýÿāăąćĉ" +
+    "ċčďēĕėęěĝğġģĥĩīĭįĵķĺļľńņňōŏőŕŗřś" +
+    "ŝşšţťũūŭůűųŵŷźżžơưǎǐǒǔǖǘǚǜǟǡǧǩǫǭ" +
+    "ǰǵǹǻȁȃȅȇȉȋȍȏȑȓȕȗșțȟȧȩȫȭȯȱȳ"
+const accentTo = "aaaaaaceeeeiiiinooooouuuuyyaaacc" +
+    "ccdeeeeegggghiiiijklllnnnooorrrs" +
+    "sssttuuuuuuwyzzzouaiouuuuuaagkoo" +
+    "jgnaaaeeiioorruusthaeooooy"
+
+var unaccent map[rune]rune = make(map[rune]rune)
+
+// letters that fold to more than one
+var foldWide = map[rune]string {
+  'ß': "ss", 'ﬀ': "ff", 'ﬁ': "fi", 'ﬂ': "fl", 'ﬃ': "ffi", 'ﬄ': "ffl",
+  'ﬅ': "st", 'ﬆ': "st",
+}
+
+func init() {
+  var to []rune = []rune(accentTo)
+
+  for i, r := range []rune(accentFrom) {
+    unaccent[r] = to[i]
+  }
+}
+
+/**@brief key of the list of tribbles tagged with a normalized tag
+ * @param tag
+ * @return string
+ */
+func hashtagKey(tag string) string {
+  return fmt.Sprintf("%s:TAG", tag)
+}
+
+/**@brief fold case, width and accents of a word
+ * @param word
+ * @return string
+ */
+func fold(word string) string {
+  var b strings.Builder
+
+  for _, r := range word {
+    //fullwidth ASCII
+    if r >= 0xFF01 && r <= 0xFF5E {
+      r -= 0xFEE0
+    }
+    //combining accents of decomposed letters
+    if unicode.Is(unicode.Mn, r) {
+      continue
+    }
+
+    r = unicode.ToLower(unicode.ToUpper(r))
+    if base, ok := unaccent[r]; ok {
+      r = base
+    }
+    if wide, ok := foldWide[r]; ok {
+      b.WriteString(wide)
+      continue
+    }
+    b.WriteRune(r)
+  }
+
+  return b.String()
+}
+
+/**@brief the form of a tag its list is stored under, "" if it has no
+ *        letter, as in "#1"
+ * @param tag without the #
+ * @return string
+ */
+func normalizeTag(tag string) string {
+  tag = fold(tag)
+  if strings.IndexFunc(tag, unicode.IsLetter) < 0 {
+    return ""
+  }
+
+  return tag
+}
+
+/**@brief normalized tags in contents, each once, in order
+ * @param contents
+ * @return []string
+ */
+func hashtags(contents string) []string {
+  var tags []string
+  var seen map[string]bool = make(map[string]bool)
+
+  for _, m := range hashtagRegex.FindAllStringSubmatch(contents, -1) {
+    tag := normalizeTag(m[1])
+    if tag != "" && !seen[tag] && len(tags) < HASHTAG_MAX {
+      seen[tag] = true
+      tags = append(tags, tag)
+    }
+  }
+
+  return tags
+}
+
+/**@brief add a new tribble to the lists of its tags
+ * @param trib
+ * @return void
+ */
+func (ts *Tribserver) indexHashtags(trib tribproto.Tribble) {
+  ts.reindexHashtags(tribproto.Tribble{}, trib)
+}
+
+/**@brief move a tribble from the lists of the tags of old to those of
+ *        trib, for edits and, with an empty trib, deletes
+ * @param old
+ * @param trib
+ * @return void
+ */
+func (ts *Tribserver) reindexHashtags(old, trib tribproto.Tribble) {
+  ts.reindex(old, trib, hashtags(old.Contents), hashtags(trib.Contents),
+      hashtagKey)
+}
+
+/**@brief tribbles of all users tagged args.Tag, a page of at most
+ *        args.Limit starting after args.Cursor
+ * @param GetHashtagArgs
+ * @param GetTribblesReply
+ * @return error
+ */
+func (ts *Tribserver) GetTribblesByHashtag(
+    args *tribproto.GetHashtagArgs, reply *tribproto.GetTribblesReply) error {
+  var tag string
+  var items []string
+  var pos *position
+  var page []entry
+  var more bool
+  var err error
+
+  pos, err = decodeCursor(args.Cursor)
+  if err != nil {
+    return err
+  }
+
+  reply.Status = tribproto.OK
+  reply.Tribbles = []tribproto.Tribble{}
+
+  tag = normalizeTag(strings.TrimLeft(args.Tag, "#＃"))
+  if tag == "" {
+    return nil
+  }
+
+  //no list until the first tribble with the tag
+  items, _ = ts.Store.GetList(hashtagKey(tag))
+
+  page, more, err = ts.userPage(indexIds(items), pos, pageLimit(args.Limit))
+  if lsplog.CheckReport(1, err) {
+    return lsplog.MakeErr("Get Hashtag Message Error")
+  }
+
+  ts.fillPage(reply, page, more, pageLimit(args.Limit))
+
+  return nil
+}
@@ -0,0 +1,60 @@
+/** @file hashtag_test.go
+ *  @brief tests of #tag timelines
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "reflect"
+  "testing"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief tags differing only in case, width or accents are the same tag,
+ *        and # inside words does not start one
+ * @param t
+ * @return void
+ */
+func TestHashtagsParse(t *testing.T) {
+  got := hashtags("#Go and #ＧＯ and #gö, C# &#39; #café #CAFE #42")
+  if !reflect.DeepEqual(got, []string{"go", "cafe"}) {
+    t.Errorf("hashtags = %v", got)
+  }
+}
+
+/**@brief a tag lists the tribbles of every user with it, newest first and
+ *        a page at a time, whichever way the tag is written
+ * @param t
+ * @return void
+ */
+func TestGetTribblesByHashtag(t *testing.T) {
+  var reply tribproto.GetTribblesReply
+  var want []string
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob")
+  expectIds(t, "unused tag", tagIds(t, ts, "go"))
+  expectIds(t, "no tag", tagIds(t, ts, "#"))
+
+  for i := 0; i < 3; i++ {
+    want = append([]string{mustPost(t, ts, "alice", "#Go")}, want...)
+    want = append([]string{mustPost(t, ts, "bob", "#ＧＯ")}, want...)
+  }
+  mustPost(t, ts, "bob", "#rust")
+
+  var got []string
+  args := tribproto.GetHashtagArgs{"#GO", 4, ""}
+  for pages := 0; ; pages++ {
+    err := ts.GetTribblesByHashtag(&args, &reply)
+    if err != nil || reply.Status != tribproto.OK || pages > 3 {
+      t.Fatalf("page %d: %d, %v", pages, reply.Status, err)
+    }
+    got = append(got, idsOf(reply.Tribbles)...)
+    if reply.NextCursor == "" {
+      break
+    }
+    args.Cursor = reply.NextCursor
+  }
+  expectIds(t, "#go", got, want...)
+}
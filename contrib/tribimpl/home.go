@@ -0,0 +1,311 @@
+/** @file home.go
+ *  @brief fan-out-on-write home timelines, used when HomeSize > 0. Posting
+ *         a tribble appends an item "<posted>:<id>" to the
+ *         <follower>:HOME list of every follower of its author, found in
+ *         the :FOLLOWERS index. Once a tribserver has pushed HomeSize items
+ *         to a list since it last cut it, a background job cuts it back to
+ *         the newest HomeSize items, so posting never reads home lists and
+ *         a list holds at most HomeSize more items per tribserver. Since
+ *         the items carry the feed position, the feed
+ *         is read from this one list and only the tribbles of the returned
+ *         page are read, with the same cursors as the merged feed. Pages
+ *         older than a full list are merged from the :T lists.
+ *         A home list is only used once a backfill job has added the
+ *         tribbles of every user followed and appended HOME_READY; until
+ *         then the feed is merged from the :T lists as without fan-out.
+ *         Adding a subscription starts a job backfilling the new target.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "fmt"
+  "sort"
+  "strconv"
+  "strings"
+  "P2-f12/contrib/libstore"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/storageproto"
+  "P2-f12/official/tribproto"
+)
+
+const HOME_READY = "ready" // item of a home list holding every user followed
+
+/**@brief key of the home timeline of userid
+ * @param userid
+ * @return string
+ */
+func homeKey(userid string) string {
+  return fmt.Sprintf("%s:HOME", userid)
+}
+
+/**@brief item of homeKey for a tribble
+ * @param trib
+ * @return string
+ */
+func homeItem(trib tribproto.Tribble) string {
+  return entry{trib, trib.Id}.position().homeItem()
+}
+
+/**@brief feed position of an item of homeKey, the author is read from
+ *        the id
+ * @param item
+ * @return position
+ * @return bool false for HOME_READY
+ */
+func splitHome(item string) (position, bool) {
+  var p position
+  var posted string
+  var found bool
+  var err error
+
+  posted, p.Id, found = strings.Cut(item, ":")
+  if !found {
+    return p, false
+  }
+
+  p.Posted, err = strconv.ParseInt(posted, 10, 64)
+  p.Userid = authorOf(p.Id)
+
+  return p, err == nil
+}
+
+/**@brief item of homeKey at a position
+ * @param p
+ * @return string
+ */
+func (p position) homeItem() string {
+  return fmt.Sprintf("%d:%s", p.Posted, p.Id)
+}
+
+/**@brief positions of the items of a home list, newest first
+ * @param items
+ * @return []position
+ * @return bool whether HOME_READY is among them
+ */
+func homePositions(items []string) ([]position, bool) {
+  var list []position
+  var ready bool
+
+  for _, item := range items {
+    if p, ok := splitHome(item); ok {
+      list = append(list, p)
+    } else if item == HOME_READY {
+      ready = true
+    }
+  }
+  sort.Slice(list, func(a, b int) bool {
+    return list[a].before(list[b])
+  })
+
+  return list, ready
+}
+
+/**@brief append an item to the home list of userid, already there is fine
+ * @param userid
+ * @param item
+ * @return void
+ */
+func (ts *Tribserver) appendHome(userid, item string) {
+  err := ts.Store.AppendToList(homeKey(userid), item)
+  if err != nil && err != libstore.MakeErr("AppendToList()",
+      storageproto.EITEMEXISTS) {
+    lsplog.CheckReport(1, err)
+  }
+}
+
+/**@brief cut the home list of userid back to the newest HomeSize tribbles
+ * @param userid
+ * @return void
+ */
+func (ts *Tribserver) trimHome(userid string) {
+  items, err := ts.Store.GetList(homeKey(userid))
+  if err != nil {
+    return
+  }
+
+  list, _ := homePositions(items)
+  for i := ts.HomeSize; i < len(list); i++ {
+    err = ts.Store.RemoveFromList(homeKey(userid), list[i].homeItem())
+    //another tribserver trimmed it first
+    if err != nil && !notInList(err) {
+      lsplog.CheckReport(1, err)
+    }
+  }
+}
+
+/**@brief push a new tribble to the home lists of the followers of its
+ *        author
+ * @param trib
+ * @return void
+ */
+func (ts *Tribserver) pushHome(trib tribproto.Tribble) {
+  if ts.HomeSize <= 0 {
+    return
+  }
+
+  //no list until the first follower
+  followers, _ := ts.Store.GetList(followersKey(trib.Userid))
+  for _, userid := range followers {
+    ts.appendHome(userid, homeItem(trib))
+    if ts.pushedHome(userid) {
+      go ts.trimHome(userid)
+    }
+  }
+}
+
+/**@brief count a push to the home list of userid
+ * @param userid
+ * @return bool whether HomeSize items were pushed since the last cut, the
+ *         count then starts over
+ */
+func (ts *Tribserver) pushedHome(userid string) bool {
+  ts.homeLock.Lock()
+  defer ts.homeLock.Unlock()
+
+  ts.homePushes[userid]++
+  if ts.homePushes[userid] < ts.HomeSize {
+    return false
+  }
+  delete(ts.homePushes, userid)
+  return true
+}
+
+/**@brief add the newest HomeSize tribbles of each target to the home list
+ *        of userid, and with ready mark the list as holding every user
+ *        followed. userid is noted as a follower of each target first, so
+ *        that later posts are pushed to the list as well
+ * @param userid
+ * @param targets
+ * @param ready
+ * @return void
+ */
+func (ts *Tribserver) backfillHome(userid string, targets []string,
+    ready bool) {
+  for _, target := range targets {
+    ts.addFollower(target, userid)
+
+    ids, err := ts.Store.GetList(fmt.Sprintf("%s:T", target))
+    if lsplog.CheckReport(1, err) {
+      continue
+    }
+
+    for i := len(ids) - 1; i >= 0 && i >= len(ids) - ts.HomeSize; i-- {
+      e, err := ts.getEntry(ids[i])
+      if lsplog.CheckReport(1, err) {
+        //leave the list unready, the next read tries again
+        return
+      }
+      if !e.trib.Deleted {
+        ts.appendHome(userid, homeItem(e.trib))
+      }
+    }
+  }
+
+  if ready {
+    ts.appendHome(userid, HOME_READY)
+  }
+  ts.trimHome(userid)
+}
+
+/**@brief backfill the whole home list of userid in the background, once
+ *        at a time per user on this tribserver
+ * @param userid
+ * @param follows users userid subscribes to
+ * @return void
+ */
+func (ts *Tribserver) buildHome(userid string, follows []string) {
+  ts.homeLock.Lock()
+  defer ts.homeLock.Unlock()
+
+  if ts.homeJobs[userid] {
+    return
+  }
+  ts.homeJobs[userid] = true
+
+  go func() {
+    ts.backfillHome(userid, follows, true)
+
+    ts.homeLock.Lock()
+    delete(ts.homeJobs, userid)
+    ts.homeLock.Unlock()
+  }()
+}
+
+/**@brief up to limit tribbles of the home list of userid after pos,
+ *        newest first, leaving out users no longer followed. A full list
+ *        may miss older tribbles, past its end the page is filled from the
+ *        merged :T lists
+ * @param userid
+ * @param follows users userid subscribes to
+ * @param pos nil for the newest
+ * @param limit
+ * @return []entry
+ * @return bool whether older tribbles remain
+ * @return bool false if the list is not ready, the page is then empty and
+ *         a backfill job is started
+ * @return error
+ */
+func (ts *Tribserver) homePage(userid string, follows []string,
+    pos *position, limit int) ([]entry, bool, bool, error) {
+  var following map[string]bool = make(map[string]bool)
+  var page []entry
+  var i int
+
+  //no list until the first push
+  items, _ := ts.Store.GetList(homeKey(userid))
+  list, ready := homePositions(items)
+  if !ready {
+    ts.buildHome(userid, follows)
+    return nil, false, false, nil
+  }
+
+  for _, target := range follows {
+    following[target] = true
+  }
+
+  if pos != nil {
+    i = sort.Search(len(list), func(j int) bool {
+      return pos.before(list[j])
+    })
+  }
+
+  for ; i < len(list) && len(page) < limit; i++ {
+    if !following[list[i].Userid] {
+      continue
+    }
+    e, err := ts.getEntry(list[i].Id)
+    if err != nil {
+      return nil, false, true, err
+    }
+    //deleted since it was pushed
+    if !e.trib.Deleted {
+      page = append(page, e)
+    }
+  }
+
+  //more only if a followed user has something left
+  for i < len(list) && !following[list[i].Userid] {
+    i++
+  }
+  if i < len(list) || len(list) < ts.HomeSize {
+    return page, i < len(list), true, nil
+  }
+  if len(page) == limit {
+    return page, true, true, nil
+  }
+
+  //cut back or backfilled HomeSize per user, older ones are only in :T
+  if len(page) > 0 {
+    last := page[len(page) - 1].position()
+    pos = &last
+  }
+  rest, more, err := ts.feedPage(ts.followedLists(follows), pos,
+      limit - len(page))
+  if err != nil {
+    return nil, false, true, err
+  }
+
+  return append(page, rest...), more, true, nil
+}
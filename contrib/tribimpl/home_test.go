@@ -0,0 +1,244 @@
+/** @file home_test.go
+ *  @brief tests of fan-out-on-write home timelines
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "reflect"
+  "testing"
+  "P2-f12/contrib/fakestore"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief whether the home list of userid is ready and its backfill job
+ *        done
+ * @param ts
+ * @param store
+ * @param userid
+ * @return bool
+ */
+func homeBuilt(ts *Tribserver, store *fakestore.FakeStore,
+    userid string) bool {
+  ts.homeLock.Lock()
+  running := ts.homeJobs[userid]
+  ts.homeLock.Unlock()
+
+  items, _ := store.GetList(homeKey(userid))
+  _, ready := homePositions(items)
+  return ready && !running
+}
+
+/**@brief until its home list is ready a feed is merged from the timelines
+ *        of the users followed, afterwards it is read from the list alone
+ * @param t
+ * @return void
+ */
+func TestHomeFallbackBeforeReady(t *testing.T) {
+  var reply tribproto.GetTribblesReply
+  var timelineReads int
+
+  ts, store := newTestServer(t)
+  ts.HomeSize = 10
+  mustCreate(t, ts, "alice", "bob", "carol")
+  mustPost(t, ts, "bob", "old")
+  mustFollow(t, ts, "alice", "bob", "carol")
+  mustPost(t, ts, "carol", "new")
+
+  args := tribproto.GetTribblesArgs{"alice", 0, ""}
+  err := ts.GetTribblesBySubscription(&args, &reply)
+  if err != nil || !reflect.DeepEqual(contentsOf(reply.Tribbles),
+      []string{"new", "old"}) {
+    t.Fatalf("feed before ready = %v, %v", contentsOf(reply.Tribbles), err)
+  }
+
+  eventually(t, "home list", func() bool {
+    return homeBuilt(ts, store, "alice")
+  })
+  mustPost(t, ts, "bob", "newest")
+
+  store.SetHook(func(op, key string) error {
+    if op == "GetList" && (key == "bob:T" || key == "carol:T") {
+      timelineReads++
+    }
+    return nil
+  })
+  err = ts.GetTribblesBySubscription(&args, &reply)
+  store.SetHook(nil)
+
+  if err != nil || !reflect.DeepEqual(contentsOf(reply.Tribbles),
+      []string{"newest", "new", "old"}) {
+    t.Errorf("feed when ready = %v, %v", contentsOf(reply.Tribbles), err)
+  }
+  if timelineReads != 0 {
+    t.Errorf("ready feed read %d timelines", timelineReads)
+  }
+}
+
+/**@brief a backfill notes the follower, so that later posts are pushed
+ *        even when the :FOLLOWERS index missed the subscription
+ * @param t
+ * @return void
+ */
+func TestHomeBackfillAddsFollower(t *testing.T) {
+  var reply tribproto.GetFollowersReply
+
+  ts, store := newTestServer(t)
+  ts.HomeSize = 10
+  mustCreate(t, ts, "alice", "bob")
+  //subscribed before the index existed
+  if err := store.AppendToList("alice:F", "bob"); err != nil {
+    t.Fatal(err)
+  }
+
+  ts.backfillHome("alice", []string{"bob"}, true)
+
+  err := ts.GetFollowers(&tribproto.GetFollowersArgs{"bob", 0, ""}, &reply)
+  if err != nil || !reflect.DeepEqual(reply.Userids, []string{"alice"}) {
+    t.Fatalf("followers of bob = %v, %v", reply.Userids, err)
+  }
+
+  id := mustPost(t, ts, "bob", "pushed")
+  items, _ := store.GetList(homeKey("alice"))
+  list, ready := homePositions(items)
+  if !ready || len(list) != 1 || list[0].Id != id {
+    t.Errorf("home of alice = %v", items)
+  }
+}
+
+/**@brief posting does not read home lists, they are cut back in the
+ *        background once HomeSize items were pushed
+ * @param t
+ * @return void
+ */
+func TestHomeTrim(t *testing.T) {
+  var homeReads int
+
+  ts, store := newTestServer(t)
+  ts.HomeSize = 3
+  mustCreate(t, ts, "alice", "bob")
+  mustFollow(t, ts, "alice", "bob")
+  //the first read starts the backfill
+  err := ts.GetTribblesBySubscription(
+      &tribproto.GetTribblesArgs{"alice", 0, ""},
+      &tribproto.GetTribblesReply{})
+  if err != nil {
+    t.Fatal(err)
+  }
+  eventually(t, "home list", func() bool {
+    return homeBuilt(ts, store, "alice")
+  })
+
+  store.SetHook(func(op, key string) error {
+    if op == "GetList" && key == homeKey("alice") {
+      homeReads++
+    }
+    return nil
+  })
+  mustPost(t, ts, "bob", "1")
+  mustPost(t, ts, "bob", "2")
+  reads := homeReads
+  store.SetHook(nil)
+  if reads != 0 {
+    t.Errorf("posting read the home list %d times", reads)
+  }
+
+  var last string
+  for i := 3; i <= 7; i++ {
+    last = mustPost(t, ts, "bob", "more")
+  }
+
+  eventually(t, "trimmed home list", func() bool {
+    items, _ := store.GetList(homeKey("alice"))
+    list, _ := homePositions(items)
+    return len(list) <= ts.HomeSize
+  })
+  items, _ := store.GetList(homeKey("alice"))
+  list, ready := homePositions(items)
+  if !ready || list[0].Id != last {
+    t.Errorf("home of alice after trim = %v", items)
+  }
+}
+
+/**@brief start the home list of userid with a first feed read and wait
+ *        for its backfill
+ * @param t
+ * @param ts
+ * @param store
+ * @param userid
+ * @return void
+ */
+func buildHomeOf(t *testing.T, ts *Tribserver, store *fakestore.FakeStore,
+    userid string) {
+  t.Helper()
+  err := ts.GetTribblesBySubscription(
+      &tribproto.GetTribblesArgs{userid, 0, ""},
+      &tribproto.GetTribblesReply{})
+  if err != nil {
+    t.Fatal(err)
+  }
+  eventually(t, "home list", func() bool {
+    return homeBuilt(ts, store, userid)
+  })
+}
+
+/**@brief a home page says nothing follows when only tribbles of users no
+ *        longer followed are left in the list
+ * @param t
+ * @return void
+ */
+func TestHomeMoreSkipsUnfollowed(t *testing.T) {
+  var sub tribproto.SubscriptionReply
+  var reply tribproto.GetTribblesReply
+
+  ts, store := newTestServer(t)
+  ts.HomeSize = 10
+  mustCreate(t, ts, "alice", "bob", "carol")
+  mustFollow(t, ts, "alice", "bob", "carol")
+  mustPost(t, ts, "carol", "c1")
+  mustPost(t, ts, "carol", "c2")
+  b1 := mustPost(t, ts, "bob", "b1")
+  b2 := mustPost(t, ts, "bob", "b2")
+  buildHomeOf(t, ts, store, "alice")
+
+  err := ts.RemoveSubscription(
+      &tribproto.SubscriptionArgs{"alice", "carol"}, &sub)
+  if err != nil || sub.Status != tribproto.OK {
+    t.Fatalf("RemoveSubscription: %d, %v", sub.Status, err)
+  }
+
+  err = ts.GetTribblesBySubscription(
+      &tribproto.GetTribblesArgs{"alice", 2, ""}, &reply)
+  if err != nil {
+    t.Fatal(err)
+  }
+  expectIds(t, "home of alice", idsOf(reply.Tribbles), b2, b1)
+  if reply.NextCursor != "" {
+    t.Errorf("cursor after the last followed tribble")
+  }
+}
+
+/**@brief pages older than a full home list come from the timelines of
+ *        the users followed, every tribble once
+ * @param t
+ * @return void
+ */
+func TestHomeOlderThanList(t *testing.T) {
+  var want, got []string
+
+  ts, store := newTestServer(t)
+  ts.HomeSize = 3
+  mustCreate(t, ts, "alice", "bob")
+  for i := 0; i < 6; i++ {
+    want = append([]string{mustPost(t, ts, "bob", "b")}, want...)
+  }
+  mustFollow(t, ts, "alice", "bob")
+  buildHomeOf(t, ts, store, "alice")
+
+  for _, page := range allPages(t, ts.GetTribblesBySubscription,
+      "alice", 2) {
+    got = append(got, page...)
+  }
+  expectIds(t, "home of alice", got, want...)
+}
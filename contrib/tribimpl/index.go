@@ -0,0 +1,105 @@
+/** @file index.go
+ *  @brief index lists: the <tag>:TAG, <userid>:M and <term>:W lists a
+ *         tribble is added to by its contents. Edits add tribbles to them
+ *         long after they were posted, so their items "<posted>:<id>"
+ *         carry the posting time and are put back in feed order when
+ *         read, and edits and deletes move a tribble between the lists of
+ *         its old and new contents.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "fmt"
+  "sort"
+  "strconv"
+  "strings"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief item of an index list for a tribble
+ * @param trib
+ * @return string
+ */
+func indexItem(trib tribproto.Tribble) string {
+  return fmt.Sprintf("%d:%s", trib.Posted.UnixNano(), trib.Id)
+}
+
+/**@brief split an item of an index list
+ * @param item
+ * @return int64 posting time
+ * @return string tribble id
+ * @return bool false if item is not an index item
+ */
+func splitIndexItem(item string) (int64, string, bool) {
+  posted, id, found := strings.Cut(item, ":")
+  if !found {
+    return 0, "", false
+  }
+
+  nanos, err := strconv.ParseInt(posted, 10, 64)
+  return nanos, id, err == nil
+}
+
+/**@brief tribble ids of the items of an index list, oldest first in feed
+ *        order like a :T list
+ * @param items
+ * @return []string
+ */
+func indexIds(items []string) []string {
+  var list []position
+  var ids []string
+
+  for _, item := range items {
+    if posted, id, ok := splitIndexItem(item); ok {
+      list = append(list, position{posted, authorOf(id), id})
+    }
+  }
+  sort.Slice(list, func(a, b int) bool {
+    return list[b].before(list[a])
+  })
+
+  ids = make([]string, len(list))
+  for i, p := range list {
+    ids[i] = p.Id
+  }
+
+  return ids
+}
+
+/**@brief move a tribble from the index lists of the words of its old
+ *        contents to those of its new ones, for edits and, with an empty
+ *        trib, deletes
+ * @param old
+ * @param trib
+ * @param before words of old
+ * @param now words of trib
+ * @param key index list of a word
+ * @return void
+ */
+func (ts *Tribserver) reindex(old, trib tribproto.Tribble,
+    before, now []string, key func(string) string) {
+  var inNow map[string]bool = make(map[string]bool)
+  var inBefore map[string]bool = make(map[string]bool)
+
+  for _, word := range now {
+    inNow[word] = true
+  }
+  for _, word := range before {
+    inBefore[word] = true
+    if !inNow[word] {
+      err := ts.Store.RemoveFromList(key(word), indexItem(old))
+      if err != nil && !notInList(err) {
+        lsplog.CheckReport(1, err)
+      }
+    }
+  }
+  for _, word := range now {
+    if !inBefore[word] {
+      err := ts.Store.AppendToList(key(word), indexItem(trib))
+      lsplog.CheckReport(1, err)
+    }
+  }
+}
@@ -0,0 +1,280 @@
+/** @file like.go
+ *  @brief likes. <id>:L lists the users liking the tribble id, and since
+ *         storage appends and removes list items one at a time the counts
+ *         stay right however many tribservers add likes at once.
+ *         <author>:LT lists the tribbles of author that were ever liked, so
+ *         a page needs one read per author and one per liked tribble to
+ *         count likes. <userid>:LK lists the tribbles a user likes, oldest
+ *         like first. Liking a retribble likes its original.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "fmt"
+  "P2-f12/contrib/libstore"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/storageproto"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief key of the list of users liking the tribble id
+ * @param id
+ * @return string
+ */
+func likesKey(id string) string {
+  return fmt.Sprintf("%s:L", id)
+}
+
+/**@brief key of the list of tribbles of author that were ever liked
+ * @param author
+ * @return string
+ */
+func likedTribsKey(author string) string {
+  return fmt.Sprintf("%s:LT", author)
+}
+
+/**@brief key of the list of tribbles userid likes
+ * @param userid
+ * @return string
+ */
+func likedKey(userid string) string {
+  return fmt.Sprintf("%s:LK", userid)
+}
+
+/**@brief the tribble a like of id is about, tombstones included so that
+ *        likes of deleted tribbles can still be taken back
+ * @param id
+ * @return entry
+ * @return error
+ */
+func (ts *Tribserver) likeTarget(id string) (entry, error) {
+  e, err := ts.getEntry(id)
+  if err == nil && isRetribble(e.trib) {
+    e, err = ts.getEntry(e.trib.RepostOf)
+  }
+  return e, err
+}
+
+/**@brief fill in Likes, reading the list of liked tribbles of every
+ *        author once and the likes list of every liked tribble once
+ * @param tribs
+ * @return void
+ */
+func (ts *Tribserver) countLikes(tribs []tribproto.Tribble) {
+  var liked map[string]map[string]bool = make(map[string]map[string]bool)
+  var counts map[string]int = make(map[string]int)
+
+  for i := range tribs {
+    id, author := tribs[i].Id, tribs[i].Userid
+    if isRetribble(tribs[i]) {
+      id, author = tribs[i].RepostOf, tribs[i].RepostAuthor
+    }
+
+    ids, read := liked[author]
+    if !read {
+      ids = make(map[string]bool)
+      //no list until the first like
+      items, _ := ts.Store.GetList(likedTribsKey(author))
+      for _, item := range items {
+        ids[item] = true
+      }
+      liked[author] = ids
+    }
+    if !ids[id] {
+      continue
+    }
+
+    count, read := counts[id]
+    if !read {
+      users, _ := ts.Store.GetList(likesKey(id))
+      count = len(users)
+      counts[id] = count
+    }
+
+    tribs[i].Likes = count
+  }
+}
+
+/**@brief args.Userid likes args.Id, liking again changes nothing
+ * @param LikeArgs
+ * @param LikeReply
+ * @return error
+ */
+func (ts *Tribserver) LikeTribble(
+    args *tribproto.LikeArgs, reply *tribproto.LikeReply) error {
+  var e entry
+  var err error
+
+  _, err = ts.Store.GetList(fmt.Sprintf("%s:T", args.Userid))
+  if lsplog.CheckReport(1, err) {
+    reply.Status = tribproto.ENOSUCHUSER
+    return nil
+  }
+
+  e, err = ts.likeTarget(args.Id)
+  if lsplog.CheckReport(1, err) || e.trib.Deleted {
+    reply.Status = tribproto.ENOSUCHTRIBBLE
+    return nil
+  }
+
+  //listed before the like so that pages never miss it
+  err = ts.Store.AppendToList(likedTribsKey(e.trib.Userid), e.id)
+  if err != nil && err != libstore.MakeErr("AppendToList()",
+      storageproto.EITEMEXISTS) {
+    return err
+  }
+
+  err = ts.Store.AppendToList(likesKey(e.id), args.Userid)
+  if err != nil && err != libstore.MakeErr("AppendToList()",
+      storageproto.EITEMEXISTS) {
+    return err
+  }
+
+  err = ts.Store.AppendToList(likedKey(args.Userid), e.id)
+  if err != nil && err != libstore.MakeErr("AppendToList()",
+      storageproto.EITEMEXISTS) {
+    return err
+  }
+
+  reply.Status = tribproto.OK
+
+  return nil
+}
+
+/**@brief args.Userid no longer likes args.Id, unliking again changes
+ *        nothing
+ * @param LikeArgs
+ * @param LikeReply
+ * @return error
+ */
+func (ts *Tribserver) UnlikeTribble(
+    args *tribproto.LikeArgs, reply *tribproto.LikeReply) error {
+  var e entry
+  var err error
+
+  _, err = ts.Store.GetList(fmt.Sprintf("%s:T", args.Userid))
+  if lsplog.CheckReport(1, err) {
+    reply.Status = tribproto.ENOSUCHUSER
+    return nil
+  }
+
+  e, err = ts.likeTarget(args.Id)
+  if lsplog.CheckReport(1, err) {
+    reply.Status = tribproto.ENOSUCHTRIBBLE
+    return nil
+  }
+
+  //a missing list is as good as a missing item
+  err = ts.Store.RemoveFromList(likesKey(e.id), args.Userid)
+  if err != nil && !notInList(err) {
+    return err
+  }
+
+  err = ts.Store.RemoveFromList(likedKey(args.Userid), e.id)
+  if err != nil && !notInList(err) {
+    return err
+  }
+
+  reply.Status = tribproto.OK
+
+  return nil
+}
+
+/**@brief whether a RemoveFromList error only says there was nothing to
+ *        remove
+ * @param err
+ * @return bool
+ */
+func notInList(err error) bool {
+  return err == libstore.MakeErr("RemoveFromList()",
+      storageproto.EITEMNOTFOUND) ||
+      err == libstore.MakeErr("RemoveFromList()",
+      storageproto.EKEYNOTFOUND) ||
+      err == libstore.MakeErr("RemoveFromList()",
+      storageproto.EWRONGSERVER)
+}
+
+/**@brief whether a GetList error only says there is no list yet
+ * @param err
+ * @return bool
+ */
+func noList(err error) bool {
+  return err == libstore.MakeErr("GetList()", storageproto.EKEYNOTFOUND)
+}
+
+/**@brief users liking a tribble
+ * @param GetLikesArgs
+ * @param GetLikesReply
+ * @return error
+ */
+func (ts *Tribserver) GetLikes(
+    args *tribproto.GetLikesArgs, reply *tribproto.GetLikesReply) error {
+  var e entry
+  var users []string
+  var err error
+
+  e, err = ts.likeTarget(args.Id)
+  if lsplog.CheckReport(1, err) || e.trib.Deleted {
+    reply.Status = tribproto.ENOSUCHTRIBBLE
+    return nil
+  }
+
+  //no list until the first like
+  users, _ = ts.Store.GetList(likesKey(e.id))
+
+  reply.Status = tribproto.OK
+  reply.Userids = append([]string{}, users...)
+
+  return nil
+}
+
+/**@brief tribbles args.Userid likes, a page of at most args.Limit after
+ *        args.Cursor, latest like first
+ * @param GetTribblesArgs
+ * @param GetTribblesReply
+ * @return error
+ */
+func (ts *Tribserver) GetLikedTribbles(
+    args *tribproto.GetTribblesArgs, reply *tribproto.GetTribblesReply) error {
+  var ids []string
+  var page []entry
+  var start, i int
+  var err error
+
+  _, err = ts.Store.GetList(fmt.Sprintf("%s:T", args.Userid))
+  if lsplog.CheckReport(1, err) {
+    reply.Status = tribproto.ENOSUCHUSER
+    reply.Tribbles = nil
+    return nil
+  }
+
+  //no list until the first like
+  ids, _ = ts.Store.GetList(likedKey(args.Userid))
+
+  start, err = listStart(ids, args.Cursor)
+  if err != nil {
+    return err
+  }
+
+  reply.Status = tribproto.OK
+
+  for i = start - 1; i >= 0 && len(page) < pageLimit(args.Limit); i-- {
+    e, err := ts.getEntry(ids[i])
+    if lsplog.CheckReport(1, err) {
+      return lsplog.MakeErr("Get Liked Tribbles Message Error")
+    }
+    if !e.trib.Deleted {
+      page = append(page, e)
+    }
+  }
+
+  reply.Tribbles = ts.renderPage(page)
+  reply.NextCursor = ""
+  if i >= 0 && len(page) > 0 {
+    reply.NextCursor = listCursorAt(ids, i + 1)
+  }
+
+  return nil
+}
@@ -0,0 +1,116 @@
+/** @file like_test.go
+ *  @brief tests of tribble ids and likes
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "reflect"
+  "sync"
+  "testing"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief tribservers sharing a store, posting at once or restarted, never
+ *        hand out the same id twice
+ * @param t
+ * @return void
+ */
+func TestTribbleIdsUnique(t *testing.T) {
+  var ids map[string]bool = make(map[string]bool)
+  var lock sync.Mutex
+  var wg sync.WaitGroup
+
+  first, store := newTestServer(t)
+  second := NewTribserverWithStore(store)
+  mustCreate(t, first, "alice", "bob")
+
+  for _, ts := range []*Tribserver{first, first, second, second} {
+    wg.Add(1)
+    go func(ts *Tribserver) {
+      defer wg.Done()
+      for i := 0; i < 20; i++ {
+        for _, userid := range []string{"alice", "bob"} {
+          id := mustPost(t, ts, userid, "hi")
+          lock.Lock()
+          if ids[id] {
+            t.Errorf("id %s handed out twice", id)
+          }
+          ids[id] = true
+          lock.Unlock()
+        }
+      }
+    }(ts)
+  }
+  wg.Wait()
+
+  //a restarted tribserver knows nothing of earlier posts
+  restarted := NewTribserverWithStore(store)
+  if id := mustPost(t, restarted, "alice", "again"); ids[id] {
+    t.Errorf("restarted tribserver reused id %s", id)
+  }
+}
+
+/**@brief likes are counted per tribble, liking twice counts once, likes
+ *        of a retribble go to its original, and unliking takes them back
+ * @param t
+ * @return void
+ */
+func TestLikes(t *testing.T) {
+  var like tribproto.LikeReply
+  var likes tribproto.GetLikesReply
+  var page tribproto.GetTribblesReply
+  var rt tribproto.PostTribbleReply
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob", "carol")
+  liked := mustPost(t, ts, "alice", "liked")
+  other := mustPost(t, ts, "alice", "other")
+
+  err := ts.Retribble(&tribproto.RetribbleArgs{"carol", liked}, &rt)
+  if err != nil || rt.Status != tribproto.OK {
+    t.Fatalf("Retribble: %d, %v", rt.Status, err)
+  }
+
+  for _, args := range []tribproto.LikeArgs{
+      {"bob", liked}, {"bob", liked}, {"carol", rt.Id}} {
+    err = ts.LikeTribble(&args, &like)
+    if err != nil || like.Status != tribproto.OK {
+      t.Fatalf("LikeTribble(%v): %d, %v", args, like.Status, err)
+    }
+  }
+
+  err = ts.GetLikes(&tribproto.GetLikesArgs{liked}, &likes)
+  if err != nil || !reflect.DeepEqual(likes.Userids,
+      []string{"bob", "carol"}) {
+    t.Errorf("GetLikes(liked) = %v, %v", likes.Userids, err)
+  }
+  err = ts.GetLikes(&tribproto.GetLikesArgs{other}, &likes)
+  if err != nil || len(likes.Userids) != 0 {
+    t.Errorf("GetLikes(other) = %v, %v", likes.Userids, err)
+  }
+
+  err = ts.GetTribbles(&tribproto.GetTribblesArgs{"alice", 0, ""}, &page)
+  if err != nil || len(page.Tribbles) != 2 ||
+      page.Tribbles[0].Likes != 0 || page.Tribbles[1].Likes != 2 {
+    t.Errorf("GetTribbles(alice) = %+v, %v", page.Tribbles, err)
+  }
+  err = ts.GetTribbles(&tribproto.GetTribblesArgs{"carol", 0, ""}, &page)
+  if err != nil || len(page.Tribbles) != 1 || page.Tribbles[0].Likes != 2 {
+    t.Errorf("GetTribbles(carol) = %+v, %v", page.Tribbles, err)
+  }
+
+  err = ts.UnlikeTribble(&tribproto.LikeArgs{"bob", liked}, &like)
+  if err != nil || like.Status != tribproto.OK {
+    t.Fatalf("UnlikeTribble: %d, %v", like.Status, err)
+  }
+  err = ts.GetLikedTribbles(&tribproto.GetTribblesArgs{"bob", 0, ""}, &page)
+  if err != nil || len(page.Tribbles) != 0 {
+    t.Errorf("GetLikedTribbles(bob) = %+v, %v", page.Tribbles, err)
+  }
+  err = ts.GetTribbles(&tribproto.GetTribblesArgs{"alice", 0, ""}, &page)
+  if err != nil || page.Tribbles[1].Likes != 1 {
+    t.Errorf("likes after unlike = %+v, %v", page.Tribbles, err)
+  }
+}
@@ -0,0 +1,119 @@
+/** @file mention.go
+ *  @brief @userid mentions. Posting a tribble appends an index item
+ *         "<posted>:<id>" to the <userid>:M list of every existing user it
+ *         mentions, and editing it moves it between the lists of the users
+ *         mentioned before and after. Mentions page the same way as a
+ *         timeline.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "fmt"
+  "regexp"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/tribproto"
+)
+
+const MENTION_MAX = 10 // users notified per tribble, later mentions are ignored
+
+// @ at the start or after a character that cannot be part of a userid,
+// so mail addresses are not mentions
+var mentionRegex = regexp.MustCompile(`(?:^|[^\pL\pN_@-])@([\pL\pN_-]+)`)
+
+/**@brief key of the list of tribbles mentioning userid
+ * @param userid
+ * @return string
+ */
+func mentionKey(userid string) string {
+  return fmt.Sprintf("%s:M", userid)
+}
+
+/**@brief userids mentioned in contents, each once, in order
+ * @param contents
+ * @return []string
+ */
+func mentions(contents string) []string {
+  var users []string
+  var seen map[string]bool = make(map[string]bool)
+
+  for _, m := range mentionRegex.FindAllStringSubmatch(contents, -1) {
+    if !seen[m[1]] && len(users) < MENTION_MAX {
+      seen[m[1]] = true
+      users = append(users, m[1])
+    }
+  }
+
+  return users
+}
+
+/**@brief add a new tribble to the mentions of the users it names, users
+ *        that do not exist are skipped
+ * @param trib
+ * @return void
+ */
+func (ts *Tribserver) indexMentions(trib tribproto.Tribble) {
+  ts.reindexMentions(tribproto.Tribble{}, trib)
+}
+
+/**@brief move a tribble from the mentions of the users old names to those
+ *        of the users trib names, for edits and, with an empty trib,
+ *        deletes. Newly named users that do not exist are skipped.
+ * @param old
+ * @param trib
+ * @return void
+ */
+func (ts *Tribserver) reindexMentions(old, trib tribproto.Tribble) {
+  var now []string
+
+  for _, userid := range mentions(trib.Contents) {
+    _, err := ts.Store.GetList(fmt.Sprintf("%s:T", userid))
+    if err == nil {
+      now = append(now, userid)
+    }
+  }
+
+  ts.reindex(old, trib, mentions(old.Contents), now, mentionKey)
+}
+
+/**@brief tribbles mentioning args.Userid, a page of at most args.Limit
+ *        starting after args.Cursor
+ * @param GetTribblesArgs
+ * @param GetTribblesReply
+ * @return error
+ */
+func (ts *Tribserver) GetMentions(
+    args *tribproto.GetTribblesArgs, reply *tribproto.GetTribblesReply) error {
+  var items []string
+  var pos *position
+  var page []entry
+  var more bool
+  var err error
+
+  pos, err = decodeCursor(args.Cursor)
+  if err != nil {
+    return err
+  }
+
+  _, err = ts.Store.GetList(fmt.Sprintf("%s:T", args.Userid))
+  if lsplog.CheckReport(1, err) {
+    reply.Status = tribproto.ENOSUCHUSER
+    reply.Tribbles = nil
+    return nil
+  }
+
+  //no list until the first mention
+  items, _ = ts.Store.GetList(mentionKey(args.Userid))
+
+  reply.Status = tribproto.OK
+
+  page, more, err = ts.userPage(indexIds(items), pos, pageLimit(args.Limit))
+  if lsplog.CheckReport(1, err) {
+    return lsplog.MakeErr("Get Mentions Message Error")
+  }
+
+  ts.fillPage(reply, page, more, pageLimit(args.Limit))
+
+  return nil
+}
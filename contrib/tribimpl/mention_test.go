@@ -0,0 +1,56 @@
+/** @file mention_test.go
+ *  @brief tests of @mentions
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "reflect"
+  "testing"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief mentions are found once each, and mail addresses are not
+ *        mentions
+ * @param t
+ * @return void
+ */
+func TestMentionsParse(t *testing.T) {
+  got := mentions("@bob hi @carol, mail bob@example.com or @bob again")
+  if !reflect.DeepEqual(got, []string{"bob", "carol"}) {
+    t.Errorf("mentions = %v", got)
+  }
+}
+
+/**@brief mentioned users that exist get the tribble in their mentions,
+ *        newest first and a page at a time
+ * @param t
+ * @return void
+ */
+func TestGetMentions(t *testing.T) {
+  var reply tribproto.GetTribblesReply
+  var want []string
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob")
+
+  err := ts.GetMentions(&tribproto.GetTribblesArgs{"nobody", 0, ""}, &reply)
+  if err != nil || reply.Status != tribproto.ENOSUCHUSER {
+    t.Errorf("mentions of an unknown user: %d, %v", reply.Status, err)
+  }
+  expectIds(t, "no mentions yet", mentionIds(t, ts, "bob"))
+
+  //the post goes through although nobody does not exist
+  for i := 0; i < 5; i++ {
+    want = append([]string{mustPost(t, ts, "alice", "hi @bob @nobody")},
+        want...)
+  }
+  mustPost(t, ts, "alice", "not a mention")
+
+  var got []string
+  for _, page := range allPages(t, ts.GetMentions, "bob", 2) {
+    got = append(got, page...)
+  }
+  expectIds(t, "mentions of bob", got, want...)
+}
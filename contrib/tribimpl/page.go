@@ -0,0 +1,429 @@
+/** @file page.go
+ *  @brief cursor pagination of tribble lists, newest first. A cursor names
+ *         the last tribble of a page, not an offset, so posting new
+ *         tribbles does not shift the following pages.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "container/heap"
+  "encoding/base64"
+  "encoding/json"
+  "sort"
+  "P2-f12/contrib/libstore"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/tribproto"
+)
+
+const (
+  PAGE_DEFAULT = 100  // page size when the client does not ask for one
+  PAGE_MAX = 1000     // larger limits are cut to this
+)
+
+/**
+ *  @brief a tribble with the storage id it was read from
+ */
+type entry struct {
+  trib tribproto.Tribble
+  id string
+}
+
+/**
+ *  @brief position of a tribble in feed order: newer Posted first, then by
+ *         user and id so that tribbles posted at the same time still have
+ *         a fixed order
+ */
+type position struct {
+  Posted int64
+  Userid string
+  Id string
+}
+
+func (e entry) position() position {
+  return position{e.trib.Posted.UnixNano(), e.trib.Userid, e.id}
+}
+
+/**@brief whether a comes before b in feed order
+ * @param a
+ * @param b
+ * @return bool
+ */
+func (a position) before(b position) bool {
+  if a.Posted != b.Posted {
+    return a.Posted > b.Posted
+  }
+  if a.Userid != b.Userid {
+    return a.Userid < b.Userid
+  }
+  return a.Id < b.Id
+}
+
+/**@brief opaque cursor holding v
+ * @param v
+ * @return string
+ */
+func encodeJSONCursor(v interface{}) string {
+  enc, _ := json.Marshal(v)
+  return base64.RawURLEncoding.EncodeToString(enc)
+}
+
+/**@brief read a cursor made by encodeJSONCursor into v
+ * @param cursor
+ * @param v
+ * @return error
+ */
+func decodeJSONCursor(cursor string, v interface{}) error {
+  enc, err := base64.RawURLEncoding.DecodeString(cursor)
+  if err == nil {
+    err = json.Unmarshal(enc, v)
+  }
+  if err != nil {
+    return lsplog.MakeErr("invalid cursor " + cursor)
+  }
+
+  return nil
+}
+
+/**
+ *  @brief where a page of a list read newest first ends: the last item and
+ *         its index, used if the item has left the list since
+ */
+type listCursor struct {
+  Id string
+  Index int
+}
+
+/**@brief where a page of a list read newest first starts
+ * @param ids oldest first
+ * @param cursor made by listCursorAt, "" for the newest
+ * @return int the page is ids[start-1], ids[start-2], ...
+ * @return error
+ */
+func listStart(ids []string, cursor string) (int, error) {
+  var cur listCursor
+
+  if cursor == "" {
+    return len(ids), nil
+  }
+  if err := decodeJSONCursor(cursor, &cur); err != nil {
+    return 0, err
+  }
+
+  for j := range ids {
+    if ids[j] == cur.Id {
+      return j, nil
+    }
+  }
+
+  if cur.Index < 0 {
+    return 0, nil
+  }
+  if cur.Index > len(ids) {
+    return len(ids), nil
+  }
+  return cur.Index, nil
+}
+
+/**@brief cursor of the page after one ending with ids[i]
+ * @param ids
+ * @param i
+ * @return string
+ */
+func listCursorAt(ids []string, i int) string {
+  return encodeJSONCursor(listCursor{ids[i], i})
+}
+
+/**@brief cursor pointing after e
+ * @param e
+ * @return string
+ */
+func encodeCursor(e entry) string {
+  return encodeJSONCursor(e.position())
+}
+
+/**@brief position a cursor points after, nil for "" which means the start
+ * @param cursor
+ * @return *position
+ * @return error
+ */
+func decodeCursor(cursor string) (*position, error) {
+  var pos position
+
+  if cursor == "" {
+    return nil, nil
+  }
+
+  if err := decodeJSONCursor(cursor, &pos); err != nil {
+    return nil, err
+  }
+
+  return &pos, nil
+}
+
+/**@brief page size for a requested limit
+ * @param limit
+ * @return int
+ */
+func pageLimit(limit int) int {
+  if limit <= 0 {
+    return PAGE_DEFAULT
+  }
+  if limit > PAGE_MAX {
+    return PAGE_MAX
+  }
+  return limit
+}
+
+/**@brief read one tribble by id, tombstones of deleted tribbles included
+ * @param id
+ * @return entry
+ * @return error
+ */
+func (ts *Tribserver) getEntry(id string) (entry, error) {
+  trib, err := libstore.GetJSON[tribproto.Tribble](ts.Store, id)
+  //tribbles stored before ids were kept in the tribble itself
+  trib.Id = id
+  return entry{trib, id}, err
+}
+
+/**@brief first index of a list, oldest first, from which on every
+ *        tribble satisfies in. Found by binary search reading only
+ *        O(log n) tribbles.
+ * @param ids
+ * @param in true for the suffix being searched for
+ * @return int
+ * @return error
+ */
+func (ts *Tribserver) searchList(ids []string,
+    in func(position) bool) (int, error) {
+  var start int
+  var err error
+
+  start = sort.Search(len(ids), func(i int) bool {
+    if err != nil {
+      return true
+    }
+    e, e_err := ts.getEntry(ids[i])
+    if e_err != nil {
+      err = e_err
+      return true
+    }
+    return in(e.position())
+  })
+
+  return start, err
+}
+
+/**@brief where a page after pos starts in a user's tribble list
+ * @param ids oldest first
+ * @param pos nil for the newest
+ * @return int the page is ids[start-1], ids[start-2], ...
+ * @return error
+ */
+func (ts *Tribserver) pageStart(ids []string, pos *position) (int, error) {
+  if pos == nil {
+    return len(ids), nil
+  }
+
+  //tribbles at or before pos in feed order form a suffix of ids
+  return ts.searchList(ids, func(p position) bool {
+    return !pos.before(p)
+  })
+}
+
+/**@brief up to limit tribbles of one user after pos, newest first
+ * @param ids the user's tribble list, oldest first
+ * @param pos nil for the newest
+ * @param limit
+ * @return []entry
+ * @return bool whether older tribbles remain
+ * @return error
+ */
+func (ts *Tribserver) userPage(ids []string, pos *position,
+    limit int) ([]entry, bool, error) {
+  var page []entry
+
+  start, err := ts.pageStart(ids, pos)
+  if err != nil {
+    return nil, false, err
+  }
+
+  for i := start - 1; i >= 0 && len(page) < limit; i-- {
+    e, err := ts.getEntry(ids[i])
+    if err != nil {
+      return nil, false, err
+    }
+    //deleted while we were reading the list
+    if !e.trib.Deleted {
+      page = append(page, e)
+    }
+    start = i
+  }
+
+  return page, start > 0, nil
+}
+
+/**
+ *  @brief the not yet used part of one user's list during a feed merge,
+ *         head is the newest tribble not on the page yet
+ */
+type stream struct {
+  ids []string
+  next int
+  head entry
+}
+
+/**
+ *  @brief streams ordered by their head, newest first
+ */
+type streamHeap []*stream
+
+func (h streamHeap) Len() int {
+  return len(h)
+}
+
+func (h streamHeap) Less(i, j int) bool {
+  return h[i].head.position().before(h[j].head.position())
+}
+
+func (h streamHeap) Swap(i, j int) {
+  h[i], h[j] = h[j], h[i]
+}
+
+func (h *streamHeap) Push(x interface{}) {
+  *h = append(*h, x.(*stream))
+}
+
+func (h *streamHeap) Pop() interface{} {
+  var old streamHeap = *h
+  var x *stream = old[len(old) - 1]
+
+  *h = old[:len(old) - 1]
+  return x
+}
+
+/**@brief advance a stream to its next tribble
+ * @param s
+ * @return bool false if the stream is used up
+ * @return error
+ */
+func (ts *Tribserver) advance(s *stream) (bool, error) {
+  var err error
+
+  if s.next < 0 {
+    return false, nil
+  }
+
+  s.head, err = ts.getEntry(s.ids[s.next])
+  s.next--
+
+  return true, err
+}
+
+/**@brief merge the lists of several users into one page after pos,
+ *        reading each tribble only when it is about to be used, so a page
+ *        costs about limit + len(lists) reads however many users there are
+ * @param lists tribble lists, oldest first
+ * @param pos nil for the newest
+ * @param limit
+ * @return []entry newest first
+ * @return bool whether older tribbles remain
+ * @return error
+ */
+func (ts *Tribserver) feedPage(lists [][]string, pos *position,
+    limit int) ([]entry, bool, error) {
+  var streams streamHeap
+  var page []entry
+
+  for _, ids := range lists {
+    start, err := ts.pageStart(ids, pos)
+    if err != nil {
+      return nil, false, err
+    }
+
+    s := &stream{ids: ids, next: start - 1}
+    ok, err := ts.advance(s)
+    if err != nil {
+      return nil, false, err
+    }
+    if ok {
+      streams = append(streams, s)
+    }
+  }
+  heap.Init(&streams)
+
+  for len(page) < limit && streams.Len() > 0 {
+    s := streams[0]
+    if !s.head.trib.Deleted {
+      page = append(page, s.head)
+    }
+
+    ok, err := ts.advance(s)
+    if err != nil {
+      return nil, false, err
+    }
+    if ok {
+      heap.Fix(&streams, 0)
+    } else {
+      heap.Pop(&streams)
+    }
+  }
+
+  return page, streams.Len() > 0, nil
+}
+
+/**@brief the tribbles of the first limit entries of a sorted page, with
+ *        reposts and likes filled in, and the cursor of the next one
+ * @param page
+ * @param more whether there is anything after page
+ * @param limit
+ * @return []tribproto.Tribble
+ * @return string "" if nothing follows
+ */
+func (ts *Tribserver) pageTribbles(page []entry, more bool,
+    limit int) ([]tribproto.Tribble, string) {
+  var next string
+
+  if len(page) > limit {
+    page = page[:limit]
+    more = true
+  }
+
+  //the cursor stays on the last entry even if render drops it
+  if more && len(page) > 0 {
+    next = encodeCursor(page[len(page) - 1])
+  }
+
+  return ts.renderPage(page), next
+}
+
+/**@brief the tribbles of a page with reposts and likes filled in
+ * @param page
+ * @return []tribproto.Tribble
+ */
+func (ts *Tribserver) renderPage(page []entry) []tribproto.Tribble {
+  var tribs []tribproto.Tribble = make([]tribproto.Tribble, 0, len(page))
+
+  for _, e := range page {
+    if trib, ok := ts.render(e.trib); ok {
+      tribs = append(tribs, trib)
+    }
+  }
+  ts.countLikes(tribs)
+
+  return tribs
+}
+
+/**@brief fill a reply with the first limit entries of a sorted page
+ * @param reply
+ * @param page newest first
+ * @param more whether there is anything after page
+ * @param limit
+ * @return void
+ */
+func (ts *Tribserver) fillPage(reply *tribproto.GetTribblesReply,
+    page []entry, more bool, limit int) {
+  reply.Tribbles, reply.NextCursor = ts.pageTribbles(page, more, limit)
+}
@@ -0,0 +1,106 @@
+/** @file page_test.go
+ *  @brief tests of the edges of cursor paging through timelines and feeds
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "testing"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief empty timelines and feeds have one empty page
+ * @param t
+ * @return void
+ */
+func TestPageEmpty(t *testing.T) {
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob")
+  mustFollow(t, ts, "alice", "bob")
+
+  for name, pages := range map[string][][]string{
+      "timeline": allPages(t, ts.GetTribbles, "alice", 3),
+      "feed of a follower": allPages(t, ts.GetTribblesBySubscription,
+          "alice", 3),
+      "feed of nobody followed": allPages(t, ts.GetTribblesBySubscription,
+          "bob", 3)} {
+    if len(pages) != 1 || len(pages[0]) != 0 {
+      t.Errorf("%s = %v", name, pages)
+    }
+  }
+}
+
+/**@brief a last page that is exactly full has no cursor after it
+ * @param t
+ * @return void
+ */
+func TestPageLastFull(t *testing.T) {
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob")
+  mustFollow(t, ts, "bob", "alice")
+  for i := 0; i < 6; i++ {
+    mustPost(t, ts, "alice", "a")
+  }
+
+  for name, pages := range map[string][][]string{
+      "timeline": allPages(t, ts.GetTribbles, "alice", 3),
+      "feed": allPages(t, ts.GetTribblesBySubscription, "bob", 3)} {
+    if len(pages) != 2 || len(pages[1]) != 3 {
+      t.Errorf("%s = %v", name, pages)
+    }
+  }
+}
+
+/**@brief a cursor stays good when its tribble is deleted or newer ones
+ *        are posted, and a cursor that is not one is refused
+ * @param t
+ * @return void
+ */
+func TestPageStaleCursor(t *testing.T) {
+  for _, feed := range []bool{false, true} {
+    var first, second tribproto.GetTribblesReply
+    var del tribproto.DeleteTribbleReply
+    var ids []string
+
+    ts, _ := newTestServer(t)
+    mustCreate(t, ts, "alice", "bob")
+    mustFollow(t, ts, "bob", "alice")
+    for i := 0; i < 5; i++ {
+      ids = append([]string{mustPost(t, ts, "alice", "a")}, ids...)
+    }
+
+    name, get, args := "timeline", ts.GetTribbles,
+        tribproto.GetTribblesArgs{"alice", 2, ""}
+    if feed {
+      name, get, args = "feed", ts.GetTribblesBySubscription,
+          tribproto.GetTribblesArgs{"bob", 2, ""}
+    }
+
+    if err := get(&args, &first); err != nil {
+      t.Fatal(err)
+    }
+    expectIds(t, name + " first page", idsOf(first.Tribbles),
+        ids[0], ids[1])
+
+    //the last tribble of the page goes, a new one comes
+    err := ts.DeleteTribble(
+        &tribproto.DeleteTribbleArgs{"alice", ids[1]}, &del)
+    if err != nil || del.Status != tribproto.OK {
+      t.Fatalf("DeleteTribble: %d, %v", del.Status, err)
+    }
+    mustPost(t, ts, "alice", "newer")
+
+    args.Cursor = first.NextCursor
+    if err = get(&args, &second); err != nil {
+      t.Fatal(err)
+    }
+    expectIds(t, name + " page after a stale cursor",
+        idsOf(second.Tribbles), ids[2], ids[3])
+
+    args.Cursor = "not a cursor"
+    if err = get(&args, &second); err == nil {
+      t.Errorf("%s: bad cursor accepted", name)
+    }
+  }
+}
@@ -0,0 +1,162 @@
+/** @file repost.go
+ *  @brief retribbles and quotes. Both are tribbles of the reposting user
+ *         that name the original in RepostOf, a retribble without contents
+ *         of its own and a quote with a comment. The original is read
+ *         again whenever a page is returned, so edits and deletes show.
+ *         <userid>:RT lists the originals a user retribbled, which keeps
+ *         a user from retribbling one twice.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "fmt"
+  "P2-f12/contrib/libstore"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/storageproto"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief key of the list of tribbles a user retribbled
+ * @param userid
+ * @return string
+ */
+func retribbleKey(userid string) string {
+  return fmt.Sprintf("%s:RT", userid)
+}
+
+/**@brief whether trib is a plain retribble
+ * @param trib
+ * @return bool
+ */
+func isRetribble(trib tribproto.Tribble) bool {
+  return trib.RepostOf != "" && trib.Contents == ""
+}
+
+/**@brief the tribble to repost when asked to repost id, a retribble
+ *        stands for its original
+ * @param id
+ * @return entry
+ * @return int tribproto status
+ */
+func (ts *Tribserver) original(id string) (entry, int) {
+  e, err := ts.getEntry(id)
+  if err == nil && !e.trib.Deleted && isRetribble(e.trib) {
+    e, err = ts.getEntry(e.trib.RepostOf)
+  }
+  if lsplog.CheckReport(1, err) || e.trib.Deleted {
+    return e, tribproto.ENOSUCHTRIBBLE
+  }
+
+  return e, tribproto.OK
+}
+
+/**@brief fill in the original of a repost as it is now
+ * @param trib
+ * @return tribproto.Tribble
+ * @return bool false for a retribble of a deleted tribble, which has
+ *         nothing left to show
+ */
+func (ts *Tribserver) render(
+    trib tribproto.Tribble) (tribproto.Tribble, bool) {
+  if trib.RepostOf == "" {
+    return trib, true
+  }
+
+  orig, err := ts.getEntry(trib.RepostOf)
+  if err != nil || orig.trib.Deleted {
+    trib.Reposted = ""
+    return trib, !isRetribble(trib)
+  }
+
+  trib.Reposted = orig.trib.Contents
+  return trib, true
+}
+
+/**@brief repost the tribble args.Id to the timeline of args.Userid, at
+ *        most once per user
+ * @param RetribbleArgs
+ * @param PostTribbleReply
+ * @return error
+ */
+func (ts *Tribserver) Retribble(
+    args *tribproto.RetribbleArgs, reply *tribproto.PostTribbleReply) error {
+  var orig entry
+  var trib tribproto.Tribble
+  var err error
+
+  _, err = ts.Store.GetList(fmt.Sprintf("%s:T", args.Userid))
+  if lsplog.CheckReport(1, err) {
+    reply.Status = tribproto.ENOSUCHUSER
+    return nil
+  }
+
+  orig, reply.Status = ts.original(args.Id)
+  if reply.Status != tribproto.OK {
+    return nil
+  }
+
+  //storage refuses the second append, even from another tribserver
+  err = ts.Store.AppendToList(retribbleKey(args.Userid), orig.id)
+  if err == libstore.MakeErr("AppendToList()", storageproto.EITEMEXISTS) {
+    reply.Status = tribproto.EEXISTS
+    return nil
+  }
+  if lsplog.CheckReport(1, err) {
+    return err
+  }
+
+  trib.Userid = args.Userid
+  trib.RepostOf = orig.id
+  trib.RepostAuthor = orig.trib.Userid
+
+  trib, reply.Status, err = ts.post(trib)
+  if reply.Status != tribproto.OK || err != nil {
+    //allow another try, unless the mark cannot be taken back
+    rmErr := ts.Store.RemoveFromList(retribbleKey(args.Userid), orig.id)
+    if rmErr != nil && !notInList(rmErr) && err == nil {
+      err = rmErr
+    }
+    return err
+  }
+
+  reply.Id = trib.Id
+
+  return nil
+}
+
+/**@brief post args.Contents as a comment on the tribble args.Id
+ * @param QuoteTribbleArgs
+ * @param PostTribbleReply
+ * @return error
+ */
+func (ts *Tribserver) QuoteTribble(
+    args *tribproto.QuoteTribbleArgs,
+    reply *tribproto.PostTribbleReply) error {
+  var orig entry
+  var trib tribproto.Tribble
+  var err error
+
+  //without a comment it would be a retribble
+  if args.Contents == "" {
+    return nil
+  }
+
+  orig, reply.Status = ts.original(args.Id)
+  if reply.Status != tribproto.OK {
+    return nil
+  }
+
+  trib.Userid = args.Userid
+  trib.Contents = args.Contents
+  trib.RepostOf = orig.id
+  trib.RepostAuthor = orig.trib.Userid
+
+  trib, reply.Status, err = ts.post(trib)
+  if reply.Status == tribproto.OK && err == nil {
+    reply.Id = trib.Id
+  }
+
+  return err
+}
@@ -0,0 +1,161 @@
+/** @file repost_test.go
+ *  @brief tests of retribbles and quotes
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "testing"
+  "P2-f12/contrib/libstore"
+  "P2-f12/official/storageproto"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief a user retribbles an original once, also through a retribble of
+ *        it, and followers see it with the contents of the original as
+ *        they are now
+ * @param t
+ * @return void
+ */
+func TestRetribble(t *testing.T) {
+  var rt, again tribproto.PostTribbleReply
+  var edit tribproto.EditTribbleReply
+  var feed tribproto.GetTribblesReply
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob", "carol", "dave")
+  mustFollow(t, ts, "dave", "carol")
+  orig := mustPost(t, ts, "alice", "original")
+
+  err := ts.Retribble(&tribproto.RetribbleArgs{"bob", orig}, &rt)
+  if err != nil || rt.Status != tribproto.OK {
+    t.Fatalf("Retribble: %d, %v", rt.Status, err)
+  }
+  err = ts.Retribble(&tribproto.RetribbleArgs{"bob", orig}, &again)
+  if err != nil || again.Status != tribproto.EEXISTS {
+    t.Errorf("second retribble: %d, %v", again.Status, err)
+  }
+  err = ts.Retribble(&tribproto.RetribbleArgs{"bob", "alice:404"}, &again)
+  if err != nil || again.Status != tribproto.ENOSUCHTRIBBLE {
+    t.Errorf("retribble of an unknown tribble: %d, %v", again.Status, err)
+  }
+
+  //a retribble of the retribble reposts the original
+  err = ts.Retribble(&tribproto.RetribbleArgs{"carol", rt.Id}, &rt)
+  if err != nil || rt.Status != tribproto.OK {
+    t.Fatalf("Retribble of a retribble: %d, %v", rt.Status, err)
+  }
+
+  err = ts.EditTribble(
+      &tribproto.EditTribbleArgs{"alice", orig, "edited"}, &edit)
+  if err != nil || edit.Status != tribproto.OK {
+    t.Fatalf("EditTribble: %d, %v", edit.Status, err)
+  }
+
+  err = ts.GetTribblesBySubscription(
+      &tribproto.GetTribblesArgs{"dave", 0, ""}, &feed)
+  if err != nil || len(feed.Tribbles) != 1 {
+    t.Fatalf("feed of dave = %+v, %v", feed.Tribbles, err)
+  }
+  got := feed.Tribbles[0]
+  if got.Id != rt.Id || got.Userid != "carol" || got.RepostOf != orig ||
+      got.RepostAuthor != "alice" || got.Reposted != "edited" {
+    t.Errorf("retribble in the feed = %+v", got)
+  }
+}
+
+/**@brief a failed retribble whose mark cannot be taken back reports it,
+ *        one whose mark is gone can be tried again
+ * @param t
+ * @return void
+ */
+func TestRetribbleCleanup(t *testing.T) {
+  var rt tribproto.PostTribbleReply
+
+  ts, store := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob")
+
+  //the timeline append fails, then so may the cleanup
+  for _, removeFails := range []bool{false, true} {
+    orig := mustPost(t, ts, "alice", "original")
+    store.SetHook(func(op, key string) error {
+      if (op == "AppendToList" && key == "bob:T") ||
+          (removeFails && op == "RemoveFromList" && key == "bob:RT") {
+        return libstore.MakeErr(op + "()", storageproto.EPUTFAILED)
+      }
+      return nil
+    })
+
+    rt = tribproto.PostTribbleReply{}
+    err := ts.Retribble(&tribproto.RetribbleArgs{"bob", orig}, &rt)
+    store.SetHook(nil)
+    if rt.Status == tribproto.OK && err == nil {
+      t.Fatalf("retribble posted although its timeline append failed")
+    }
+
+    if removeFails {
+      if err == nil {
+        t.Errorf("failed cleanup of the retribble mark not reported")
+      }
+      continue
+    }
+    if err != nil {
+      t.Errorf("cleanup reported %v", err)
+    }
+    err = ts.Retribble(&tribproto.RetribbleArgs{"bob", orig}, &rt)
+    if err != nil || rt.Status != tribproto.OK {
+      t.Errorf("retry after a cleanup: %d, %v", rt.Status, err)
+    }
+  }
+}
+
+/**@brief a quote keeps its comment when the original is deleted, a
+ *        retribble of it disappears
+ * @param t
+ * @return void
+ */
+func TestQuoteAfterDelete(t *testing.T) {
+  var quote, rt, empty tribproto.PostTribbleReply
+  var del tribproto.DeleteTribbleReply
+  var page tribproto.GetTribblesReply
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob")
+  orig := mustPost(t, ts, "alice", "original")
+
+  err := ts.QuoteTribble(
+      &tribproto.QuoteTribbleArgs{"bob", orig, "my comment"}, &quote)
+  if err != nil || quote.Status != tribproto.OK {
+    t.Fatalf("QuoteTribble: %d, %v", quote.Status, err)
+  }
+  err = ts.QuoteTribble(&tribproto.QuoteTribbleArgs{"bob", orig, ""}, &empty)
+  if err != nil || empty.Id != "" {
+    t.Errorf("quote without a comment stored as %q, %v", empty.Id, err)
+  }
+  err = ts.Retribble(&tribproto.RetribbleArgs{"bob", orig}, &rt)
+  if err != nil || rt.Status != tribproto.OK {
+    t.Fatalf("Retribble: %d, %v", rt.Status, err)
+  }
+
+  err = ts.GetTribbles(&tribproto.GetTribblesArgs{"bob", 0, ""}, &page)
+  if err != nil || len(page.Tribbles) != 2 ||
+      page.Tribbles[1].Reposted != "original" {
+    t.Fatalf("tribbles of bob = %+v, %v", page.Tribbles, err)
+  }
+
+  err = ts.DeleteTribble(&tribproto.DeleteTribbleArgs{"alice", orig}, &del)
+  if err != nil || del.Status != tribproto.OK {
+    t.Fatalf("DeleteTribble: %d, %v", del.Status, err)
+  }
+
+  err = ts.GetTribbles(&tribproto.GetTribblesArgs{"bob", 0, ""}, &page)
+  if err != nil {
+    t.Fatal(err)
+  }
+  expectIds(t, "tribbles of bob", idsOf(page.Tribbles), quote.Id)
+  if len(page.Tribbles) == 1 && (page.Tribbles[0].Contents != "my comment" ||
+      page.Tribbles[0].Reposted != "") {
+    t.Errorf("quote of a deleted tribble = %+v", page.Tribbles[0])
+  }
+}
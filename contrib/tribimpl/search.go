@@ -0,0 +1,283 @@
+/** @file search.go
+ *  @brief full text search. The contents of every tribble are split into
+ *         words, folded like tags, stemmed and stripped of stop words, and
+ *         for each remaining term an index item "<posted>:<id>" goes to
+ *         the <term>:W list. Since the items carry the posting time, results
+ *         can be ranked by the number of query terms they match and then
+ *         by recency from the term lists alone, and only the tribbles of
+ *         the returned page are read. Only the newest SEARCH_ITEMS_MAX
+ *         tribbles of a term take part, so common terms cost a bounded
+ *         amount of work and older tribbles with them are not found.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+  "unicode"
+  "unicode/utf8"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/tribproto"
+)
+
+const (
+  TERM_MAX_LEN = 40      // longer words are not indexed
+  SEARCH_TERMS_MAX = 8   // query terms used, later ones are ignored
+  SEARCH_ITEMS_MAX = 1000 // tribbles used per term, the newest ones
+)
+
+// too common to be worth a list
+var stopWords = map[string]bool {
+  "a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+  "be": true, "but": true, "by": true, "for": true, "i": true, "if": true,
+  "in": true, "is": true, "it": true, "of": true, "on": true, "or": true,
+  "so": true, "that": true, "the": true, "this": true, "to": true,
+  "was": true, "we": true, "with": true, "you": true,
+}
+
+/**
+ *  @brief a search result before its tribble is read, and the position of
+ *         the last one of a page in a cursor
+ */
+type hit struct {
+  Score int
+  Posted int64
+  Id string
+}
+
+/**@brief whether a ranks before b: more terms matched, then newer
+ * @param a
+ * @param b
+ * @return bool
+ */
+func (a hit) before(b hit) bool {
+  if a.Score != b.Score {
+    return a.Score > b.Score
+  }
+  if a.Posted != b.Posted {
+    return a.Posted > b.Posted
+  }
+  return a.Id < b.Id
+}
+
+/**@brief key of the list of tribbles containing a term
+ * @param term
+ * @return string
+ */
+func termKey(term string) string {
+  return fmt.Sprintf("%s:W", term)
+}
+
+/**@brief strip common English suffixes so that "posting", "posted" and
+ *        "posts" find each other
+ * @param word folded
+ * @return string
+ */
+func stem(word string) string {
+  var n int = len(word)
+
+  switch {
+  case n > 4 && strings.HasSuffix(word, "ies"):
+    word = word[:n - 3] + "y"
+  case n > 4 && strings.HasSuffix(word, "sses"):
+    word = word[:n - 2]
+  case n > 5 && strings.HasSuffix(word, "ing"):
+    word = undouble(word[:n - 3])
+  case n > 4 && strings.HasSuffix(word, "ed"):
+    word = undouble(word[:n - 2])
+  case n > 4 && strings.HasSuffix(word, "ly"):
+    word = word[:n - 2]
+  case n > 3 && strings.HasSuffix(word, "s") &&
+      !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") &&
+      !strings.HasSuffix(word, "is"):
+    word = word[:n - 1]
+  }
+
+  //"cache" as in "caching"
+  if len(word) > 3 && strings.HasSuffix(word, "e") {
+    word = word[:len(word) - 1]
+  }
+
+  return word
+}
+
+/**@brief "runn" to "run" after a suffix is stripped
+ * @param word
+ * @return string
+ */
+func undouble(word string) string {
+  var n int = len(word)
+
+  if n > 2 && word[n - 1] == word[n - 2] &&
+     !strings.ContainsRune("aeiouslz", rune(word[n - 1])) {
+    return word[:n - 1]
+  }
+  return word
+}
+
+/**@brief index terms of a text, each once, in order
+ * @param text
+ * @return []string
+ */
+func terms(text string) []string {
+  var list []string
+  var seen map[string]bool = make(map[string]bool)
+
+  words := strings.FieldsFunc(text, func(r rune) bool {
+    return !unicode.IsLetter(r) && !unicode.IsNumber(r) &&
+        !unicode.Is(unicode.Mn, r)
+  })
+
+  for _, word := range words {
+    term := fold(word)
+    if stopWords[term] || utf8.RuneCountInString(term) < 2 ||
+       len(term) > TERM_MAX_LEN {
+      continue
+    }
+    term = stem(term)
+    if !seen[term] {
+      seen[term] = true
+      list = append(list, term)
+    }
+  }
+
+  return list
+}
+
+/**@brief add a new tribble to the lists of its terms
+ * @param trib
+ * @return void
+ */
+func (ts *Tribserver) indexTerms(trib tribproto.Tribble) {
+  ts.reindexTerms(tribproto.Tribble{}, trib)
+}
+
+/**@brief move a tribble from the lists of the terms of old to those of
+ *        trib, for edits and, with an empty trib, deletes
+ * @param old
+ * @param trib
+ * @return void
+ */
+func (ts *Tribserver) reindexTerms(old, trib tribproto.Tribble) {
+  ts.reindex(old, trib, terms(old.Contents), terms(trib.Contents), termKey)
+}
+
+/**@brief the newest SEARCH_ITEMS_MAX tribbles containing a term, unscored
+ * @param term
+ * @return []hit
+ * @return error
+ */
+func (ts *Tribserver) termHits(term string) ([]hit, error) {
+  var found []hit
+
+  items, err := ts.Store.GetList(termKey(term))
+  //no list until the first tribble with the term
+  if noList(err) {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  for _, item := range items {
+    if posted, id, ok := splitIndexItem(item); ok {
+      found = append(found, hit{Posted: posted, Id: id})
+    }
+  }
+
+  if len(found) > SEARCH_ITEMS_MAX {
+    sort.Slice(found, func(a, b int) bool {
+      return found[a].before(found[b])
+    })
+    found = found[:SEARCH_ITEMS_MAX]
+  }
+
+  return found, nil
+}
+
+/**@brief tribbles whose contents match args.Query, best first: those
+ *        matching more of its words, then the newer ones. With args.All
+ *        only tribbles matching every word. A page of at most args.Limit
+ *        after args.Cursor.
+ * @param SearchArgs
+ * @param GetTribblesReply
+ * @return error
+ */
+func (ts *Tribserver) SearchTribbles(
+    args *tribproto.SearchArgs, reply *tribproto.GetTribblesReply) error {
+  var cur *hit
+  var query []string
+  var hits map[string]*hit = make(map[string]*hit)
+  var ranked []hit
+  var page []entry
+  var start, i int
+
+  if args.Cursor != "" {
+    cur = new(hit)
+    if err := decodeJSONCursor(args.Cursor, cur); err != nil {
+      return err
+    }
+  }
+
+  reply.Status = tribproto.OK
+  reply.Tribbles = []tribproto.Tribble{}
+
+  query = terms(args.Query)
+  if len(query) > SEARCH_TERMS_MAX {
+    query = query[:SEARCH_TERMS_MAX]
+  }
+  if len(query) == 0 {
+    return nil
+  }
+
+  for _, term := range query {
+    found, err := ts.termHits(term)
+    if lsplog.CheckReport(1, err) {
+      return lsplog.MakeErr("Search Tribbles Message Error")
+    }
+    for _, f := range found {
+      h, seen := hits[f.Id]
+      if !seen {
+        h = &hit{Posted: f.Posted, Id: f.Id}
+        hits[f.Id] = h
+      }
+      h.Score++
+    }
+  }
+
+  for _, h := range hits {
+    if !args.All || h.Score == len(query) {
+      ranked = append(ranked, *h)
+    }
+  }
+  sort.Slice(ranked, func(a, b int) bool {
+    return ranked[a].before(ranked[b])
+  })
+
+  if cur != nil {
+    start = sort.Search(len(ranked), func(j int) bool {
+      return cur.before(ranked[j])
+    })
+  }
+
+  for i = start; i < len(ranked) && len(page) < pageLimit(args.Limit); i++ {
+    e, err := ts.getEntry(ranked[i].Id)
+    if lsplog.CheckReport(1, err) {
+      return lsplog.MakeErr("Search Tribbles Message Error")
+    }
+    if !e.trib.Deleted {
+      page = append(page, e)
+    }
+  }
+
+  reply.Tribbles = ts.renderPage(page)
+  reply.NextCursor = ""
+  if i < len(ranked) && i > start {
+    reply.NextCursor = encodeJSONCursor(ranked[i - 1])
+  }
+
+  return nil
+}
@@ -0,0 +1,121 @@
+/** @file search_test.go
+ *  @brief tests of full text search
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "testing"
+  "P2-f12/contrib/fakestore"
+  "P2-f12/official/storageproto"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief tribbles matching more query terms rank first, and with All
+ *        only those matching every term are found
+ * @param t
+ * @return void
+ */
+func TestSearchAll(t *testing.T) {
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice")
+  both := mustPost(t, ts, "alice", "apples and pears")
+  apples := mustPost(t, ts, "alice", "only apples")
+  pears := mustPost(t, ts, "alice", "pears again")
+
+  expectIds(t, "any", searchIds(t, ts, "pears apples", false),
+      both, pears, apples)
+  expectIds(t, "all", searchIds(t, ts, "pears apples", true), both)
+  expectIds(t, "all with an unknown term",
+      searchIds(t, ts, "pears plums", true))
+  expectIds(t, "stop words only", searchIds(t, ts, "and the", false))
+}
+
+/**@brief search results page with cursors up to an empty last page
+ * @param t
+ * @return void
+ */
+func TestSearchPages(t *testing.T) {
+  var reply tribproto.GetTribblesReply
+  var got []string
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice")
+  var want []string
+  for i := 0; i < 5; i++ {
+    want = append([]string{mustPost(t, ts, "alice", "paged words")}, want...)
+  }
+
+  args := tribproto.SearchArgs{"words", false, 2, ""}
+  for pages := 0; ; pages++ {
+    err := ts.SearchTribbles(&args, &reply)
+    if err != nil || reply.Status != tribproto.OK || pages > 3 {
+      t.Fatalf("page %d: %d, %v", pages, reply.Status, err)
+    }
+    got = append(got, idsOf(reply.Tribbles)...)
+    if reply.NextCursor == "" {
+      break
+    }
+    args.Cursor = reply.NextCursor
+  }
+  expectIds(t, "pages", got, want...)
+
+  args.Cursor = "not a cursor"
+  if err := ts.SearchTribbles(&args, &reply); err == nil {
+    t.Errorf("bad cursor accepted")
+  }
+}
+
+/**@brief a term without a list finds nothing, other storage errors are
+ *        reported
+ * @param t
+ * @return void
+ */
+func TestSearchErrors(t *testing.T) {
+  var reply tribproto.GetTribblesReply
+
+  ts, store := newTestServer(t)
+  mustCreate(t, ts, "alice")
+  mustPost(t, ts, "alice", "broken words")
+
+  expectIds(t, "unknown term", searchIds(t, ts, "nowhere", false))
+
+  store.SetHook(fakestore.FailStatus("GetList", termKey("broken"),
+      storageproto.EWRONGSERVER))
+  err := ts.SearchTribbles(
+      &tribproto.SearchArgs{"broken words", false, 0, ""}, &reply)
+  if err == nil {
+    t.Errorf("failed term list read as empty: %v", idsOf(reply.Tribbles))
+  }
+}
+
+/**@brief only the newest SEARCH_ITEMS_MAX tribbles of a term are used
+ * @param t
+ * @return void
+ */
+func TestSearchItemsMax(t *testing.T) {
+  var reply tribproto.GetTribblesReply
+  var oldest, newest string
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice")
+  oldest = mustPost(t, ts, "alice", "common")
+  for i := 0; i < SEARCH_ITEMS_MAX; i++ {
+    newest = mustPost(t, ts, "alice", "common")
+  }
+
+  args := tribproto.SearchArgs{"common", false, SEARCH_ITEMS_MAX + 1, ""}
+  err := ts.SearchTribbles(&args, &reply)
+  if err != nil || len(reply.Tribbles) != SEARCH_ITEMS_MAX {
+    t.Fatalf("found %d, %v", len(reply.Tribbles), err)
+  }
+  if reply.Tribbles[0].Id != newest {
+    t.Errorf("newest tribble not first")
+  }
+  for _, trib := range reply.Tribbles {
+    if trib.Id == oldest {
+      t.Errorf("tribble beyond SEARCH_ITEMS_MAX found")
+    }
+  }
+}
@@ -0,0 +1,146 @@
+/** @file thread.go
+ *  @brief replies and conversations. A reply is an ordinary tribble of its
+ *         author that also names the tribble it answers and the one that
+ *         started the conversation, the root. Every reply of a
+ *         conversation is appended to the <root>:R list, oldest first.
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "fmt"
+  "P2-f12/official/lsplog"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief key of the list of replies in a conversation
+ * @param root id of the tribble that started it
+ * @return string
+ */
+func threadKey(root string) string {
+  return fmt.Sprintf("%s:R", root)
+}
+
+/**@brief id of the tribble that started the conversation of trib
+ * @param trib
+ * @return string
+ */
+func rootOf(trib tribproto.Tribble) string {
+  if trib.Thread != "" {
+    return trib.Thread
+  }
+  return trib.Id
+}
+
+/**@brief post a reply to the tribble args.ReplyTo
+ * @param PostReplyArgs
+ * @param PostTribbleReply
+ * @return error
+ */
+func (ts *Tribserver) PostReply(
+    args *tribproto.PostReplyArgs, reply *tribproto.PostTribbleReply) error {
+  var parent entry
+  var trib tribproto.Tribble
+  var err error
+
+  //do not allow empty post
+  if args.Contents == "" {
+    return nil
+  }
+
+  parent, err = ts.getEntry(args.ReplyTo)
+  if lsplog.CheckReport(1, err) || parent.trib.Deleted {
+    reply.Status = tribproto.ENOSUCHTRIBBLE
+    return nil
+  }
+
+  trib.Userid = args.Userid
+  trib.Contents = args.Contents
+  trib.ReplyTo = args.ReplyTo
+  trib.Thread = rootOf(parent.trib)
+
+  //post lists the reply in its conversation before its timeline
+  trib, reply.Status, err = ts.post(trib)
+  if reply.Status != tribproto.OK || err != nil {
+    return err
+  }
+
+  reply.Id = trib.Id
+
+  return nil
+}
+
+/**@brief the conversation args.Id belongs to: its root and a page of at
+ *        most args.Limit replies after args.Cursor, oldest first
+ * @param GetThreadArgs
+ * @param GetThreadReply
+ * @return error
+ */
+func (ts *Tribserver) GetThread(
+    args *tribproto.GetThreadArgs, reply *tribproto.GetThreadReply) error {
+  var pos *position
+  var e, root entry
+  var ids []string
+  var page []entry
+  var start, i int
+  var err error
+
+  pos, err = decodeCursor(args.Cursor)
+  if err != nil {
+    return err
+  }
+
+  //a deleted root still holds its conversation together
+  e, err = ts.getEntry(args.Id)
+  if lsplog.CheckReport(1, err) {
+    reply.Status = tribproto.ENOSUCHTRIBBLE
+    return nil
+  }
+  root = e
+  if rootOf(e.trib) != args.Id {
+    root, err = ts.getEntry(rootOf(e.trib))
+    if lsplog.CheckReport(1, err) {
+      reply.Status = tribproto.ENOSUCHTRIBBLE
+      return nil
+    }
+  }
+
+  reply.Status = tribproto.OK
+  //the root is filled in like the replies
+  roots := make([]tribproto.Tribble, 1)
+  roots[0], _ = ts.render(root.trib)
+  ts.countLikes(roots)
+  reply.Root = roots[0]
+
+  //no list until the first reply
+  ids, err = ts.Store.GetList(threadKey(root.id))
+  if err != nil {
+    ids = nil
+  }
+
+  //replies newer than pos form a suffix of ids
+  if pos != nil {
+    start, err = ts.searchList(ids, func(p position) bool {
+      return p.before(*pos)
+    })
+    if lsplog.CheckReport(1, err) {
+      return lsplog.MakeErr("Get Thread Message Error")
+    }
+  }
+
+  for i = start; i < len(ids) && len(page) < pageLimit(args.Limit); i++ {
+    e, err = ts.getEntry(ids[i])
+    if lsplog.CheckReport(1, err) {
+      return lsplog.MakeErr("Get Thread Message Error")
+    }
+    if !e.trib.Deleted {
+      page = append(page, e)
+    }
+  }
+
+  reply.Replies, reply.NextCursor = ts.pageTribbles(page, i < len(ids),
+      pageLimit(args.Limit))
+
+  return nil
+}
@@ -0,0 +1,176 @@
+/** @file thread_test.go
+ *  @brief tests of replies and conversation threads
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "testing"
+  "P2-f12/contrib/fakestore"
+  "P2-f12/official/storageproto"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief post a reply, failing the test on any error
+ * @param t
+ * @param ts
+ * @param userid
+ * @param replyTo
+ * @return string id of the reply
+ */
+func mustReply(t *testing.T, ts *Tribserver, userid, replyTo string) string {
+  t.Helper()
+  var reply tribproto.PostTribbleReply
+  err := ts.PostReply(
+      &tribproto.PostReplyArgs{userid, replyTo, "re"}, &reply)
+  if err != nil || reply.Status != tribproto.OK {
+    t.Fatalf("PostReply(%s): %d, %v", replyTo, reply.Status, err)
+  }
+  return reply.Id
+}
+
+/**@brief every page of replies of the thread of id
+ * @param t
+ * @param ts
+ * @param id
+ * @param limit
+ * @return string root id
+ * @return [][]string reply ids per page
+ */
+func threadPages(t *testing.T, ts *Tribserver, id string,
+    limit int) (string, [][]string) {
+  t.Helper()
+  var pages [][]string
+  var root string
+
+  args := tribproto.GetThreadArgs{id, limit, ""}
+  for {
+    var reply tribproto.GetThreadReply
+    err := ts.GetThread(&args, &reply)
+    if err != nil || reply.Status != tribproto.OK || len(pages) > 100 {
+      t.Fatalf("page %d of thread %s: %d, %v", len(pages), id,
+          reply.Status, err)
+    }
+    root = reply.Root.Id
+    pages = append(pages, idsOf(reply.Replies))
+    if reply.NextCursor == "" {
+      return root, pages
+    }
+    args.Cursor = reply.NextCursor
+  }
+}
+
+/**@brief replies to replies join the conversation of the root, which
+ *        any of its tribbles leads to, oldest reply first
+ * @param t
+ * @return void
+ */
+func TestThread(t *testing.T) {
+  var reply tribproto.PostTribbleReply
+  var thread tribproto.GetThreadReply
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob")
+  root := mustPost(t, ts, "alice", "root")
+
+  id, pages := threadPages(t, ts, root, 2)
+  if id != root || len(pages) != 1 || len(pages[0]) != 0 {
+    t.Errorf("thread without replies = %s, %v", id, pages)
+  }
+
+  first := mustReply(t, ts, "bob", root)
+  second := mustReply(t, ts, "alice", first)
+  third := mustReply(t, ts, "bob", second)
+  fourth := mustReply(t, ts, "bob", root)
+
+  for _, from := range []string{root, third} {
+    id, pages = threadPages(t, ts, from, 2)
+    if id != root || len(pages) != 2 {
+      t.Errorf("thread from %s = %s, %v", from, id, pages)
+      continue
+    }
+    expectIds(t, "replies", append(pages[0], pages[1]...),
+        first, second, third, fourth)
+  }
+
+  err := ts.GetThread(&tribproto.GetThreadArgs{"alice:404", 0, ""}, &thread)
+  if err != nil || thread.Status != tribproto.ENOSUCHTRIBBLE {
+    t.Errorf("thread of an unknown tribble: %d, %v", thread.Status, err)
+  }
+  err = ts.PostReply(
+      &tribproto.PostReplyArgs{"bob", "alice:404", "re"}, &reply)
+  if err != nil || reply.Status != tribproto.ENOSUCHTRIBBLE {
+    t.Errorf("reply to an unknown tribble: %d, %v", reply.Status, err)
+  }
+}
+
+/**@brief a thread cursor stays good when its reply is deleted, and later
+ *        replies show up on the pages after it
+ * @param t
+ * @return void
+ */
+func TestThreadStaleCursor(t *testing.T) {
+  var page tribproto.GetThreadReply
+  var del tribproto.DeleteTribbleReply
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob")
+  root := mustPost(t, ts, "alice", "root")
+  first := mustReply(t, ts, "bob", root)
+  second := mustReply(t, ts, "bob", root)
+  third := mustReply(t, ts, "bob", root)
+
+  args := tribproto.GetThreadArgs{root, 2, ""}
+  err := ts.GetThread(&args, &page)
+  if err != nil {
+    t.Fatal(err)
+  }
+  expectIds(t, "first page", idsOf(page.Replies), first, second)
+
+  err = ts.DeleteTribble(&tribproto.DeleteTribbleArgs{"bob", second}, &del)
+  if err != nil || del.Status != tribproto.OK {
+    t.Fatalf("DeleteTribble: %d, %v", del.Status, err)
+  }
+  fourth := mustReply(t, ts, "bob", root)
+
+  args.Cursor = page.NextCursor
+  err = ts.GetThread(&args, &page)
+  if err != nil {
+    t.Fatal(err)
+  }
+  expectIds(t, "page after a stale cursor", idsOf(page.Replies),
+      third, fourth)
+  if page.NextCursor != "" {
+    t.Errorf("cursor after the last reply")
+  }
+}
+
+/**@brief a reply that could not join its conversation does not show up in
+ *        the timeline of its author either
+ * @param t
+ * @return void
+ */
+func TestPostReplyThreadFails(t *testing.T) {
+  var reply tribproto.PostTribbleReply
+
+  ts, store := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob")
+  root := mustPost(t, ts, "alice", "root")
+
+  store.SetHook(fakestore.FailStatus("AppendToList", threadKey(root),
+      storageproto.EPUTFAILED))
+  err := ts.PostReply(&tribproto.PostReplyArgs{"bob", root, "re"}, &reply)
+  if err == nil && reply.Status == tribproto.OK {
+    t.Errorf("reply posted outside its conversation")
+  }
+  store.SetHook(nil)
+
+  ids, err := store.GetList("bob:T")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(ids) != 0 {
+    t.Errorf("timeline list of bob = %v, want none", ids)
+  }
+}
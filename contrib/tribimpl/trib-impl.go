@@ -8,17 +8,30 @@ package tribimpl
 import (
   "encoding/json"
   "fmt"
-  "sort"
+  "net"
+  "net/http"
   "time"
   "strconv"
+  "strings"
+  "sync"
 	"P2-f12/official/tribproto"
   "P2-f12/contrib/libstore"
   "P2-f12/official/lsplog"
+  "P2-f12/official/storageproto"
 )
 
 type Tribserver struct {
-  Store *libstore.Libstore
-  Id int
+  Store libstore.KVStore
+  HomeSize int   // tribbles kept per home timeline, 0 merges feeds on read
+
+  lib *libstore.Libstore  // under Store if opened here, closed with it
+
+  seqLock sync.Mutex
+  seqs map[string]int  // last tribble number taken per user
+
+  homeLock sync.Mutex
+  homeJobs map[string]bool  // users whose home list is being backfilled
+  homePushes map[string]int // items pushed per home list since its last cut
 }
 
 /**@brief create a new tribserver   
@@ -27,26 +40,111 @@ type Tribserver struct {
  * @return *tribserver 
  */
 func NewTribserver(storagemaster, myhostport string) *Tribserver {
+  // libstore.NONE forces no leases on Get and GetList requests
+  return NewTribserverFlags(storagemaster, myhostport, libstore.NONE)
+}
+
+/**@brief create a new tribserver with libstore flags, e.g.
+ *        libstore.BINARY_TRANSPORT when storage speaks the binary codec
+ * @param string
+ * @param string
+ * @param int
+ * @return *tribserver
+ */
+func NewTribserverFlags(storagemaster, myhostport string,
+    flags int) *Tribserver {
+  return NewTribserverHome(storagemaster, myhostport, flags, 0)
+}
+
+/**@brief create a new tribserver with libstore flags that pushes tribbles
+ *        to home timelines of homeSize tribbles, 0 merges feeds on read
+ * @param string
+ * @param string
+ * @param int
+ * @param int
+ * @return *tribserver nil if storage cannot be reached
+ */
+func NewTribserverHome(storagemaster, myhostport string,
+    flags, homeSize int) *Tribserver {
   lsplog.SetVerbose(3)
   fmt.Printf("st_master:%s, port:%s\n", storagemaster, myhostport)
 
-  var svr *Tribserver = new(Tribserver)
+  var store *libstore.Libstore
+  var host string
   var err error
 
+  //libstore listens for revocations itself, give it a port of its own
+  host, _, err = net.SplitHostPort(myhostport)
+  if lsplog.CheckReport(1, err) {
+    return nil
+  }
+
   lsplog.Vlogf(3, "try to create libstore")
-  // libstore.NONE forces no leases on Get and GetList requests
-  svr.Store, err =
-      libstore.NewLibstore(storagemaster, myhostport, libstore.NONE)
+  store, err = libstore.NewLibstore(
+      storagemaster, net.JoinHostPort(host, "0"), flags)
 
   if lsplog.CheckReport(1, err) {
     return nil
   }
 
-  svr.Id = 1
+  //read back our own writes even before their revocations arrive
+  svr := NewTribserverWithStore(store.NewSession())
+  svr.lib = store
+  svr.HomeSize = homeSize
 
   return svr
 }
 
+/**@brief create a tribserver on top of any key-value store, e.g. the
+ *        in-memory fakestore for tests
+ * @param libstore.KVStore
+ * @return *tribserver
+ */
+func NewTribserverWithStore(store libstore.KVStore) *Tribserver {
+  var svr *Tribserver = new(Tribserver)
+
+  svr.Store = store
+  svr.seqs = make(map[string]int)
+  svr.homeJobs = make(map[string]bool)
+  svr.homePushes = make(map[string]int)
+
+  return svr
+}
+
+/**@brief release the libstore of this tribserver
+ * @param void
+ * @return error
+ */
+func (ts *Tribserver) Close() error {
+  err := ts.Store.Close()
+  if ts.lib != nil {
+    if lerr := ts.lib.Close(); err == nil {
+      err = lerr
+    }
+  }
+  return err
+}
+
+/**@brief http handler reporting the libstore cache statistics as JSON
+ * @param void
+ * @return http.HandlerFunc
+ */
+func (ts *Tribserver) StatsHandler() http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    var enc []byte
+    var err error
+
+    enc, err = json.Marshal(ts.Store.Stats())
+    if lsplog.CheckReport(1, err) {
+      http.Error(w, err.Error(), http.StatusInternalServerError)
+      return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(enc)
+  }
+}
+
 /**@brief create a new user 
  * @param CreateUserArgs
  * @param CreateUserReply
@@ -123,6 +221,10 @@ func (ts *Tribserver) AddSubscription(
     reply.Status = tribproto.EEXISTS
   } else {
     reply.Status = tribproto.OK
+    ts.addFollower(args.Targetuser, args.Userid)
+    if ts.HomeSize > 0 {
+      go ts.backfillHome(args.Userid, []string{args.Targetuser}, false)
+    }
   }
 
 	return nil
@@ -163,6 +265,7 @@ func (ts *Tribserver) RemoveSubscription(
     reply.Status = tribproto.ENOSUCHTARGETUSER
   } else {
     reply.Status = tribproto.OK
+    ts.removeFollower(args.Targetuser, args.Userid)
   }
 
 	return nil
@@ -194,59 +297,145 @@ func (ts *Tribserver) GetSubscriptions(
 	return nil
 }
 
-/**@brief Post Tribbles
- * @param PostTribbleArgs
- * @param PostTribbleReply
- * @return error 
+/**@brief key of the list of tribble numbers taken by userid, it only
+ *        grows so that the numbers of deleted tribbles are not taken again
+ * @param userid
+ * @return string
  */
-func (ts *Tribserver) PostTribble(
-    args *tribproto.PostTribbleArgs, reply *tribproto.PostTribbleReply) error {
-  var trib_key string
-  var trib tribproto.Tribble
-  var enc []byte
-  var err error
+func seqKey(userid string) string {
+  return fmt.Sprintf("%s:N", userid)
+}
 
-  //do not allow empty post
-  if args.Contents == "" {
-    return nil
+/**@brief take an id "<userid>:<n>" for a new tribble of userid. n is
+ *        taken by appending it to seqKey(userid), which fails if another
+ *        tribserver, or this one before a restart, took it first, so ids
+ *        are unique across the cluster
+ * @param userid
+ * @return string
+ * @return error
+ */
+func (ts *Tribserver) newId(userid string) (string, error) {
+  ts.seqLock.Lock()
+  defer ts.seqLock.Unlock()
+
+  n, known := ts.seqs[userid]
+  for {
+    if !known {
+      //no list until the first tribble, the numbers are 1 to len(taken)
+      taken, _ := ts.Store.GetList(seqKey(userid))
+      if len(taken) > n {
+        n = len(taken)
+      }
+      known = true
+    }
+
+    err := ts.Store.AppendToList(seqKey(userid), strconv.Itoa(n + 1))
+    if err == nil {
+      n++
+      ts.seqs[userid] = n
+      return fmt.Sprintf("%s:%d", userid, n), nil
+    }
+    if err != libstore.MakeErr("AppendToList()", storageproto.EITEMEXISTS) {
+      return "", err
+    }
+
+    //taken elsewhere, catch up
+    known = false
+    n++
   }
+}
 
-  trib_key = fmt.Sprintf("%s:T", args.Userid)
+/**@brief author of the tribble id, userids may hold ':' but numbers do not
+ * @param id
+ * @return string
+ */
+func authorOf(id string) string {
+  if i := strings.LastIndex(id, ":"); i >= 0 {
+    return id[:i]
+  }
+  return ""
+}
+
+/**@brief store a new tribble of trib.Userid and add it to the user's
+ *        timeline, Posted and Id are filled in here
+ * @param trib
+ * @return tribproto.Tribble as stored
+ * @return int tribproto status
+ * @return error
+ */
+func (ts *Tribserver) post(
+    trib tribproto.Tribble) (tribproto.Tribble, int, error) {
+  var trib_key string
+  var err error
+
+  trib_key = fmt.Sprintf("%s:T", trib.Userid)
   _, err = ts.Store.GetList(trib_key)
   if lsplog.CheckReport(1, err) {
-    reply.Status = tribproto.ENOSUCHUSER
-    return nil
+    return trib, tribproto.ENOSUCHUSER, nil
   }
 
-  err = ts.Store.AppendToList(trib_key, strconv.Itoa(ts.Id))
+  trib.Id, err = ts.newId(trib.Userid)
   if lsplog.CheckReport(1, err) {
-    reply.Status = tribproto.EEXISTS
-    return nil
+    return trib, tribproto.OK, err
   }
 
-  trib.Userid = args.Userid
+  //store the tribble first, so no timeline ever lists a missing entry
   trib.Posted = time.Now()
-  trib.Contents = args.Contents
 
-  enc, err = json.Marshal(trib)
+  err = libstore.PutJSON(ts.Store, trib.Id, trib)
   if lsplog.CheckReport(1, err) {
-    reply.Status = tribproto.OK
-    return err
+    return trib, tribproto.OK, err
+  }
+
+  //a reply joins its conversation before anyone can see it
+  if trib.Thread != "" {
+    err = ts.Store.AppendToList(threadKey(trib.Thread), trib.Id)
+    if lsplog.CheckReport(1, err) {
+      return trib, tribproto.OK, err
+    }
   }
 
-  err = ts.Store.Put(strconv.Itoa(ts.Id), string(enc))
+  err = ts.Store.AppendToList(trib_key, trib.Id)
   if lsplog.CheckReport(1, err) {
-    reply.Status = tribproto.OK
-    return err
+    return trib, tribproto.EEXISTS, nil
   }
 
-  reply.Status = tribproto.OK
-  ts.Id++
+  ts.indexMentions(trib)
+  ts.indexHashtags(trib)
+  ts.indexTerms(trib)
+  ts.pushHome(trib)
 
-	return nil
+  return trib, tribproto.OK, nil
+}
+
+/**@brief Post Tribbles
+ * @param PostTribbleArgs
+ * @param PostTribbleReply
+ * @return error 
+ */
+func (ts *Tribserver) PostTribble(
+    args *tribproto.PostTribbleArgs, reply *tribproto.PostTribbleReply) error {
+  var trib tribproto.Tribble
+  var err error
+
+  //do not allow empty post
+  if args.Contents == "" {
+    return nil
+  }
+
+  trib.Userid = args.Userid
+  trib.Contents = args.Contents
+
+  trib, reply.Status, err = ts.post(trib)
+  if reply.Status == tribproto.OK && err == nil {
+    reply.Id = trib.Id
+  }
+
+	return err
 }
 
-/**@brief get posted tribbles 
+/**@brief get posted tribbles, a page of at most args.Limit starting after
+ *        args.Cursor
  * @param GetTribblesArgs
  * @param GetTribblesReply
  * @return error 
@@ -255,9 +444,15 @@ func (ts *Tribserver) GetTribbles(
     args *tribproto.GetTribblesArgs, reply *tribproto.GetTribblesReply) error {
   var trib_key string
   var trib_ids []string
-  var trib_enc string
+  var pos *position
+  var page []entry
+  var more bool
   var err error
-  var length int
+
+  pos, err = decodeCursor(args.Cursor)
+  if err != nil {
+    return err
+  }
 
   trib_key = fmt.Sprintf("%s:T", args.Userid)
 
@@ -269,54 +464,57 @@ func (ts *Tribserver) GetTribbles(
   }
 
   reply.Status = tribproto.OK
-  if len(trib_ids) > 100 {
-    length = 100
-  } else {
-    length = len(trib_ids)
-  }
 
-  reply.Tribbles = make([]tribproto.Tribble, length)
-
-  for i := 0; i < length; i++ {
-    trib_enc, err = ts.Store.Get(trib_ids[len(trib_ids) - 1 - i])
-    if lsplog.CheckReport(1, err) {
-      return lsplog.MakeErr("Get Tribbles Message Error")
-    }
-    //fmt.Printf("unmarshal string %s\n", trib_enc)
-    _ = json.Unmarshal([]byte(trib_enc), &(reply.Tribbles[i]))
+  page, more, err = ts.userPage(trib_ids, pos, pageLimit(args.Limit))
+  if lsplog.CheckReport(1, err) {
+    return lsplog.MakeErr("Get Tribbles Message Error")
   }
 
+  ts.fillPage(reply, page, more, pageLimit(args.Limit))
+
 	return nil
 }
 
-type Tribs [](tribproto.Tribble)
+/**@brief the tribble lists of the users followed, users whose list cannot
+ *        be read are left out
+ * @param fllw_ids
+ * @return [][]string
+ */
+func (ts *Tribserver) followedLists(fllw_ids []string) [][]string {
+  var lists [][]string
 
-func (tribs Tribs) Len() int {
-  return len(tribs)
-}
+  for i := 0; i < len(fllw_ids); i++ {
+    lsplog.Vlogf(3, "try geting subscription user %d", i + 1)
 
-func (tribs Tribs) Less(i, j int) bool {
-  return tribs[i].Posted.After(tribs[j].Posted)
-}
+    trib_ids, err := ts.Store.GetList(fmt.Sprintf("%s:T", fllw_ids[i]))
+    if lsplog.CheckReport(1, err) {
+      continue
+    }
+    lists = append(lists, trib_ids)
+  }
 
-func (tribs Tribs) Swap(i, j int) {
-  tribs[j], tribs[i] = tribs[i], tribs[j]
+  return lists
 }
 
-//TODO: trim the list by sorting and taking only the most recent 100 tribbles
-/**@brief collect all tribbles from all users followed
- * @param CreateUserArgs
- * @param CreateUserReply
+/**@brief collect tribbles from all users followed, a page of at most
+ *        args.Limit starting after args.Cursor
+ * @param GetTribblesArgs
+ * @param GetTribblesReply
  * @return error 
  */
 func (ts *Tribserver) GetTribblesBySubscription(
     args *tribproto.GetTribblesArgs, reply *tribproto.GetTribblesReply) error {
   var fllw_key string
   var fllw_ids []string
+  var pos *position
+  var page []entry
+  var more, ready bool
   var err error
-  var getArgs tribproto.GetTribblesArgs
-  var getReply tribproto.GetTribblesReply
-  var tribs Tribs
+
+  pos, err = decodeCursor(args.Cursor)
+  if err != nil {
+    return err
+  }
 
   fllw_key = fmt.Sprintf("%s:F", args.Userid)
   fllw_ids, err = ts.Store.GetList(fllw_key)
@@ -326,34 +524,34 @@ func (ts *Tribserver) GetTribblesBySubscription(
     return nil
   }
 
-  fmt.Printf("complete geting list %d\n", len(fllw_ids))
+  lsplog.Vlogf(3, "complete geting list %d", len(fllw_ids))
 
   reply.Status = tribproto.OK
 
-  for i := 0; i < len(fllw_ids); i++ {
-    getArgs.Userid = fllw_ids[i]
-    err = ts.GetTribbles(&getArgs, &getReply)
-
-    fmt.Printf("try geting subscription user %d\n", i + 1)
-
+  if ts.HomeSize > 0 {
+    page, more, ready, err = ts.homePage(args.Userid, fllw_ids, pos,
+        pageLimit(args.Limit))
     if lsplog.CheckReport(1, err) {
-      reply.Status = tribproto.ENOSUCHTARGETUSER
-      reply.Tribbles = nil
+      return lsplog.MakeErr("Get Home Message Error")
+    }
+    if ready {
+      ts.fillPage(reply, page, more, pageLimit(args.Limit))
       return nil
     }
+  }
 
-    reply.Tribbles = append(reply.Tribbles, getReply.Tribbles...)
+  //merge in time order
+  page, more, err = ts.feedPage(ts.followedLists(fllw_ids), pos,
+      pageLimit(args.Limit))
+  if lsplog.CheckReport(1, err) {
+    reply.Status = tribproto.ENOSUCHTARGETUSER
+    reply.Tribbles = nil
+    return nil
   }
 
-  fmt.Printf("complete getting all subscribed tribs\n")
+  lsplog.Vlogf(3, "complete getting all subscribed tribs")
 
-  //to satisfy go compiler type check
-  tribs = reply.Tribbles
-  //sort in time order
-  sort.Sort(tribs)
+  ts.fillPage(reply, page, more, pageLimit(args.Limit))
 
-  if len(reply.Tribbles) > 100 {
-    reply.Tribbles = reply.Tribbles[:100]
-  }
 	return nil
 }
@@ -0,0 +1,236 @@
+/** @file trib-impl_test.go
+ *  @brief tests of users, posting, subscriptions and paging through
+ *         timelines on the in-memory fakestore
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "reflect"
+  "testing"
+  "P2-f12/contrib/fakestore"
+  "P2-f12/contrib/libstore"
+  "P2-f12/official/storageproto"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief users are created once
+ * @param t
+ * @return void
+ */
+func TestCreateUser(t *testing.T) {
+  var reply tribproto.CreateUserReply
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice")
+
+  err := ts.CreateUser(&tribproto.CreateUserArgs{"alice"}, &reply)
+  if err != nil || reply.Status != tribproto.EEXISTS {
+    t.Errorf("second CreateUser: %d, %v", reply.Status, err)
+  }
+}
+
+/**@brief posted tribbles show up newest first in the timeline of their
+ *        author, unknown users and empty posts are refused
+ * @param t
+ * @return void
+ */
+func TestPostTribble(t *testing.T) {
+  var post tribproto.PostTribbleReply
+  var page tribproto.GetTribblesReply
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice")
+
+  err := ts.PostTribble(&tribproto.PostTribbleArgs{"nobody", "hi"}, &post)
+  if err != nil || post.Status != tribproto.ENOSUCHUSER {
+    t.Errorf("post by an unknown user: %d, %v", post.Status, err)
+  }
+
+  first := mustPost(t, ts, "alice", "first")
+  second := mustPost(t, ts, "alice", "second")
+
+  post = tribproto.PostTribbleReply{}
+  err = ts.PostTribble(&tribproto.PostTribbleArgs{"alice", ""}, &post)
+  if err != nil || post.Id != "" {
+    t.Errorf("empty post stored as %q, %v", post.Id, err)
+  }
+
+  err = ts.GetTribbles(&tribproto.GetTribblesArgs{"alice", 0, ""}, &page)
+  if err != nil || page.Status != tribproto.OK {
+    t.Fatalf("GetTribbles: %d, %v", page.Status, err)
+  }
+  expectIds(t, "tribbles of alice", idsOf(page.Tribbles), second, first)
+  if page.Tribbles[0].Userid != "alice" ||
+      page.Tribbles[0].Contents != "second" ||
+      page.Tribbles[0].Posted.IsZero() {
+    t.Errorf("stored tribble = %+v", page.Tribbles[0])
+  }
+
+  err = ts.GetTribbles(&tribproto.GetTribblesArgs{"nobody", 0, ""}, &page)
+  if err != nil || page.Status != tribproto.ENOSUCHUSER {
+    t.Errorf("tribbles of an unknown user: %d, %v", page.Status, err)
+  }
+}
+
+/**@brief storage errors while posting are reported, not swallowed
+ * @param t
+ * @return void
+ */
+func TestPostTribbleStorageError(t *testing.T) {
+  var post tribproto.PostTribbleReply
+
+  ts, store := newTestServer(t)
+  mustCreate(t, ts, "alice")
+  store.SetHook(fakestore.FailStatus("AppendToList", seqKey("alice"),
+      storageproto.EPUTFAILED))
+
+  err := ts.PostTribble(&tribproto.PostTribbleArgs{"alice", "hi"}, &post)
+  if err == nil && post.Status == tribproto.OK {
+    t.Errorf("post succeeded without an id")
+  }
+}
+
+/**@brief a tribble whose entry could not be stored is not listed in the
+ *        timeline of its author
+ * @param t
+ * @return void
+ */
+func TestPostTribblePutFails(t *testing.T) {
+  var post tribproto.PostTribbleReply
+
+  ts, store := newTestServer(t)
+  mustCreate(t, ts, "alice")
+  first := mustPost(t, ts, "alice", "first")
+
+  store.SetHook(func(op, key string) error {
+    if op == "Put" {
+      return libstore.MakeErr("Put()", storageproto.EPUTFAILED)
+    }
+    return nil
+  })
+  err := ts.PostTribble(&tribproto.PostTribbleArgs{"alice", "lost"}, &post)
+  if err == nil && post.Status == tribproto.OK {
+    t.Errorf("post succeeded without its entry")
+  }
+  store.SetHook(nil)
+
+  ids, err := store.GetList("alice:T")
+  if err != nil {
+    t.Fatal(err)
+  }
+  expectIds(t, "timeline list of alice", ids, first)
+}
+
+/**@brief subscriptions are added once, to existing users, and removed;
+ *        the feed follows them
+ * @param t
+ * @return void
+ */
+func TestSubscriptions(t *testing.T) {
+  var sub tribproto.SubscriptionReply
+  var subs tribproto.GetSubscriptionsReply
+  var feed tribproto.GetTribblesReply
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob", "carol")
+  mustFollow(t, ts, "alice", "bob", "carol")
+
+  err := ts.AddSubscription(
+      &tribproto.SubscriptionArgs{"alice", "bob"}, &sub)
+  if err != nil || sub.Status != tribproto.EEXISTS {
+    t.Errorf("second subscription: %d, %v", sub.Status, err)
+  }
+  err = ts.AddSubscription(
+      &tribproto.SubscriptionArgs{"alice", "nobody"}, &sub)
+  if err != nil || sub.Status != tribproto.ENOSUCHTARGETUSER {
+    t.Errorf("subscription to an unknown user: %d, %v", sub.Status, err)
+  }
+  err = ts.AddSubscription(
+      &tribproto.SubscriptionArgs{"nobody", "bob"}, &sub)
+  if err != nil || sub.Status != tribproto.ENOSUCHUSER {
+    t.Errorf("subscription of an unknown user: %d, %v", sub.Status, err)
+  }
+
+  err = ts.GetSubscriptions(&tribproto.GetSubscriptionsArgs{"alice"}, &subs)
+  if err != nil || !reflect.DeepEqual(subs.Userids,
+      []string{"bob", "carol"}) {
+    t.Errorf("subscriptions of alice = %v, %v", subs.Userids, err)
+  }
+
+  fromBob := mustPost(t, ts, "bob", "from bob")
+  fromCarol := mustPost(t, ts, "carol", "from carol")
+  mustPost(t, ts, "alice", "own tribbles are not in the feed")
+
+  args := tribproto.GetTribblesArgs{"alice", 0, ""}
+  err = ts.GetTribblesBySubscription(&args, &feed)
+  if err != nil {
+    t.Fatal(err)
+  }
+  expectIds(t, "feed", idsOf(feed.Tribbles), fromCarol, fromBob)
+
+  err = ts.RemoveSubscription(
+      &tribproto.SubscriptionArgs{"alice", "carol"}, &sub)
+  if err != nil || sub.Status != tribproto.OK {
+    t.Fatalf("RemoveSubscription: %d, %v", sub.Status, err)
+  }
+  err = ts.RemoveSubscription(
+      &tribproto.SubscriptionArgs{"alice", "carol"}, &sub)
+  if err != nil || sub.Status != tribproto.ENOSUCHTARGETUSER {
+    t.Errorf("second RemoveSubscription: %d, %v", sub.Status, err)
+  }
+
+  err = ts.GetTribblesBySubscription(&args, &feed)
+  if err != nil {
+    t.Fatal(err)
+  }
+  expectIds(t, "feed after unsubscribing", idsOf(feed.Tribbles), fromBob)
+}
+
+/**@brief a timeline and a feed page through every tribble once, newest
+ *        first
+ * @param t
+ * @return void
+ */
+func TestPagination(t *testing.T) {
+  var want []string
+
+  ts, _ := newTestServer(t)
+  mustCreate(t, ts, "alice", "bob", "carol")
+  mustFollow(t, ts, "carol", "alice", "bob")
+  for i := 0; i < 7; i++ {
+    want = append([]string{mustPost(t, ts, "alice", "a")}, want...)
+  }
+
+  pages := allPages(t, ts.GetTribbles, "alice", 3)
+  if len(pages) != 3 || len(pages[0]) != 3 || len(pages[2]) != 1 {
+    t.Errorf("pages of alice = %v", pages)
+  }
+  var got []string
+  for _, page := range pages {
+    got = append(got, page...)
+  }
+  expectIds(t, "timeline of alice", got, want...)
+
+  for i := 0; i < 4; i++ {
+    want = append([]string{mustPost(t, ts, "bob", "b")}, want...)
+  }
+  got = nil
+  for _, page := range allPages(t, ts.GetTribblesBySubscription,
+      "carol", 4) {
+    got = append(got, page...)
+  }
+  expectIds(t, "feed of carol", got, want...)
+}
+
+/**@brief a tribserver is not created when storage cannot be reached, so
+ *        callers can refuse to start instead of using a nil server
+ * @param t
+ * @return void
+ */
+func TestNewTribserverUnreachable(t *testing.T) {
+  if ts := NewTribserverHome("localhost:1", "localhost:0", 0, 5); ts != nil {
+    t.Errorf("tribserver created without storage")
+  }
+}
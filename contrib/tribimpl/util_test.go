@@ -0,0 +1,217 @@
+/** @file util_test.go
+ *  @brief helpers for the tribimpl tests, which run a tribserver on top of
+ *         the in-memory fakestore
+ *  @author Andrin(atrejo) Dalong CHENG(dalongc)
+ *  @date 2012-10-23
+ */
+package tribimpl
+
+import (
+  "reflect"
+  "testing"
+  "time"
+  "P2-f12/contrib/fakestore"
+  "P2-f12/official/tribproto"
+)
+
+/**@brief a tribserver on an empty fakestore
+ * @param t
+ * @return *Tribserver
+ * @return *fakestore.FakeStore
+ */
+func newTestServer(t *testing.T) (*Tribserver, *fakestore.FakeStore) {
+  store := fakestore.NewFakeStore()
+  return NewTribserverWithStore(store), store
+}
+
+/**@brief create users, failing the test on any error
+ * @param t
+ * @param ts
+ * @param userids
+ * @return void
+ */
+func mustCreate(t *testing.T, ts *Tribserver, userids ...string) {
+  t.Helper()
+  for _, userid := range userids {
+    var reply tribproto.CreateUserReply
+    err := ts.CreateUser(&tribproto.CreateUserArgs{userid}, &reply)
+    if err != nil || reply.Status != tribproto.OK {
+      t.Fatalf("CreateUser(%s): %d, %v", userid, reply.Status, err)
+    }
+  }
+}
+
+/**@brief subscribe userid to targets, failing the test on any error
+ * @param t
+ * @param ts
+ * @param userid
+ * @param targets
+ * @return void
+ */
+func mustFollow(t *testing.T, ts *Tribserver, userid string,
+    targets ...string) {
+  t.Helper()
+  for _, target := range targets {
+    var reply tribproto.SubscriptionReply
+    err := ts.AddSubscription(
+        &tribproto.SubscriptionArgs{userid, target}, &reply)
+    if err != nil || reply.Status != tribproto.OK {
+      t.Fatalf("AddSubscription(%s, %s): %d, %v",
+          userid, target, reply.Status, err)
+    }
+  }
+}
+
+/**@brief post a tribble, failing the test on any error
+ * @param t
+ * @param ts
+ * @param userid
+ * @param contents
+ * @return string id of the tribble
+ */
+func mustPost(t *testing.T, ts *Tribserver, userid, contents string) string {
+  t.Helper()
+  var reply tribproto.PostTribbleReply
+  err := ts.PostTribble(
+      &tribproto.PostTribbleArgs{userid, contents}, &reply)
+  if err != nil || reply.Status != tribproto.OK {
+    t.Fatalf("PostTribble(%s): %d, %v", userid, reply.Status, err)
+  }
+  return reply.Id
+}
+
+/**@brief contents of tribbles, for comparing pages
+ * @param tribs
+ * @return []string
+ */
+func contentsOf(tribs []tribproto.Tribble) []string {
+  var contents []string = []string{}
+  for _, trib := range tribs {
+    contents = append(contents, trib.Contents)
+  }
+  return contents
+}
+
+/**@brief ids of tribbles, for comparing pages
+ * @param tribs
+ * @return []string
+ */
+func idsOf(tribs []tribproto.Tribble) []string {
+  var ids []string = []string{}
+  for _, trib := range tribs {
+    ids = append(ids, trib.Id)
+  }
+  return ids
+}
+
+/**@brief fail the test unless got lists the ids in want
+ * @param t
+ * @param what described in the failure
+ * @param got
+ * @param want
+ * @return void
+ */
+func expectIds(t *testing.T, what string, got []string, want ...string) {
+  t.Helper()
+  if want == nil {
+    want = []string{}
+  }
+  if !reflect.DeepEqual(got, want) {
+    t.Errorf("%s = %v, want %v", what, got, want)
+  }
+}
+
+/**@brief every page of a listing, following NextCursor to the end
+ * @param t
+ * @param get one listing call
+ * @param userid
+ * @param limit
+ * @return [][]string ids per page
+ */
+func allPages(t *testing.T,
+    get func(*tribproto.GetTribblesArgs, *tribproto.GetTribblesReply) error,
+    userid string, limit int) [][]string {
+  t.Helper()
+  var pages [][]string
+
+  args := tribproto.GetTribblesArgs{userid, limit, ""}
+  for {
+    var reply tribproto.GetTribblesReply
+    err := get(&args, &reply)
+    if err != nil || reply.Status != tribproto.OK || len(pages) > 100 {
+      t.Fatalf("page %d of %s: %d, %v", len(pages), userid,
+          reply.Status, err)
+    }
+    pages = append(pages, idsOf(reply.Tribbles))
+    if reply.NextCursor == "" {
+      return pages
+    }
+    args.Cursor = reply.NextCursor
+  }
+}
+
+/**@brief wait up to a second for background jobs to make cond true
+ * @param t
+ * @param what described in the failure
+ * @param cond
+ * @return void
+ */
+func eventually(t *testing.T, what string, cond func() bool) {
+  t.Helper()
+  for deadline := time.Now().Add(time.Second); !cond(); {
+    if time.Now().After(deadline) {
+      t.Fatalf("timed out waiting for %s", what)
+    }
+    time.Sleep(5 * time.Millisecond)
+  }
+}
+
+/**@brief ids of the first page of mentions of userid
+ * @param t
+ * @param ts
+ * @param userid
+ * @return []string
+ */
+func mentionIds(t *testing.T, ts *Tribserver, userid string) []string {
+  t.Helper()
+  var reply tribproto.GetTribblesReply
+  err := ts.GetMentions(&tribproto.GetTribblesArgs{userid, 0, ""}, &reply)
+  if err != nil || reply.Status != tribproto.OK {
+    t.Fatalf("GetMentions(%s): %d, %v", userid, reply.Status, err)
+  }
+  return idsOf(reply.Tribbles)
+}
+
+/**@brief ids of the first page of tribbles tagged tag
+ * @param t
+ * @param ts
+ * @param tag
+ * @return []string
+ */
+func tagIds(t *testing.T, ts *Tribserver, tag string) []string {
+  t.Helper()
+  var reply tribproto.GetTribblesReply
+  err := ts.GetTribblesByHashtag(&tribproto.GetHashtagArgs{tag, 0, ""}, &reply)
+  if err != nil || reply.Status != tribproto.OK {
+    t.Fatalf("GetTribblesByHashtag(%s): %d, %v", tag, reply.Status, err)
+  }
+  return idsOf(reply.Tribbles)
+}
+
+/**@brief ids of the first page of search results for query
+ * @param t
+ * @param ts
+ * @param query
+ * @param all
+ * @return []string
+ */
+func searchIds(t *testing.T, ts *Tribserver, query string,
+    all bool) []string {
+  t.Helper()
+  var reply tribproto.GetTribblesReply
+  err := ts.SearchTribbles(&tribproto.SearchArgs{query, all, 0, ""}, &reply)
+  if err != nil || reply.Status != tribproto.OK {
+    t.Fatalf("SearchTribbles(%s): %d, %v", query, reply.Status, err)
+  }
+  return idsOf(reply.Tribbles)
+}
@@ -11,8 +11,6 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"net/rpc"
-	"net/http"
 	"os"
 	"time"
 )
@@ -78,15 +76,8 @@ func main() {
 	leaseCBaddr := ""
 	flags := 0
 	if *handleLeases {
-		l, e := net.Listen("tcp", ":0")
-		if e != nil {
-			log.Fatal("listen error:", e)
-		}
-		_, listenport, _ := net.SplitHostPort(l.Addr().String())
-		leaseCBaddr = net.JoinHostPort("localhost", listenport)
-		log.Println("Starting HTTP server on ", listenport)
-		rpc.HandleHTTP()
-		go http.Serve(l, nil)
+		// libstore runs its own callback server, let it pick a port
+		leaseCBaddr = "localhost:0"
 	}
 
 	if *handleLeases && *forceLease {
@@ -96,6 +87,9 @@ func main() {
 	if err != nil {
 		log.Fatal("Could not create a libstore")
 	}
+	if *handleLeases {
+		log.Println("Lease callbacks on ", ls.Addr)
+	}
 
 
 	for i := 0; i < *numTimes; i++ {
@@ -65,6 +65,10 @@ func cleanupLibstore(l net.Listener) {
 	if l != nil {
 		l.Close()
 	}
+	// Stop the libstore's own lease callback listener
+	if ls != nil {
+		ls.Close()
+	}
 	// Recreate default http serve mux
 	http.DefaultServeMux = http.NewServeMux()
 	// Recreate default rpc server
@@ -164,7 +168,7 @@ func testNoLeases() {
 
 // Always request leases when flags is ALWAYS_LEASE
 func testAlwaysLeases() {
-	l := initLibstore(flag.Arg(0), fmt.Sprintf("localhost:%d", *portnum), fmt.Sprintf("localhost:%d", *portnum), libstore.ALWAYS_LEASE)
+	l := initLibstore(flag.Arg(0), fmt.Sprintf("localhost:%d", *portnum), "localhost:0", libstore.ALWAYS_LEASE)
 	if l == nil {
 		fmt.Fprintln(output, "FAIL: could not init libstore")
 		failCount++
@@ -1052,11 +1056,11 @@ func main() {
 		}
 	}
 
-	l := initLibstore(flag.Arg(0), fmt.Sprintf("localhost:%d", *portnum), fmt.Sprintf("localhost:%d", *portnum), libstore.NONE)
+	l := initLibstore(flag.Arg(0), fmt.Sprintf("localhost:%d", *portnum), "localhost:0", libstore.NONE)
 	if l == nil {
 		return
 	}
-	revokeConn, err = rpc.DialHTTP("tcp", fmt.Sprintf("localhost:%d", *portnum))
+	revokeConn, err = rpc.DialHTTP("tcp", ls.Addr)
 	if err != nil {
 		fmt.Println("Failed to connect to cacherpc")
 		return
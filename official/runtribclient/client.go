@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"flag"
 	"P2-f12/official/tribproto"
+	"P2-f12/contrib/transport"
 	"P2-f12/official/tribclient"
 	"strings"
 	//"time"
@@ -23,6 +24,10 @@ const (
 )
 
 var portnum *int = flag.Int("port", 9010, "server port # to connect to")
+var limit *int = flag.Int("limit", 0, "tl/ts/tt/ll/tm/tg/fs/fl: tribbles or followers per page, 0 for the server default")
+var cursor *string = flag.String("cursor", "", "tl/ts/tt/ll/tm/tg/fs/fl: next cursor printed by the previous page")
+var all *bool = flag.Bool("all", false, "fs: only tribbles containing every word")
+var wire *string = flag.String("transport", "http", "wire protocol of the server: http or binary")
 
 
 func main() {
@@ -36,16 +41,38 @@ func main() {
 	
 	serverAddress := "localhost"
 	serverPort := fmt.Sprintf("%d", *portnum)
-	client, _ := tribclient.NewTribbleclient(serverAddress, serverPort)
+	tr, err := transport.ByName(*wire)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := tribclient.NewTribbleclientTransport(serverAddress, serverPort, tr)
+	if err != nil {
+		log.Fatal("Could not connect to server:", err)
+	}
 
 	cmdlist := []cmd_info {
 		{ "uc", "Tribserver.CreateUser", 1 },
 		{ "sl", "Tribserver.GetSubscriptions", 1 },
 		{ "sa", "Tribserver.AddSubscription", 2 },
 		{ "sr", "Tribserver.RemoveSubscription", 2 },
+		{ "fl", "Tribserver.GetFollowers", 1 },
 		{ "tl", "Tribserver.GetTribbles", 1 },
 		{ "tp", "Tribserver.AddTribble", 2 },
 		{ "ts", "Tribserver.GetTribblesBySubscription", 1 },
+		{ "tr", "Tribserver.PostReply", 3 },
+		{ "tt", "Tribserver.GetThread", 1 },
+		{ "rt", "Tribserver.Retribble", 2 },
+		{ "tq", "Tribserver.QuoteTribble", 3 },
+		{ "lk", "Tribserver.LikeTribble", 2 },
+		{ "ul", "Tribserver.UnlikeTribble", 2 },
+		{ "lb", "Tribserver.GetLikes", 1 },
+		{ "ll", "Tribserver.GetLikedTribbles", 1 },
+		{ "tm", "Tribserver.GetMentions", 1 },
+		{ "tg", "Tribserver.GetTribblesByHashtag", 1 },
+		{ "fs", "Tribserver.SearchTribbles", 1 },
+		{ "td", "Tribserver.DeleteTribble", 2 },
+		{ "te", "Tribserver.EditTribble", 3 },
+		{ "th", "Tribserver.GetTribbleHistory", 1 },
 	}
 
 	cmdmap := make(map[string]cmd_info)
@@ -78,25 +105,107 @@ func main() {
 	case "sr":  // subscription remove
 		status, err := client.RemoveSubscription(flag.Arg(1), flag.Arg(2))
 		PrintStatus(ci.funcname, status, err)
+	case "fl":  // follower list
+		users, count, next, status, err := client.GetFollowers(flag.Arg(1), *limit, *cursor)
+		PrintStatus(ci.funcname, status, err)
+		if (err == nil && status == tribproto.OK) {
+			fmt.Printf("%d: %s\n", count, strings.Join(users, " "))
+			PrintNext(next)
+		}
 	case "tl":  // tribble list
-		tribbles, status, err := client.GetTribbles(flag.Arg(1))
+		tribbles, next, status, err := client.GetTribblesPage(flag.Arg(1), *limit, *cursor)
 		PrintStatus(ci.funcname, status, err)
 		if (err == nil && status == tribproto.OK) {
 			PrintTribbles(tribbles)
+			PrintNext(next)
 		}
 	case "ts":  // tribbles by subscription
-		tribbles, status, err := client.GetTribblesBySubscription(flag.Arg(1))
+		tribbles, next, status, err := client.GetTribblesBySubscriptionPage(flag.Arg(1), *limit, *cursor)
 		PrintStatus(ci.funcname, status, err)
 		if (err == nil && status == tribproto.OK) {
 			PrintTribbles(tribbles)
+			PrintNext(next)
 		}
 	case "tp":  // tribble post
 		status, err := client.PostTribble(flag.Arg(1), flag.Arg(2))
 		PrintStatus(ci.funcname, status, err)
+	case "tr":  // tribble reply
+		status, err := client.PostReply(flag.Arg(1), flag.Arg(2), flag.Arg(3))
+		PrintStatus(ci.funcname, status, err)
+	case "tt":  // tribble thread
+		root, replies, next, status, err := client.GetThread(flag.Arg(1), *limit, *cursor)
+		PrintStatus(ci.funcname, status, err)
+		if (err == nil && status == tribproto.OK) {
+			PrintTribble(root)
+			for _, t := range replies {
+				fmt.Printf("  > %s: ", t.ReplyTo)
+				PrintTribble(t)
+			}
+			PrintNext(next)
+		}
+	case "rt":  // retribble
+		status, err := client.Retribble(flag.Arg(1), flag.Arg(2))
+		PrintStatus(ci.funcname, status, err)
+	case "tq":  // tribble quote
+		status, err := client.QuoteTribble(flag.Arg(1), flag.Arg(2), flag.Arg(3))
+		PrintStatus(ci.funcname, status, err)
+	case "lk":  // like
+		status, err := client.LikeTribble(flag.Arg(1), flag.Arg(2))
+		PrintStatus(ci.funcname, status, err)
+	case "ul":  // unlike
+		status, err := client.UnlikeTribble(flag.Arg(1), flag.Arg(2))
+		PrintStatus(ci.funcname, status, err)
+	case "lb":  // liked by
+		users, status, err := client.GetLikes(flag.Arg(1))
+		PrintStatus(ci.funcname, status, err)
+		if (err == nil && status == tribproto.OK) {
+			fmt.Printf("%d: %s\n", len(users), strings.Join(users, " "))
+		}
+	case "ll":  // liked tribble list
+		tribbles, next, status, err := client.GetLikedTribblesPage(flag.Arg(1), *limit, *cursor)
+		PrintStatus(ci.funcname, status, err)
+		if (err == nil && status == tribproto.OK) {
+			PrintTribbles(tribbles)
+			PrintNext(next)
+		}
+	case "tm":  // tribbles mentioning the user
+		tribbles, next, status, err := client.GetMentionsPage(flag.Arg(1), *limit, *cursor)
+		PrintStatus(ci.funcname, status, err)
+		if (err == nil && status == tribproto.OK) {
+			PrintTribbles(tribbles)
+			PrintNext(next)
+		}
+	case "tg":  // tribbles with a hashtag
+		tribbles, next, status, err := client.GetTribblesByHashtagPage(flag.Arg(1), *limit, *cursor)
+		PrintStatus(ci.funcname, status, err)
+		if (err == nil && status == tribproto.OK) {
+			PrintTribbles(tribbles)
+			PrintNext(next)
+		}
+	case "fs":  // full text search, the words may be separate arguments
+		query := strings.Join(flag.Args()[1:], " ")
+		tribbles, next, status, err := client.SearchTribbles(query, *all, *limit, *cursor)
+		PrintStatus(ci.funcname, status, err)
+		if (err == nil && status == tribproto.OK) {
+			PrintTribbles(tribbles)
+			PrintNext(next)
+		}
+	case "td":  // tribble delete
+		status, err := client.DeleteTribble(flag.Arg(1), flag.Arg(2))
+		PrintStatus(ci.funcname, status, err)
+	case "te":  // tribble edit
+		status, err := client.EditTribble(flag.Arg(1), flag.Arg(2), flag.Arg(3))
+		PrintStatus(ci.funcname, status, err)
+	case "th":  // tribble history
+		revisions, status, err := client.GetTribbleHistory(flag.Arg(1))
+		PrintStatus(ci.funcname, status, err)
+		if (err == nil && status == tribproto.OK) {
+			PrintTribbles(revisions)
+		}
 	}
 }
 
-// This is a little lazy, but there are only 4 entries...
+// This is a little lazy, but there are only 6 entries...
 func TribStatusToString(status int) string {
 	switch(status) {
 	case tribproto.OK:
@@ -106,7 +215,11 @@ func TribStatusToString(status int) string {
 	case tribproto.ENOSUCHTARGETUSER:
 		return "No such target user"
 	case tribproto.EEXISTS:
-		return "User already exists"
+		return "Already exists"
+	case tribproto.ENOSUCHTRIBBLE:
+		return "No such tribble"
+	case tribproto.ENOTOWNER:
+		return "Tribble belongs to another user"
 	}
 	return "Unknown error"
 }
@@ -120,8 +233,22 @@ func PrintStatus(cmdname string, status int, err error) {
 }
 
 func PrintTribble(t tribproto.Tribble) {
-	fmt.Printf("%16.16s - %s - %s\n",
-		t.Userid, t.Posted.String(), t.Contents)
+	edited := ""
+	if !t.Edited.IsZero() {
+		edited = " (edited)"
+	}
+	contents := t.Contents
+	if t.RepostOf != "" && t.Contents == "" {
+		contents = fmt.Sprintf("RT @%s: %s", t.RepostAuthor, t.Reposted)
+	} else if t.RepostOf != "" {
+		contents = fmt.Sprintf("%s \"@%s: %s\"", t.Contents, t.RepostAuthor, t.Reposted)
+	}
+	likes := ""
+	if t.Likes > 0 {
+		likes = fmt.Sprintf(" (%d likes)", t.Likes)
+	}
+	fmt.Printf("%16.16s - %s - [%s] %s%s%s\n",
+		t.Userid, t.Posted.String(), t.Id, contents, edited, likes)
 	
 }
 
@@ -130,3 +257,9 @@ func PrintTribbles(tribbles []tribproto.Tribble) {
 		PrintTribble(t)
 	}
 }
+
+func PrintNext(next string) {
+	if next != "" {
+		fmt.Printf("more: -cursor=%s\n", next)
+	}
+}
@@ -18,6 +18,11 @@ const (
 	LEASE_GUARD_SECONDS = 2  // And the server should wait an extra 2
 )
 
+// Compression
+const (
+	COMPRESS_THRESHOLD = 1024 // replies with more bytes of data may be packed
+)
+
 type LeaseStruct struct {
 	Granted bool
 	ValidSeconds int
@@ -27,18 +32,28 @@ type GetArgs struct {
 	Key string
 	WantLease bool
 	LeaseClient string // host:port of client that wants lease, for callback
+	Compress bool // client accepts Packed replies
 }
 
+// Version counts the writes to a key, 0 if it was never written.
+// If Compressed is set Value is empty and Packed holds the data, see
+// contrib/packing.
 type GetReply struct {
 	Status int
 	Value string
 	Lease LeaseStruct
+	Version uint64
+	Compressed bool
+	Packed []byte
 }
 
 type GetListReply struct {
 	Status int
 	Value []string
 	Lease LeaseStruct
+	Version uint64
+	Compressed bool
+	Packed []byte
 }
 
 type PutArgs struct {
@@ -48,6 +63,7 @@ type PutArgs struct {
 
 type PutReply struct {
 	Status int
+	Version uint64 // version of the key after this write
 }
 
 type Node struct {
@@ -5,7 +5,9 @@ package main
 // DO NOT MODIFY THIS FILE FOR YOUR PROJECT
 
 import (
+	"P2-f12/contrib/grpcserver"
 	"P2-f12/contrib/storageimpl" // 'official' vs 'contrib' here
+	"P2-f12/contrib/transport"
 	"P2-f12/official/storagerpc"
 	"flag"
 	"fmt"
@@ -20,6 +22,8 @@ var portnum *int = flag.Int("port", 0, "port # to listen on.  Non-master nodes d
 var storageMasterNodePort *string = flag.String("master", "", "Specify the storage master node, making this node a slave.  Defaults to its own port (self-mastering).")
 var numNodes *int = flag.Int("N", 0, "Become the master.  Specifies the number of nodes in the system, including the master.")
 var nodeID *uint = flag.Uint("id", 0, "The node ID to use for consistent hashing.  Should be a 32 bit number.")
+var grpcPort *int = flag.Int("grpc", 0, "Also serve StorageRPC over gRPC on this port.  0 disables gRPC.")
+var wire *string = flag.String("transport", "http", "Wire protocol: http (net/rpc over HTTP) or binary (length-prefixed TCP).  All nodes and clients must agree.")
 
 func main() {
 	flag.Parse()
@@ -35,6 +39,11 @@ func main() {
 		}
 	}
 
+	tr, e := transport.ByName(*wire)
+	if e != nil {
+		log.Fatal(e)
+	}
+
 	l, e := net.Listen("tcp", fmt.Sprintf(":%d", *portnum))
 	if e != nil {
 		log.Fatal("listen error:", e)
@@ -42,8 +51,23 @@ func main() {
 	_, listenport, _ := net.SplitHostPort(l.Addr().String())
 	log.Println("Server starting on ", listenport)
 	*portnum, _ = strconv.Atoi(listenport)
-	ss := storageimpl.NewStorageserver(*storageMasterNodePort, *numNodes, *portnum, uint32(*nodeID))
+	ss := storageimpl.NewStorageserverTransport(*storageMasterNodePort, *numNodes, *portnum, uint32(*nodeID), tr)
 	srpc := storagerpc.NewStorageRPC(ss)
+	if *grpcPort != 0 {
+		gs := grpcserver.NewServer()
+		gs.Register(srpc)
+		gl, e := net.Listen("tcp", fmt.Sprintf(":%d", *grpcPort))
+		if e != nil {
+			log.Fatal("grpc listen error:", e)
+		}
+		go gs.Serve(gl)
+	}
+	if *wire == "binary" {
+		srv := rpc.NewServer()
+		srv.Register(srpc)
+		transport.ServeBinary(l, srv)
+		return
+	}
 	rpc.Register(srpc)
 	rpc.HandleHTTP()
 	http.Serve(l, nil)
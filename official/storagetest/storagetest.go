@@ -106,14 +106,14 @@ func (st *StorageTester) Put(key, value string) (*storageproto.PutReply, error)
 }
 
 func (st *StorageTester) Get(key string, wantlease bool) (*storageproto.GetReply, error) {
-	args := &storageproto.GetArgs{key, wantlease, st.myhostport}
+	args := &storageproto.GetArgs{key, wantlease, st.myhostport, false}
 	var reply storageproto.GetReply
 	err := st.srv.Call("StorageRPC.Get", args, &reply)
 	return &reply, err
 }
 
 func (st *StorageTester) GetList(key string, wantlease bool) (*storageproto.GetListReply, error) {
-	args := &storageproto.GetArgs{key, wantlease, st.myhostport}
+	args := &storageproto.GetArgs{key, wantlease, st.myhostport, false}
 	var reply storageproto.GetListReply
 	err := st.srv.Call("StorageRPC.GetList", args, &reply)
 	return &reply, err
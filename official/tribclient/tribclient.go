@@ -4,6 +4,7 @@ import (
 	"log"
 	"net"
 	"net/rpc"
+	"P2-f12/contrib/transport"
 	"P2-f12/official/tribproto"
 )
 
@@ -15,7 +16,7 @@ import (
 type Tribbleclient struct {
 	serverAddress string
 	serverPort    string
-	client        *rpc.Client
+	client        transport.Caller
 }
 
 func NewTribbleclient(serverAddress string, serverPort string) (*Tribbleclient, error) {
@@ -26,6 +27,16 @@ func NewTribbleclient(serverAddress string, serverPort string) (*Tribbleclient,
 	return &Tribbleclient{serverAddress, serverPort, client}, nil
 }
 
+// NewTribbleclientTransport connects over tr, e.g. transport.Binary{} for
+// a tribserver started with -transport=binary.
+func NewTribbleclientTransport(serverAddress string, serverPort string, tr transport.Transport) (*Tribbleclient, error) {
+	client, err := tr.Dial(net.JoinHostPort(serverAddress, serverPort))
+	if err != nil {
+		return nil, err
+	}
+	return &Tribbleclient{serverAddress, serverPort, client}, nil
+}
+
 func (tc *Tribbleclient) Close() {
 	tc.client.Close()
 }
@@ -50,6 +61,18 @@ func (tc *Tribbleclient) GetSubscriptions(Userid string) ([]string, int, error)
 	return reply.Userids, reply.Status, nil
 }
 
+// GetFollowers returns up to limit users subscribed to Userid, latest
+// first, their total count and the cursor of the next page ("" at the end).
+func (tc *Tribbleclient) GetFollowers(Userid string, limit int, cursor string) ([]string, int, string, int, error) {
+	args := &tribproto.GetFollowersArgs{Userid, limit, cursor}
+	var reply tribproto.GetFollowersReply
+	err := tc.client.Call("Tribserver.GetFollowers", args, &reply)
+	if err != nil {
+		return nil, 0, "", 0, err
+	}
+	return reply.Userids, reply.Count, reply.NextCursor, reply.Status, nil
+}
+
 func (tc *Tribbleclient) dosub(funcname, Userid, Targetuser string) (int, error) {
 	args := &tribproto.SubscriptionArgs{Userid, Targetuser}
 	var reply tribproto.SubscriptionReply
@@ -77,13 +100,28 @@ func (tc *Tribbleclient) GetTribblesBySubscription(Userid string) ([]tribproto.T
 }
 
 func (tc *Tribbleclient) dotrib(funcname, Userid string) ([]tribproto.Tribble, int, error) {
-	args := &tribproto.GetTribblesArgs{Userid}
+	tribbles, _, status, err := tc.dotribPage(funcname, Userid, 0, "")
+	return tribbles, status, err
+}
+
+// GetTribblesPage returns up to limit tribbles older than cursor, newest
+// first, and the cursor of the next page ("" at the end).
+func (tc *Tribbleclient) GetTribblesPage(Userid string, limit int, cursor string) ([]tribproto.Tribble, string, int, error) {
+	return tc.dotribPage("Tribserver.GetTribbles", Userid, limit, cursor)
+}
+
+func (tc *Tribbleclient) GetTribblesBySubscriptionPage(Userid string, limit int, cursor string) ([]tribproto.Tribble, string, int, error) {
+	return tc.dotribPage("Tribserver.GetTribblesBySubscription", Userid, limit, cursor)
+}
+
+func (tc *Tribbleclient) dotribPage(funcname, Userid string, limit int, cursor string) ([]tribproto.Tribble, string, int, error) {
+	args := &tribproto.GetTribblesArgs{Userid, limit, cursor}
 	var reply tribproto.GetTribblesReply
 	err := tc.client.Call(funcname, args, &reply)
 	if err != nil {
-		return nil, 0, err
+		return nil, "", 0, err
 	}
-	return reply.Tribbles, reply.Status, nil
+	return reply.Tribbles, reply.NextCursor, reply.Status, nil
 }
 
 func (tc *Tribbleclient) PostTribble(Userid, Contents string) (int, error) {
@@ -95,3 +133,142 @@ func (tc *Tribbleclient) PostTribble(Userid, Contents string) (int, error) {
 	}
 	return reply.Status, nil
 }
+
+func (tc *Tribbleclient) DeleteTribble(Userid, Id string) (int, error) {
+	args := &tribproto.DeleteTribbleArgs{Userid, Id}
+	var reply tribproto.DeleteTribbleReply
+	err := tc.client.Call("Tribserver.DeleteTribble", args, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return reply.Status, nil
+}
+
+func (tc *Tribbleclient) EditTribble(Userid, Id, Contents string) (int, error) {
+	args := &tribproto.EditTribbleArgs{Userid, Id, Contents}
+	var reply tribproto.EditTribbleReply
+	err := tc.client.Call("Tribserver.EditTribble", args, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return reply.Status, nil
+}
+
+// GetTribbleHistory returns every revision of a tribble, oldest first.
+func (tc *Tribbleclient) GetTribbleHistory(Id string) ([]tribproto.Tribble, int, error) {
+	args := &tribproto.GetTribbleHistoryArgs{Id}
+	var reply tribproto.GetTribbleHistoryReply
+	err := tc.client.Call("Tribserver.GetTribbleHistory", args, &reply)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reply.Revisions, reply.Status, nil
+}
+
+func (tc *Tribbleclient) PostReply(Userid, ReplyTo, Contents string) (int, error) {
+	args := &tribproto.PostReplyArgs{Userid, ReplyTo, Contents}
+	var reply tribproto.PostTribbleReply
+	err := tc.client.Call("Tribserver.PostReply", args, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return reply.Status, nil
+}
+
+// GetThread returns the tribble that started the conversation of Id and up
+// to limit of its replies after cursor, oldest first, and the cursor of the
+// next page ("" at the end).
+func (tc *Tribbleclient) GetThread(Id string, limit int, cursor string) (tribproto.Tribble, []tribproto.Tribble, string, int, error) {
+	args := &tribproto.GetThreadArgs{Id, limit, cursor}
+	var reply tribproto.GetThreadReply
+	err := tc.client.Call("Tribserver.GetThread", args, &reply)
+	if err != nil {
+		return tribproto.Tribble{}, nil, "", 0, err
+	}
+	return reply.Root, reply.Replies, reply.NextCursor, reply.Status, nil
+}
+
+func (tc *Tribbleclient) Retribble(Userid, Id string) (int, error) {
+	args := &tribproto.RetribbleArgs{Userid, Id}
+	var reply tribproto.PostTribbleReply
+	err := tc.client.Call("Tribserver.Retribble", args, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return reply.Status, nil
+}
+
+func (tc *Tribbleclient) QuoteTribble(Userid, Id, Contents string) (int, error) {
+	args := &tribproto.QuoteTribbleArgs{Userid, Id, Contents}
+	var reply tribproto.PostTribbleReply
+	err := tc.client.Call("Tribserver.QuoteTribble", args, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return reply.Status, nil
+}
+
+func (tc *Tribbleclient) dolike(funcname, Userid, Id string) (int, error) {
+	args := &tribproto.LikeArgs{Userid, Id}
+	var reply tribproto.LikeReply
+	err := tc.client.Call(funcname, args, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return reply.Status, nil
+}
+
+func (tc *Tribbleclient) LikeTribble(Userid, Id string) (int, error) {
+	return tc.dolike("Tribserver.LikeTribble", Userid, Id)
+}
+
+func (tc *Tribbleclient) UnlikeTribble(Userid, Id string) (int, error) {
+	return tc.dolike("Tribserver.UnlikeTribble", Userid, Id)
+}
+
+func (tc *Tribbleclient) GetLikes(Id string) ([]string, int, error) {
+	args := &tribproto.GetLikesArgs{Id}
+	var reply tribproto.GetLikesReply
+	err := tc.client.Call("Tribserver.GetLikes", args, &reply)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reply.Userids, reply.Status, nil
+}
+
+// GetLikedTribblesPage returns up to limit tribbles Userid likes, latest
+// like first, and the cursor of the next page ("" at the end).
+func (tc *Tribbleclient) GetLikedTribblesPage(Userid string, limit int, cursor string) ([]tribproto.Tribble, string, int, error) {
+	return tc.dotribPage("Tribserver.GetLikedTribbles", Userid, limit, cursor)
+}
+
+// GetMentionsPage returns up to limit tribbles mentioning @Userid, newest
+// first, and the cursor of the next page ("" at the end).
+func (tc *Tribbleclient) GetMentionsPage(Userid string, limit int, cursor string) ([]tribproto.Tribble, string, int, error) {
+	return tc.dotribPage("Tribserver.GetMentions", Userid, limit, cursor)
+}
+
+// GetTribblesByHashtagPage returns up to limit tribbles of all users tagged
+// with Tag, newest first, and the cursor of the next page ("" at the end).
+func (tc *Tribbleclient) GetTribblesByHashtagPage(Tag string, limit int, cursor string) ([]tribproto.Tribble, string, int, error) {
+	args := &tribproto.GetHashtagArgs{Tag, limit, cursor}
+	var reply tribproto.GetTribblesReply
+	err := tc.client.Call("Tribserver.GetTribblesByHashtag", args, &reply)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return reply.Tribbles, reply.NextCursor, reply.Status, nil
+}
+
+// SearchTribbles returns up to limit tribbles matching query, those with
+// more of its words and then the newer ones first, and the cursor of the
+// next page ("" at the end).  With all set every word must match.
+func (tc *Tribbleclient) SearchTribbles(query string, all bool, limit int, cursor string) ([]tribproto.Tribble, string, int, error) {
+	args := &tribproto.SearchArgs{query, all, limit, cursor}
+	var reply tribproto.GetTribblesReply
+	err := tc.client.Call("Tribserver.SearchTribbles", args, &reply)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return reply.Tribbles, reply.NextCursor, reply.Status, nil
+}
@@ -10,12 +10,25 @@ const (
 	ENOSUCHUSER
 	ENOSUCHTARGETUSER
 	EEXISTS // for Create user and AddSubscription
+	ENOSUCHTRIBBLE // unknown or deleted tribble id
+	ENOTOWNER      // the tribble was posted by another user
 )
 
 type Tribble struct {
 	Userid   string // user who posted the Tribble
 	Posted   time.Time
 	Contents string
+	Id       string    // storage id "<userid>:<n>", names the tribble in later requests
+	Edited   time.Time // time of the last edit, zero if never edited
+	Deleted  bool      // tombstone left behind by DeleteTribble
+	ReplyTo  string    // id of the tribble this one answers, "" if none
+	Thread   string    // id of the tribble that started the conversation
+
+	RepostOf     string // id of the retribbled or quoted tribble, "" if none
+	RepostAuthor string // its author
+	Reposted     string `json:"-"` // its contents when read, a retribble has no own
+
+	Likes int `json:"-"` // users liking it when read, those of the original for a retribble
 }
 
 type CreateUserArgs struct {
@@ -33,6 +46,83 @@ type PostTribbleArgs struct {
 
 type PostTribbleReply struct {
 	Status int
+	Id     string // id of the new tribble
+}
+
+type PostReplyArgs struct {
+	Userid   string
+	ReplyTo  string // id of the tribble answered
+	Contents string
+}
+
+type RetribbleArgs struct {
+	Userid string
+	Id     string // tribble to repost
+}
+
+type QuoteTribbleArgs struct {
+	Userid   string
+	Id       string // tribble to quote
+	Contents string // comment on it
+}
+
+type LikeArgs struct { // For both LikeTribble and UnlikeTribble
+	Userid string
+	Id     string
+}
+
+type LikeReply struct {
+	Status int
+}
+
+type GetLikesArgs struct {
+	Id string
+}
+
+type GetLikesReply struct {
+	Status  int
+	Userids []string // users liking the tribble, oldest like first
+}
+
+type GetThreadArgs struct {
+	Id     string // any tribble of the conversation
+	Limit  int    // page size, 0 for the default of 100
+	Cursor string // NextCursor of the previous page, "" for the first replies
+}
+
+type GetThreadReply struct {
+	Status     int
+	Root       Tribble   // tribble that started the conversation
+	Replies    []Tribble // oldest first
+	NextCursor string    // "" if there are no newer replies
+}
+
+type DeleteTribbleArgs struct {
+	Userid string // must be the author
+	Id     string
+}
+
+type DeleteTribbleReply struct {
+	Status int
+}
+
+type EditTribbleArgs struct {
+	Userid   string // must be the author
+	Id       string
+	Contents string
+}
+
+type EditTribbleReply struct {
+	Status int
+}
+
+type GetTribbleHistoryArgs struct {
+	Id string
+}
+
+type GetTribbleHistoryReply struct {
+	Status    int
+	Revisions []Tribble // oldest first, the current contents last
 }
 
 type SubscriptionArgs struct { // For both and add remove
@@ -53,11 +143,40 @@ type GetSubscriptionsReply struct {
 	Userids []string
 }
 
-type GetTribblesArgs struct { // Used for both GetTribbles and GetTribblesBySubscription
+type GetFollowersArgs struct {
+	Userid string
+	Limit  int    // page size, 0 for the default of 100
+	Cursor string // NextCursor of the previous page, "" for the newest
+}
+
+type GetFollowersReply struct {
+	Status     int
+	Userids    []string // users subscribed to Userid, latest first
+	Count      int      // followers in all pages
+	NextCursor string   // "" if there are no older followers
+}
+
+type GetTribblesArgs struct { // Used for GetTribbles, GetTribblesBySubscription and the other tribble listings
 	Userid string
+	Limit  int    // page size, 0 for the default of 100
+	Cursor string // NextCursor of the previous page, "" for the newest
+}
+
+type GetHashtagArgs struct {
+	Tag    string // with or without the #
+	Limit  int
+	Cursor string
+}
+
+type SearchArgs struct {
+	Query  string // words to look for in tribble contents
+	All    bool   // only tribbles containing every word
+	Limit  int
+	Cursor string
 }
 
 type GetTribblesReply struct {
-	Status   int
-	Tribbles []Tribble
+	Status     int
+	Tribbles   []Tribble
+	NextCursor string // "" if there are no older tribbles
 }
@@ -11,12 +11,51 @@ import (
 	"net/http"
 	"net/rpc"
 	"log"
+  "P2-f12/contrib/grpcserver"
+  "P2-f12/contrib/libstore"
+  "P2-f12/contrib/transport"
   "P2-f12/contrib/tribimpl"
 )
 
 
 
 var portnum *int = flag.Int("port", 9010, "port # to listen on")
+var exportStats *bool = flag.Bool("stats", false, "Serve libstore cache statistics as JSON at /stats")
+var grpcPort *int = flag.Int("grpc", 0, "Also serve Tribserver over gRPC on this port, 0 disables it")
+var wire *string = flag.String("transport", "http", "Wire protocol for clients and storage: http or binary")
+var homeSize *int = flag.Int("home", 0, "Push tribbles to home timelines of this many tribbles each, 0 merges feeds on read")
+var statsPort *int = flag.Int("statsport", 0, "HTTP port for /stats when -transport=binary, 0 uses -port + 1")
+
+// serveGRPC serves ts over gRPC as well if -grpc is given.
+func serveGRPC(ts *tribimpl.Tribserver) {
+	if *grpcPort == 0 {
+		return
+	}
+	gs := grpcserver.NewServer()
+	gs.Register(ts)
+	l, e := net.Listen("tcp", fmt.Sprintf(":%d", *grpcPort))
+	if e != nil {
+		log.Fatal("grpc listen error:", e)
+	}
+	go gs.Serve(l)
+}
+
+// serveStats serves /stats on an HTTP listener of its own, for transports
+// that do not speak HTTP.
+func serveStats(ts *tribimpl.Tribserver) {
+	port := *statsPort
+	if port == 0 {
+		port = *portnum + 1
+	}
+	l, e := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if e != nil {
+		log.Fatal("stats listen error:", e)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/stats", ts.StatsHandler())
+	log.Printf("Serving /stats on port %d\n", port)
+	go http.Serve(l, mux)
+}
 
 func main() {
 	flag.Parse()
@@ -28,8 +67,32 @@ func main() {
 		log.Fatal("listen error:", e)
 	}
 	log.Printf("Server starting on port %d\n", *portnum);
-	ts := tribimpl.NewTribserver(flag.Arg(0), fmt.Sprintf("localhost:%d", *portnum))
+	var ts *tribimpl.Tribserver
+	if *wire == "binary" {
+		ts = tribimpl.NewTribserverHome(flag.Arg(0), fmt.Sprintf("localhost:%d", *portnum), libstore.BINARY_TRANSPORT, *homeSize)
+		if ts == nil {
+			log.Fatal("could not create tribserver")
+		}
+		serveGRPC(ts)
+		if *exportStats {
+			serveStats(ts)
+		}
+		srv := rpc.NewServer()
+		srv.Register(ts)
+		transport.ServeBinary(l, srv)
+		return
+	} else if *wire != "http" {
+		log.Fatal("unknown transport ", *wire)
+	}
+	ts = tribimpl.NewTribserverHome(flag.Arg(0), fmt.Sprintf("localhost:%d", *portnum), libstore.NONE, *homeSize)
+	if ts == nil {
+		log.Fatal("could not create tribserver")
+	}
+	serveGRPC(ts)
 	rpc.Register(ts)
 	rpc.HandleHTTP()
+	if *exportStats {
+		http.Handle("/stats", ts.StatsHandler())
+	}
 	http.Serve(l, nil)
 }
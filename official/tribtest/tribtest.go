@@ -63,6 +63,10 @@ func cleanupTribserver(l net.Listener) {
 	if l != nil {
 		l.Close()
 	}
+	// Stop the tribserver's libstore
+	if ts != nil {
+		ts.Close()
+	}
 	// Recreate default http serve mux
 	http.DefaultServeMux = http.NewServeMux()
 	// Recreate default rpc server
@@ -200,14 +204,14 @@ func postTribble(user string, contents string) (error, int) {
 }
 
 func getTribbles(user string) (error, int, []tribproto.Tribble) {
-	args := &tribproto.GetTribblesArgs{user}
+	args := &tribproto.GetTribblesArgs{Userid: user}
 	var reply tribproto.GetTribblesReply
 	err := ts.GetTribbles(args, &reply)
 	return err, reply.Status, reply.Tribbles
 }
 
 func getTribblesBySubscription(user string) (error, int, []tribproto.Tribble) {
-	args := &tribproto.GetTribblesArgs{user}
+	args := &tribproto.GetTribblesArgs{Userid: user}
 	var reply tribproto.GetTribblesReply
 	err := ts.GetTribblesBySubscription(args, &reply)
 	return err, reply.Status, reply.Tribbles